@@ -0,0 +1,276 @@
+package ren
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/foohq/urlpath"
+)
+
+// AuditEvent describes an operation a script performed through the runtime's
+// OS: a filesystem call, closing a file it opened, or a change of the working
+// directory or the environment, or a call to os.exit.
+type AuditEvent struct {
+	// Time is when the operation started.
+	Time time.Time
+	// Op is the name of the OS method called, e.g. "OpenFile", "Setenv" or
+	// "Exit". Closing a file opened with OpenFile is reported as "Close".
+	Op string
+	// Name is the absolute URL the operation applies to, e.g.
+	// "file:///data/in.txt", the variable for Setenv and Unsetenv and the
+	// status for Exit.
+	Name string
+	// Target is the new URL for Rename and Symlink and the directory created
+	// by MkdirTemp.
+	Target string
+	// Flag is the flag passed to OpenFile, e.g. os.O_WRONLY|os.O_CREATE.
+	Flag int
+	// Perm is the permission bits passed to Mkdir, MkdirAll, OpenFile and
+	// WriteFile.
+	Perm FileMode
+	// BytesRead is the number of bytes read by ReadFile, or from the file
+	// being closed.
+	BytesRead int64
+	// BytesWritten is the number of bytes written by WriteFile, or to the
+	// file being closed.
+	BytesWritten int64
+	// Duration is how long the operation took.
+	Duration time.Duration
+	// Err is the error the operation failed with, such as a *PolicyError.
+	Err error
+}
+
+// Auditor receives an AuditEvent for every operation a script performs. It is
+// called synchronously from the script, once the operation is over; a call to
+// os.exit is reported before the exit handler runs. Auditors must be safe for
+// concurrent use if scripts spawn goroutines.
+type Auditor func(AuditEvent)
+
+// NewJSONAuditor returns an Auditor that writes each event to w as a line of
+// JSON, with the duration in nanoseconds and the error as its message:
+//
+//	{"time":"2026-01-02T15:04:05.999Z","op":"WriteFile","name":"file:///out.txt","perm":420,"bytes_written":3,"duration":21000}
+//
+// Errors writing to w are ignored.
+func NewJSONAuditor(w io.Writer) Auditor {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(event AuditEvent) {
+		line := jsonAuditEvent{
+			Time:         event.Time,
+			Op:           event.Op,
+			Name:         event.Name,
+			Target:       event.Target,
+			Flag:         event.Flag,
+			Perm:         uint32(event.Perm),
+			BytesRead:    event.BytesRead,
+			BytesWritten: event.BytesWritten,
+			Duration:     int64(event.Duration),
+		}
+		if event.Err != nil {
+			line.Error = event.Err.Error()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		_ = enc.Encode(line)
+	}
+}
+
+type jsonAuditEvent struct {
+	Time         time.Time `json:"time"`
+	Op           string    `json:"op"`
+	Name         string    `json:"name,omitempty"`
+	Target       string    `json:"target,omitempty"`
+	Flag         int       `json:"flag,omitempty"`
+	Perm         uint32    `json:"perm,omitempty"`
+	BytesRead    int64     `json:"bytes_read,omitempty"`
+	BytesWritten int64     `json:"bytes_written,omitempty"`
+	Duration     int64     `json:"duration"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// auditURL returns the absolute URL name as reported in an AuditEvent, with
+// the file scheme osMiddleware leaves out.
+func auditURL(name string) string {
+	if scheme, err := urlpath.Scheme(name); err == nil && scheme == "" {
+		return "file://" + name
+	}
+	return name
+}
+
+var _ FS = (*auditFS)(nil)
+
+// auditFS reports every operation to an Auditor after passing it on to the
+// underlying filesystem. It expects absolute URLs, as produced by
+// osMiddleware.
+type auditFS struct {
+	fs      FS
+	auditor Auditor
+}
+
+func (f *auditFS) Mkdir(name string, perm FileMode) error {
+	event := f.start("Mkdir", name)
+	event.Perm = perm
+	err := f.fs.Mkdir(name, perm)
+	f.end(event, err)
+	return err
+}
+
+func (f *auditFS) MkdirAll(path string, perm FileMode) error {
+	event := f.start("MkdirAll", path)
+	event.Perm = perm
+	err := f.fs.MkdirAll(path, perm)
+	f.end(event, err)
+	return err
+}
+
+func (f *auditFS) MkdirTemp(dir, pattern string) (string, error) {
+	event := f.start("MkdirTemp", dir)
+	name, err := f.fs.MkdirTemp(dir, pattern)
+	if err == nil {
+		event.Target = auditURL(name)
+	}
+	f.end(event, err)
+	return name, err
+}
+
+// OpenFile reports the opening of the file and, once the script closes it,
+// the number of bytes read from and written to it.
+func (f *auditFS) OpenFile(name string, flag int, perm FileMode) (File, error) {
+	event := f.start("OpenFile", name)
+	event.Flag = flag
+	event.Perm = perm
+	file, err := f.fs.OpenFile(name, flag, perm)
+	f.end(event, err)
+	if err != nil {
+		return nil, err
+	}
+
+	audited := &auditFile{File: file, fs: f, name: name}
+	if seeker, ok := file.(io.Seeker); ok {
+		return &auditSeekFile{auditFile: audited, seeker: seeker}, nil
+	}
+	return audited, nil
+}
+
+func (f *auditFS) ReadFile(name string) ([]byte, error) {
+	event := f.start("ReadFile", name)
+	b, err := f.fs.ReadFile(name)
+	event.BytesRead = int64(len(b))
+	f.end(event, err)
+	return b, err
+}
+
+func (f *auditFS) Remove(name string) error {
+	event := f.start("Remove", name)
+	err := f.fs.Remove(name)
+	f.end(event, err)
+	return err
+}
+
+func (f *auditFS) RemoveAll(path string) error {
+	event := f.start("RemoveAll", path)
+	err := f.fs.RemoveAll(path)
+	f.end(event, err)
+	return err
+}
+
+func (f *auditFS) Rename(oldPath, newPath string) error {
+	event := f.start("Rename", oldPath)
+	event.Target = auditURL(newPath)
+	err := f.fs.Rename(oldPath, newPath)
+	f.end(event, err)
+	return err
+}
+
+func (f *auditFS) Stat(name string) (FileInfo, error) {
+	event := f.start("Stat", name)
+	info, err := f.fs.Stat(name)
+	f.end(event, err)
+	return info, err
+}
+
+func (f *auditFS) Symlink(oldName, newName string) error {
+	event := f.start("Symlink", oldName)
+	event.Target = auditURL(newName)
+	err := f.fs.Symlink(oldName, newName)
+	f.end(event, err)
+	return err
+}
+
+func (f *auditFS) WriteFile(name string, data []byte, perm FileMode) error {
+	event := f.start("WriteFile", name)
+	event.Perm = perm
+	err := f.fs.WriteFile(name, data, perm)
+	if err == nil {
+		event.BytesWritten = int64(len(data))
+	}
+	f.end(event, err)
+	return err
+}
+
+func (f *auditFS) ReadDir(name string) ([]DirEntry, error) {
+	event := f.start("ReadDir", name)
+	entries, err := f.fs.ReadDir(name)
+	f.end(event, err)
+	return entries, err
+}
+
+func (f *auditFS) start(op, name string) AuditEvent {
+	return AuditEvent{
+		Time: time.Now(),
+		Op:   op,
+		Name: auditURL(name),
+	}
+}
+
+func (f *auditFS) end(event AuditEvent, err error) {
+	event.Duration = time.Since(event.Time)
+	event.Err = err
+	f.auditor(event)
+}
+
+// auditFile counts the bytes read from and written to a file opened through
+// an auditFS, to report them when it is closed.
+type auditFile struct {
+	File
+	fs      *auditFS
+	name    string
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+func (f *auditFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.read.Add(int64(n))
+	return n, err
+}
+
+func (f *auditFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.written.Add(int64(n))
+	return n, err
+}
+
+func (f *auditFile) Close() error {
+	event := f.fs.start("Close", f.name)
+	err := f.File.Close()
+	event.BytesRead = f.read.Load()
+	event.BytesWritten = f.written.Load()
+	f.fs.end(event, err)
+	return err
+}
+
+// auditSeekFile is an auditFile whose underlying file supports seeking.
+type auditSeekFile struct {
+	*auditFile
+	seeker io.Seeker
+}
+
+func (f *auditSeekFile) Seek(offset int64, whence int) (int64, error) {
+	return f.seeker.Seek(offset, whence)
+}
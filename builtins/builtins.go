@@ -3,14 +3,16 @@
 
 // Package builtins defines the global functions available to every Ren script.
 // It re-exports a curated subset of Risor's built-ins and adds Ren-specific
-// ones such as import, print, printf, and the pack/unpack family. It also
-// registers the "utf16" encode/decode codec on import.
+// ones such as import, print, printf, eprint, eprintf, and the pack/unpack
+// family. It also registers the "utf16", "yaml" and "toml" encode/decode
+// codecs on import.
 package builtins
 
 import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"maps"
 
 	modbuiltins "github.com/deepnoodle-ai/risor/v2/pkg/builtins"
@@ -51,14 +53,37 @@ var builtins = map[string]*object.Builtin{
 	"unpack":   object.NewBuiltin("unpack", Unpack),
 	"print":    object.NewBuiltin("print", Print),
 	"printf":   object.NewBuiltin("printf", Printf),
+	"eprint":   object.NewBuiltin("eprint", Eprint),
+	"eprintf":  object.NewBuiltin("eprintf", Eprintf),
 }
 
 // Print writes its arguments to standard output separated by spaces and
 // followed by a newline. Strings and bytes are written verbatim; other values
 // are written via their Inspect representation. It accepts 1 to 64 arguments.
 func Print(ctx context.Context, args ...object.Object) (object.Object, error) {
+	return fprint(ren.GetOS(ctx).Stdout(), "print", args)
+}
+
+// Printf formats its trailing arguments according to the first (format-string)
+// argument and writes the result to standard output. It accepts 1 to 64
+// arguments.
+func Printf(ctx context.Context, args ...object.Object) (object.Object, error) {
+	return fprintf(ren.GetOS(ctx).Stdout(), "printf", args)
+}
+
+// Eprint is like Print but writes to standard error.
+func Eprint(ctx context.Context, args ...object.Object) (object.Object, error) {
+	return fprint(ren.GetOS(ctx).Stderr(), "eprint", args)
+}
+
+// Eprintf is like Printf but writes to standard error.
+func Eprintf(ctx context.Context, args ...object.Object) (object.Object, error) {
+	return fprintf(ren.GetOS(ctx).Stderr(), "eprintf", args)
+}
+
+func fprint(w io.Writer, name string, args []object.Object) (object.Object, error) {
 	if len(args) < 1 || len(args) > 64 {
-		return nil, object.NewArgsRangeError("print", 1, 64, len(args))
+		return nil, object.NewArgsRangeError(name, 1, 64, len(args))
 	}
 	var b bytes.Buffer
 	for i := range args {
@@ -79,19 +104,16 @@ func Print(ctx context.Context, args ...object.Object) (object.Object, error) {
 		}
 	}
 	b.WriteByte('\n')
-	_, err := ren.GetOS(ctx).Stdout().Write(b.Bytes())
+	_, err := w.Write(b.Bytes())
 	if err != nil {
 		return nil, err
 	}
 	return object.Nil, nil
 }
 
-// Printf formats its trailing arguments according to the first (format-string)
-// argument and writes the result to standard output. It accepts 1 to 64
-// arguments.
-func Printf(ctx context.Context, args ...object.Object) (object.Object, error) {
+func fprintf(w io.Writer, name string, args []object.Object) (object.Object, error) {
 	if len(args) < 1 || len(args) > 64 {
-		return nil, object.NewArgsRangeError("printf", 1, 64, len(args))
+		return nil, object.NewArgsRangeError(name, 1, 64, len(args))
 	}
 	fs, err := object.AsString(args[0])
 	if err != nil {
@@ -102,7 +124,7 @@ func Printf(ctx context.Context, args ...object.Object) (object.Object, error) {
 		fmtArgs[i] = v.Interface()
 	}
 	b := []byte(fmt.Sprintf(fs, fmtArgs...))
-	_, err = ren.GetOS(ctx).Stdout().Write(b)
+	_, err = w.Write(b)
 	if err != nil {
 		return nil, err
 	}
@@ -131,3 +131,32 @@ func TestPrintf(t *testing.T) {
 		})
 	}
 }
+
+func TestEprint(t *testing.T) {
+	m := &testutils.MockOS{}
+	ctx := ren.WithOS(t.Context(), m)
+
+	stderr := ren.NewPipe()
+	m.On("Stderr").Return(stderr)
+
+	outputCh := make(chan string, 1)
+	go func() {
+		for ctx.Err() == nil {
+			output := make([]byte, 100)
+			n, _ := stderr.Read(output)
+			outputCh <- string(output[:n])
+		}
+	}()
+
+	_, err := builtins.Eprint(ctx, object.NewString("warning:"), object.NewInt(42))
+	require.NoError(t, err)
+	require.Equal(t, "warning: 42\n", <-outputCh)
+
+	_, err = builtins.Eprintf(ctx, object.NewString("%s %d"), object.NewString("error:"), object.NewInt(7))
+	require.NoError(t, err)
+	require.Equal(t, "error: 7", <-outputCh)
+
+	_, err = builtins.Eprint(ctx)
+	require.Error(t, err)
+	m.AssertNotCalled(t, "Stdout")
+}
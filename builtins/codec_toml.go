@@ -0,0 +1,55 @@
+package builtins
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/BurntSushi/toml"
+	modbuiltins "github.com/deepnoodle-ai/risor/v2/pkg/builtins"
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+)
+
+// Registers a "toml" codec so scripts can convert between maps and TOML
+// documents via the standard encode/decode builtins:
+//
+//	encode({"a": 1}, "toml")   // map -> TOML string
+//	decode("a = 1\n", "toml")  // TOML string or bytes -> map
+//
+// Dates and times, local ones included, decode to times.
+func init() {
+	err := modbuiltins.RegisterCodec("toml", &modbuiltins.Codec{
+		Encode: encodeTOML,
+		Decode: decodeTOML,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// encodeTOML encodes a map as a TOML document.
+func encodeTOML(_ context.Context, obj object.Object) (object.Object, error) {
+	m, ok := obj.(*object.Map)
+	if !ok {
+		return nil, object.TypeErrorf("encode() expected a map for toml (got %s)", obj.Type())
+	}
+	var buf bytes.Buffer
+	err := toml.NewEncoder(&buf).Encode(m.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return object.NewString(buf.String()), nil
+}
+
+// decodeTOML decodes a TOML document into a map.
+func decodeTOML(_ context.Context, obj object.Object) (object.Object, error) {
+	b, err := object.AsBytes(obj)
+	if err != nil {
+		return nil, err
+	}
+	var value map[string]any
+	err = toml.Unmarshal(b, &value)
+	if err != nil {
+		return nil, err
+	}
+	return fromDocument(value)
+}
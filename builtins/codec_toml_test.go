@@ -0,0 +1,41 @@
+package builtins_test
+
+import (
+	"context"
+	"testing"
+
+	modbuiltins "github.com/deepnoodle-ai/risor/v2/pkg/builtins"
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/foohq/ren/builtins" // registers the "toml" codec via init
+)
+
+var tomlCodec = object.NewString("toml")
+
+func TestTOMLDecode(t *testing.T) {
+	doc := "name = \"ren\"\n\n[[servers]]\nport = 80\n\n[[servers]]\nport = 443\n"
+	dec, err := modbuiltins.Decode(context.Background(), object.NewString(doc), tomlCodec)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{
+		"name":    "ren",
+		"servers": []any{map[string]any{"port": int64(80)}, map[string]any{"port": int64(443)}},
+	}, dec.Interface())
+}
+
+func TestTOMLRoundTrip(t *testing.T) {
+	value := object.NewMap(map[string]object.Object{
+		"a": object.NewInt(1),
+		"b": object.NewMap(map[string]object.Object{"c": object.NewString("x")}),
+	})
+	enc, err := modbuiltins.Encode(context.Background(), value, tomlCodec)
+	require.NoError(t, err)
+	dec, err := modbuiltins.Decode(context.Background(), enc, tomlCodec)
+	require.NoError(t, err)
+	require.Equal(t, value.Interface(), dec.Interface())
+}
+
+func TestTOMLEncodeRejectsNonMap(t *testing.T) {
+	_, err := modbuiltins.Encode(context.Background(), object.NewInt(1), tomlCodec)
+	require.Error(t, err)
+}
@@ -0,0 +1,99 @@
+package builtins
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	modbuiltins "github.com/deepnoodle-ai/risor/v2/pkg/builtins"
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+	"gopkg.in/yaml.v3"
+)
+
+// Registers a "yaml" codec so scripts can convert between values and YAML
+// documents via the standard encode/decode builtins:
+//
+//	encode({"a": 1}, "yaml")   // value -> YAML string
+//	decode("a: 1\n", "yaml")   // YAML string or bytes -> value
+//
+// Mappings decode to maps, with their keys converted to strings, and
+// sequences to lists.
+func init() {
+	err := modbuiltins.RegisterCodec("yaml", &modbuiltins.Codec{
+		Encode: encodeYAML,
+		Decode: decodeYAML,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// encodeYAML encodes a value as a YAML document.
+func encodeYAML(_ context.Context, obj object.Object) (object.Object, error) {
+	value := obj.Interface()
+	if value == nil {
+		return nil, object.ValueErrorf("encode() does not support %s", obj.Type())
+	}
+	b, err := yaml.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return object.NewString(string(b)), nil
+}
+
+// decodeYAML decodes a YAML document into a value.
+func decodeYAML(_ context.Context, obj object.Object) (object.Object, error) {
+	b, err := object.AsBytes(obj)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	err = yaml.Unmarshal(b, &value)
+	if err != nil {
+		return nil, err
+	}
+	return fromDocument(value)
+}
+
+// fromDocument converts a value decoded from a YAML or TOML document into a
+// Risor object. Maps become maps with string keys and slices become lists;
+// other values with no Risor equivalent become their string form.
+func fromDocument(v any) (object.Object, error) {
+	switch v := v.(type) {
+	case nil:
+		return object.Nil, nil
+	case time.Time:
+		return object.NewTime(v), nil
+	case fmt.Stringer:
+		if _, ok := v.(object.Object); !ok {
+			return object.NewString(v.String()), nil
+		}
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]object.Object, rv.Len())
+		for i := range items {
+			item, err := fromDocument(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return object.NewList(items), nil
+	case reflect.Map:
+		items := make(map[string]object.Object, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			item, err := fromDocument(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+			items[fmt.Sprint(iter.Key().Interface())] = item
+		}
+		return object.NewMap(items), nil
+	}
+	return object.DefaultRegistry().FromGo(v)
+}
@@ -0,0 +1,43 @@
+package builtins_test
+
+import (
+	"context"
+	"testing"
+
+	modbuiltins "github.com/deepnoodle-ai/risor/v2/pkg/builtins"
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/foohq/ren/builtins" // registers the "yaml" codec via init
+)
+
+var yamlCodec = object.NewString("yaml")
+
+func TestYAMLDecode(t *testing.T) {
+	doc := "name: ren\nports: [80, 443]\nnested:\n  1: one\n  debug: true\nempty:\n"
+	dec, err := modbuiltins.Decode(context.Background(), object.NewString(doc), yamlCodec)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{
+		"name":   "ren",
+		"ports":  []any{int64(80), int64(443)},
+		"nested": map[string]any{"1": "one", "debug": true},
+		"empty":  nil,
+	}, dec.Interface())
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	value := object.NewMap(map[string]object.Object{
+		"a": object.NewInt(1),
+		"b": object.NewList([]object.Object{object.NewString("x")}),
+	})
+	enc, err := modbuiltins.Encode(context.Background(), value, yamlCodec)
+	require.NoError(t, err)
+	dec, err := modbuiltins.Decode(context.Background(), enc, yamlCodec)
+	require.NoError(t, err)
+	require.Equal(t, value.Interface(), dec.Interface())
+}
+
+func TestYAMLDecodeInvalid(t *testing.T) {
+	_, err := modbuiltins.Decode(context.Background(), object.NewString("a: [1"), yamlCodec)
+	require.Error(t, err)
+}
@@ -12,6 +12,8 @@ var ownDocs = []object.FuncSpec{
 	{Name: "import", Doc: "Load a module and return it; the argument is a package path or a builtin:// URL", Args: []string{"url"}, Returns: "module", Example: `import("builtin://os")`},
 	{Name: "print", Doc: "Write the arguments to standard output separated by spaces and followed by a newline", Args: []string{"value..."}, Returns: "nil"},
 	{Name: "printf", Doc: "Write a formatted string to standard output", Args: []string{"format", "value..."}, Returns: "nil"},
+	{Name: "eprint", Doc: "Write the arguments to standard error separated by spaces and followed by a newline", Args: []string{"value..."}, Returns: "nil"},
+	{Name: "eprintf", Doc: "Write a formatted string to standard error", Args: []string{"format", "value..."}, Returns: "nil"},
 	{Name: "pack", Doc: "Serialize a map into a little-endian byte buffer according to a schema", Args: []string{"schema", "data"}, Returns: "bytes"},
 	{Name: "packsize", Doc: "Return the total byte size of a schema without packing any data", Args: []string{"schema"}, Returns: "int"},
 	{Name: "unpack", Doc: "Deserialize a little-endian byte buffer into a map according to a schema", Args: []string{"schema", "buffer"}, Returns: "map"},
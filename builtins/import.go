@@ -9,8 +9,11 @@ import (
 )
 
 // Import loads a module and returns it. The single argument is the module
-// reference: a package-relative path (e.g. "utils/log") or a built-in module
-// URL (e.g. "builtin://os").
+// reference: a package-relative path (e.g. "utils/log"), a path relative to
+// the importing module (e.g. "./log" or "../utils/log"), a built-in module
+// URL (e.g. "builtin://os") or a URL of a scheme the host registered a
+// resolver for (e.g. "db://utils/log"). Importing a data file of the package
+// (e.g. "config/settings.json") returns its decoded content.
 func Import(ctx context.Context, args ...object.Object) (object.Object, error) {
 	if len(args) != 1 {
 		return nil, object.NewArgsError("import", 1, len(args))
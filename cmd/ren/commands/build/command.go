@@ -10,6 +10,7 @@ import (
 
 	"github.com/foohq/ren/builtins"
 	"github.com/foohq/ren/cmd/ren/actions"
+	"github.com/foohq/ren/modules"
 	"github.com/foohq/ren/packager"
 )
 
@@ -61,6 +62,12 @@ func buildAction() cli.ActionFunc {
 		for _, builtin := range builtins.Builtins() {
 			opts = append(opts, packager.WithBuiltin(builtin))
 		}
+		for _, module := range modules.Modules() {
+			opts = append(opts, packager.WithModule(module))
+		}
+		opts = append(opts, packager.WithWarnings(func(w packager.Warning) {
+			_, _ = fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+		}))
 
 		err := packager.Build(
 			srcDir,
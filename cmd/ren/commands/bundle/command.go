@@ -0,0 +1,173 @@
+package bundle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/foohq/ren"
+	"github.com/foohq/ren/builtins"
+	"github.com/foohq/ren/cmd/ren/actions"
+	"github.com/foohq/ren/modules"
+)
+
+const (
+	FlagOutput   = "output"
+	FlagRuntime  = "runtime"
+	FlagRestrict = "restrict"
+)
+
+func NewCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "bundle",
+		Usage:     "Bundle a package into a standalone executable",
+		ArgsUsage: "<pkg>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    FlagOutput,
+				Usage:   "set output file",
+				Aliases: []string{"o"},
+			},
+			&cli.StringFlag{
+				Name:  FlagRuntime,
+				Usage: "embed the package in the host executable in file instead of ren itself",
+			},
+			&cli.BoolFlag{
+				Name:  FlagRestrict,
+				Usage: "provide the package only the modules and builtins its manifest requires",
+			},
+		},
+		Action:       action,
+		OnUsageError: actions.UsageError,
+	}
+}
+
+func action(ctx context.Context, c *cli.Command) error {
+	return bundleAction()(ctx, c)
+}
+
+func bundleAction() cli.ActionFunc {
+	return func(ctx context.Context, c *cli.Command) error {
+		if c.Args().Len() != 1 {
+			err := fmt.Errorf("command expects the following arguments: %s", c.ArgsUsage)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		pkg := c.Args().First()
+		outputName := c.String(FlagOutput)
+		if outputName == "" {
+			outputName = strings.TrimSuffix(filepath.Base(pkg), filepath.Ext(pkg))
+			if runtime.GOOS == "windows" {
+				outputName += ".exe"
+			}
+		}
+
+		exe := c.String(FlagRuntime)
+		if exe == "" {
+			var err error
+			exe, err = os.Executable()
+			if err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, err)
+				return err
+			}
+		}
+
+		err := bundle(exe, pkg, outputName, ren.WithManifestRestriction(c.Bool(FlagRestrict)))
+		if err != nil {
+			err := fmt.Errorf("bundle error: %w", err)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		return nil
+	}
+}
+
+// bundle writes to dst the executable exe with the package pkg embedded in it.
+func bundle(exe, pkg, dst string, opts ...ren.Option) error {
+	exeFile, err := os.Open(exe)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = exeFile.Close()
+	}()
+	exeInfo, err := exeFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	pkgFile, err := os.Open(pkg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = pkgFile.Close()
+	}()
+	pkgInfo, err := pkgFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o755)
+	if err != nil {
+		return err
+	}
+	err = ren.Embed(out, exeFile, exeInfo.Size(), pkgFile, pkgInfo.Size(), opts...)
+	if err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// RunEmbedded runs the package embedded in the ren executable by bundle, if
+// there is one, the way ren run would, with the arguments of the process as
+// os.args. It reports whether a package was run and the status the process
+// should exit with.
+func RunEmbedded(ctx context.Context) (int, bool) {
+	opts := []ren.Option{
+		ren.WithArgs(os.Args[1:]),
+		ren.WithStdin(os.Stdin),
+		ren.WithStdout(os.Stdout),
+		ren.WithStderr(os.Stderr),
+	}
+	for _, builtin := range builtins.Builtins() {
+		opts = append(opts, ren.WithBuiltin(builtin))
+	}
+	for _, module := range modules.Modules() {
+		opts = append(opts, ren.WithModule(module))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	exitCode := -1
+	opts = append(opts, ren.WithExitHandler(func(code int) {
+		exitCode = code
+		cancel()
+	}))
+
+	err := ren.RunEmbedded(ctx, opts...)
+	switch {
+	case errors.Is(err, ren.ErrNotEmbedded):
+		return 0, false
+	case exitCode >= 0:
+		return exitCode, true
+	case err != nil:
+		if renErr, ok := errors.AsType[*ren.Error](err); ok {
+			_, _ = fmt.Fprint(os.Stderr, renErr.FriendlyErrorMessage())
+		} else {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+		}
+		return 1, true
+	}
+	return 0, true
+}
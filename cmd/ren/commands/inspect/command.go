@@ -0,0 +1,241 @@
+package inspect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/deepnoodle-ai/risor/v2/pkg/dis"
+	"github.com/urfave/cli/v3"
+
+	"github.com/foohq/ren/cmd/ren/actions"
+	"github.com/foohq/ren/cmd/ren/keys"
+	"github.com/foohq/ren/packager"
+)
+
+const (
+	FlagJSON        = "json"
+	FlagDisassemble = "disassemble"
+	FlagKey         = "key"
+)
+
+func NewCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "inspect",
+		Usage:     "Show the contents of a package",
+		ArgsUsage: "<pkg>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  FlagJSON,
+				Usage: "print the report as JSON",
+			},
+			&cli.StringFlag{
+				Name:  FlagDisassemble,
+				Usage: "print the bytecode of a module, given by its path in the package or its script",
+			},
+			&cli.StringFlag{
+				Name:  FlagKey,
+				Usage: "decrypt an encrypted package with the key in file",
+			},
+		},
+		Action:       action,
+		OnUsageError: actions.UsageError,
+	}
+}
+
+func action(ctx context.Context, c *cli.Command) error {
+	return inspectAction()(ctx, c)
+}
+
+func inspectAction() cli.ActionFunc {
+	return func(ctx context.Context, c *cli.Command) error {
+		if c.Args().Len() != 1 {
+			err := fmt.Errorf("command expects the following arguments: %s", c.ArgsUsage)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+		if c.Bool(FlagJSON) && c.IsSet(FlagDisassemble) {
+			err := fmt.Errorf("--%s cannot be combined with --%s", FlagDisassemble, FlagJSON)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		var opts []packager.Option
+		if name := c.String(FlagKey); name != "" {
+			key, err := keys.ReadEncryptionKey(name)
+			if err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, err)
+				return err
+			}
+			opts = append(opts, packager.WithEncryptionKey(key))
+		}
+
+		pkg := c.Args().First()
+		info, err := inspectFile(pkg, opts...)
+		if err != nil {
+			err := fmt.Errorf("inspect error: %w", err)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		switch {
+		case c.Bool(FlagJSON):
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(info)
+		case c.IsSet(FlagDisassemble):
+			err = disassemble(os.Stdout, info, c.String(FlagDisassemble))
+		default:
+			err = printInfo(os.Stdout, pkg, info, len(opts) > 0)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+		return nil
+	}
+}
+
+func inspectFile(name string, opts ...packager.Option) (*packager.PackageInfo, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	inf, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return packager.Inspect(f, inf.Size(), opts...)
+}
+
+// printInfo prints a report of the package pkg for humans. decrypted is true
+// if the package was inspected with its encryption key.
+func printInfo(w io.Writer, pkg string, info *packager.PackageInfo, decrypted bool) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "Package:\t%s\n", pkg)
+	if m := info.Manifest; m != nil {
+		for _, field := range [][2]string{
+			{"Name", m.Name},
+			{"Version", m.Version},
+			{"Description", m.Description},
+			{"Authors", strings.Join(m.Authors, ", ")},
+			{"License", m.License},
+		} {
+			if field[1] != "" {
+				_, _ = fmt.Fprintf(tw, "%s:\t%s\n", field[0], field[1])
+			}
+		}
+	}
+
+	var signature string
+	switch sig := info.Signature; {
+	case !sig.Signed:
+		signature = "unsigned"
+	case !sig.Valid:
+		signature = "invalid: " + sig.Error
+	default:
+		signature = "signed by " + keys.Fingerprint(sig.PublicKey)
+	}
+	_, _ = fmt.Fprintf(tw, "Signature:\t%s\n", signature)
+	switch {
+	case info.EncryptionKeyID != "" && decrypted:
+		_, _ = fmt.Fprintf(tw, "Encryption:\tkey %s\n", info.EncryptionKeyID)
+	case info.EncryptionKeyID != "":
+		_, _ = fmt.Fprintf(tw, "Encryption:\tkey %s (contents not inspected)\n", info.EncryptionKeyID)
+	}
+	if m := info.Manifest; m != nil && (m.Requires.Ren != "" || len(m.Requires.Modules) > 0 || len(m.Requires.Builtins) > 0) {
+		var requires []string
+		if m.Requires.Ren != "" {
+			requires = append(requires, "ren "+m.Requires.Ren)
+		}
+		requires = append(requires, m.Requires.Modules...)
+		requires = append(requires, m.Requires.Builtins...)
+		_, _ = fmt.Fprintf(tw, "Requires:\t%s\n", strings.Join(requires, ", "))
+	}
+
+	if info.Lock != nil && len(info.Lock.Dependencies) > 0 {
+		_, _ = fmt.Fprintf(tw, "\nDependencies:\n")
+		for _, name := range slices.Sorted(maps.Keys(info.Lock.Dependencies)) {
+			dep := info.Lock.Dependencies[name]
+			state := "provided at run time"
+			if dep.Vendored {
+				state = "vendored " + dep.Version
+			}
+			_, _ = fmt.Fprintf(tw, "  %s\t%s\t%s\n", name, dep.Constraint, state)
+		}
+	}
+
+	if len(info.Modules) > 0 {
+		_, _ = fmt.Fprintf(tw, "\nModules:\n")
+		for _, mod := range info.Modules {
+			_, _ = fmt.Fprintf(tw, "  %s\t%d B\t%s\n", mod.Path, mod.Size, mod.Source)
+			for _, field := range [][2]any{
+				{"exports", mod.Exports},
+				{"imports", mod.Imports},
+				{"globals", mod.Globals},
+			} {
+				if names := field[1].([]string); len(names) > 0 {
+					_, _ = fmt.Fprintf(tw, "    %s:\t%s\n", field[0], strings.Join(names, ", "))
+				}
+			}
+		}
+	}
+
+	if len(info.Files) > 0 {
+		_, _ = fmt.Fprintf(tw, "\nFiles:\n")
+		for _, file := range info.Files {
+			_, _ = fmt.Fprintf(tw, "  %s\t%d B\n", file.Path, file.Size)
+		}
+	}
+	return tw.Flush()
+}
+
+// disassemble prints the bytecode of the module name of the package: the
+// module's own code followed by that of each function it defines.
+func disassemble(w io.Writer, info *packager.PackageInfo, name string) error {
+	var mod *packager.ModuleInfo
+	for i := range info.Modules {
+		m := &info.Modules[i]
+		if m.Path == name || m.Source == name || strings.TrimSuffix(m.Path, ".json") == name {
+			mod = m
+			break
+		}
+	}
+	if mod == nil {
+		return fmt.Errorf("%s: no such module in the package", name)
+	}
+
+	for i, code := range mod.Code.Flatten() {
+		instructions, err := dis.Disassemble(code)
+		if err != nil {
+			return err
+		}
+		if len(instructions) == 0 {
+			continue
+		}
+
+		title := code.Name()
+		switch {
+		case i == 0:
+			title = mod.Path
+		case title == "":
+			title = "<anonymous>"
+		}
+		if i > 0 {
+			_, _ = fmt.Fprintln(w)
+		}
+		_, _ = fmt.Fprintf(w, "%s:\n", title)
+		dis.Print(instructions, w)
+	}
+	return nil
+}
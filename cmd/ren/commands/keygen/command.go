@@ -0,0 +1,76 @@
+package keygen
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/foohq/ren/cmd/ren/actions"
+	"github.com/foohq/ren/cmd/ren/keys"
+)
+
+const (
+	FlagOutput = "output"
+)
+
+// defaultName is the name the key files are given unless set with --output.
+const defaultName = "ren"
+
+func NewCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "keygen",
+		Usage:     "Generate a key pair for signing packages",
+		ArgsUsage: " ",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    FlagOutput,
+				Usage:   "set key file name; the keys are written to <name>.key and <name>.pub",
+				Aliases: []string{"o"},
+				Value:   defaultName,
+			},
+		},
+		Action:       action,
+		OnUsageError: actions.UsageError,
+	}
+}
+
+func action(ctx context.Context, c *cli.Command) error {
+	return keygenAction()(ctx, c)
+}
+
+func keygenAction() cli.ActionFunc {
+	return func(ctx context.Context, c *cli.Command) error {
+		if c.Args().Len() != 0 {
+			err := fmt.Errorf("command expects no arguments")
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		name := c.String(FlagOutput)
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		err = keys.WritePrivateKey(name+".key", priv)
+		if err != nil {
+			err := fmt.Errorf("keygen error: %w", err)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+		err = keys.WritePublicKey(name+".pub", pub)
+		if err != nil {
+			err := fmt.Errorf("keygen error: %w", err)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		_, _ = fmt.Fprintln(os.Stdout, keys.Fingerprint(pub))
+		return nil
+	}
+}
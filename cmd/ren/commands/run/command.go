@@ -2,6 +2,7 @@ package run
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 
@@ -10,15 +11,35 @@ import (
 	"github.com/foohq/ren"
 	"github.com/foohq/ren/builtins"
 	"github.com/foohq/ren/cmd/ren/actions"
+	"github.com/foohq/ren/cmd/ren/keys"
 	"github.com/foohq/ren/modules"
 )
 
+const (
+	FlagTrustedKey = "trusted-key"
+	FlagRoot       = "root"
+	FlagAudit      = "audit"
+)
+
 func NewCommand() *cli.Command {
 	return &cli.Command{
-		Name:         "run",
-		Usage:        "Run Risor script from a package",
-		ArgsUsage:    "<pkg> [[arg] ...]",
-		Flags:        []cli.Flag{},
+		Name:      "run",
+		Usage:     "Run Risor script from a package or a source directory",
+		ArgsUsage: "<pkg|dir> [[arg] ...]",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  FlagTrustedKey,
+				Usage: "run the package only if it is signed by the public key in file (may be repeated)",
+			},
+			&cli.StringFlag{
+				Name:  FlagRoot,
+				Usage: "confine the script's file:// paths to the directory dir",
+			},
+			&cli.StringFlag{
+				Name:  FlagAudit,
+				Usage: "log every filesystem and OS operation of the script to file as JSON lines",
+			},
+		},
 		Action:       action,
 		OnUsageError: actions.UsageError,
 	}
@@ -41,6 +62,9 @@ func runAction() cli.ActionFunc {
 
 		opts := []ren.Option{
 			ren.WithArgs(args),
+			ren.WithStdin(os.Stdin),
+			ren.WithStdout(os.Stdout),
+			ren.WithStderr(os.Stderr),
 		}
 		for _, builtin := range builtins.Builtins() {
 			opts = append(opts, ren.WithBuiltin(builtin))
@@ -50,18 +74,65 @@ func runAction() cli.ActionFunc {
 			opts = append(opts, ren.WithModule(module))
 		}
 
+		if root := c.String(FlagRoot); root != "" {
+			info, err := os.Stat(root)
+			if err == nil && !info.IsDir() {
+				err = fmt.Errorf("%s: not a directory", root)
+			}
+			if err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, err)
+				return err
+			}
+			// Relative paths resolve against the root, not the host's working
+			// directory, which has no meaning within it.
+			opts = append(opts,
+				ren.WithFilesystem("file", ren.NewSubFS(ren.NewLocalFS(), root)),
+				ren.WithWorkingDir("/"),
+			)
+		}
+
+		if names := c.StringSlice(FlagTrustedKey); len(names) > 0 {
+			trusted, err := keys.ReadPublicKeys(names)
+			if err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, err)
+				return err
+			}
+			opts = append(opts, ren.WithTrustedKeys(trusted...))
+		}
+
+		if name := c.String(FlagAudit); name != "" {
+			auditFile, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+			if err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, err)
+				return err
+			}
+			defer func() {
+				_ = auditFile.Close()
+			}()
+			opts = append(opts, ren.WithAuditor(ren.NewJSONAuditor(auditFile)))
+		}
+
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
 		opts = append(opts, ren.WithExitHandler(func(c int) {
 			cancel()
 		}))
 
-		err := ren.RunFile(
+		run := ren.RunFile
+		if info, err := os.Stat(pkg); err == nil && info.IsDir() {
+			run = ren.RunDir
+		}
+
+		err := run(
 			ctx,
 			pkg,
 			opts...,
 		)
 		if err != nil {
+			if renErr, ok := errors.AsType[*ren.Error](err); ok {
+				_, _ = fmt.Fprint(os.Stderr, "run error: "+renErr.FriendlyErrorMessage())
+				return fmt.Errorf("run error: %w", err)
+			}
 			err := fmt.Errorf("run error: %w", err)
 			_, _ = fmt.Fprintln(os.Stderr, err)
 			return err
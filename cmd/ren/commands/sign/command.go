@@ -0,0 +1,119 @@
+package sign
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/foohq/ren/cmd/ren/actions"
+	"github.com/foohq/ren/cmd/ren/keys"
+	"github.com/foohq/ren/packager"
+)
+
+const (
+	FlagKey    = "key"
+	FlagOutput = "output"
+)
+
+func NewCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "sign",
+		Usage:     "Sign a package",
+		ArgsUsage: "<pkg>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     FlagKey,
+				Usage:    "set private key file",
+				Aliases:  []string{"k"},
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:    FlagOutput,
+				Usage:   "set output file; defaults to signing the package in place",
+				Aliases: []string{"o"},
+			},
+		},
+		Action:       action,
+		OnUsageError: actions.UsageError,
+	}
+}
+
+func action(ctx context.Context, c *cli.Command) error {
+	return signAction()(ctx, c)
+}
+
+func signAction() cli.ActionFunc {
+	return func(ctx context.Context, c *cli.Command) error {
+		if c.Args().Len() != 1 {
+			err := fmt.Errorf("command expects the following arguments: %s", c.ArgsUsage)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		pkg := c.Args().First()
+		outputName := c.String(FlagOutput)
+		if outputName == "" {
+			outputName = pkg
+		}
+
+		key, err := keys.ReadPrivateKey(c.String(FlagKey))
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		err = signFile(pkg, outputName, key)
+		if err != nil {
+			err := fmt.Errorf("sign error: %w", err)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		return nil
+	}
+}
+
+// signFile signs the package src and writes the result to dst, which may be
+// src itself. Like packager.Build, it writes to a temporary file next to dst
+// and renames it into place, so that dst is never left half-written.
+func signFile(src, dst string, key ed25519.PrivateKey) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(dst), "ren*"+filepath.Ext(dst))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = out.Close()
+		_ = os.Remove(out.Name())
+	}()
+
+	err = packager.Sign(in, info.Size(), out, key)
+	if err != nil {
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	// Windows cannot replace a file that is still open.
+	_ = in.Close()
+
+	return os.Rename(out.Name(), dst)
+}
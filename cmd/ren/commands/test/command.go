@@ -0,0 +1,156 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/foohq/ren"
+	"github.com/foohq/ren/builtins"
+	"github.com/foohq/ren/cmd/ren/actions"
+	"github.com/foohq/ren/modules"
+	modtesting "github.com/foohq/ren/modules/testing"
+	"github.com/foohq/ren/packager"
+	"github.com/foohq/ren/tester"
+)
+
+const (
+	FlagFormat  = "format"
+	FlagOutput  = "output"
+	FlagRun     = "run"
+	FlagVerbose = "verbose"
+)
+
+// Report formats.
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatJUnit = "junit"
+)
+
+// errTestsFailed is returned when at least one test fails.
+var errTestsFailed = errors.New("tests failed")
+
+func NewCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "test",
+		Usage:     "Run the tests of Risor scripts",
+		ArgsUsage: "<dir>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  FlagFormat,
+				Usage: "set report format (text, json or junit)",
+				Value: formatText,
+			},
+			&cli.StringFlag{
+				Name:    FlagOutput,
+				Usage:   "write report to file",
+				Aliases: []string{"o"},
+			},
+			&cli.StringFlag{
+				Name:  FlagRun,
+				Usage: "run only tests whose name matches the regular expression",
+			},
+			&cli.BoolFlag{
+				Name:    FlagVerbose,
+				Usage:   "list passed and skipped tests in the text report",
+				Aliases: []string{"v"},
+			},
+		},
+		Action:       action,
+		OnUsageError: actions.UsageError,
+	}
+}
+
+func action(ctx context.Context, c *cli.Command) error {
+	return testAction()(ctx, c)
+}
+
+func testAction() cli.ActionFunc {
+	return func(ctx context.Context, c *cli.Command) error {
+		if c.Args().Len() != 1 {
+			err := fmt.Errorf("command expects the following arguments: %s", c.ArgsUsage)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		srcDir := c.Args().First()
+		format := c.String(FlagFormat)
+		if format != formatText && format != formatJSON && format != formatJUnit {
+			err := fmt.Errorf("unknown report format %q", format)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		var buildOpts []packager.Option
+		var runOpts []ren.Option
+		for _, builtin := range builtins.Builtins() {
+			buildOpts = append(buildOpts, packager.WithBuiltin(builtin))
+			runOpts = append(runOpts, ren.WithBuiltin(builtin))
+		}
+		for _, module := range modules.Modules() {
+			buildOpts = append(buildOpts, packager.WithModule(module))
+			runOpts = append(runOpts, ren.WithModule(module))
+		}
+		// The tester provides the testing module at run time; the build must
+		// know of it to accept imports of builtin://testing.
+		buildOpts = append(buildOpts, packager.WithModule(modtesting.Module()))
+
+		opts := []tester.Option{
+			tester.WithBuildOptions(buildOpts...),
+			tester.WithRunOptions(runOpts...),
+		}
+		if pattern := c.String(FlagRun); pattern != "" {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				err := fmt.Errorf("invalid -%s pattern: %w", FlagRun, err)
+				_, _ = fmt.Fprintln(os.Stderr, err)
+				return err
+			}
+			opts = append(opts, tester.WithFilter(re))
+		}
+
+		results, err := tester.Run(ctx, srcDir, opts...)
+		if err != nil {
+			err := fmt.Errorf("test error: %w", err)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		var w io.Writer = os.Stdout
+		if name := c.String(FlagOutput); name != "" {
+			f, err := os.Create(name)
+			if err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, err)
+				return err
+			}
+			defer func() {
+				_ = f.Close()
+			}()
+			w = f
+		}
+
+		switch format {
+		case formatJSON:
+			err = tester.WriteJSON(w, results)
+		case formatJUnit:
+			err = tester.WriteJUnit(w, results)
+		default:
+			err = tester.WriteText(w, results, c.Bool(FlagVerbose))
+		}
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		if tester.Summarize(results).Failed > 0 {
+			return errTestsFailed
+		}
+		return nil
+	}
+}
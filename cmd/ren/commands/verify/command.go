@@ -0,0 +1,78 @@
+package verify
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/foohq/ren/cmd/ren/actions"
+	"github.com/foohq/ren/cmd/ren/keys"
+	"github.com/foohq/ren/packager"
+)
+
+const (
+	FlagTrustedKey = "trusted-key"
+)
+
+func NewCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "verify",
+		Usage:     "Verify the signature of a package",
+		ArgsUsage: "<pkg>",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  FlagTrustedKey,
+				Usage: "require the package to be signed by the public key in file (may be repeated)",
+			},
+		},
+		Action:       action,
+		OnUsageError: actions.UsageError,
+	}
+}
+
+func action(ctx context.Context, c *cli.Command) error {
+	return verifyAction()(ctx, c)
+}
+
+func verifyAction() cli.ActionFunc {
+	return func(ctx context.Context, c *cli.Command) error {
+		if c.Args().Len() != 1 {
+			err := fmt.Errorf("command expects the following arguments: %s", c.ArgsUsage)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		trusted, err := keys.ReadPublicKeys(c.StringSlice(FlagTrustedKey))
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		pkg := c.Args().First()
+		zr, err := zip.OpenReader(pkg)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+		defer func() {
+			_ = zr.Close()
+		}()
+
+		// Without trusted keys, only check that the signature is intact.
+		key, err := packager.Signer(&zr.Reader)
+		if err == nil && len(trusted) > 0 {
+			err = packager.Verify(&zr.Reader, trusted...)
+		}
+		if err != nil {
+			err := fmt.Errorf("verify error: %w", err)
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+
+		_, _ = fmt.Fprintf(os.Stdout, "%s: signed by %s\n", pkg, keys.Fingerprint(key))
+		return nil
+	}
+}
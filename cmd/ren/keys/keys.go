@@ -0,0 +1,141 @@
+// Package keys reads and writes the Ed25519 keys used to sign packages. Keys
+// are stored PEM-encoded, private keys in PKCS #8 and public keys in PKIX form,
+// the same format as produced by `openssl genpkey -algorithm ed25519`. It also
+// reads the keys packages are encrypted with, stored as raw bytes.
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/foohq/ren/packager"
+)
+
+// PEM block types of the key files.
+const (
+	privateKeyType = "PRIVATE KEY"
+	publicKeyType  = "PUBLIC KEY"
+)
+
+// ErrNotEd25519 is returned when a key file holds a key of another type.
+var ErrNotEd25519 = errors.New("not an Ed25519 key")
+
+// ReadEncryptionKey reads the key a package is encrypted with from the file
+// name, which holds the packager.KeySize bytes of the key and nothing else,
+// e.g. as written by `head -c 32 /dev/urandom`.
+func ReadEncryptionKey(name string) ([]byte, error) {
+	key, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != packager.KeySize {
+		return nil, fmt.Errorf("%s: encryption key must be %d bytes, got %d", name, packager.KeySize, len(key))
+	}
+	return key, nil
+}
+
+// ReadPrivateKey reads the private key stored in the file name.
+func ReadPrivateKey(name string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(name, privateKeyType)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrNotEd25519)
+	}
+	return edKey, nil
+}
+
+// ReadPublicKey reads the public key stored in the file name.
+func ReadPublicKey(name string) (ed25519.PublicKey, error) {
+	der, err := readPEM(name, publicKeyType)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrNotEd25519)
+	}
+	return edKey, nil
+}
+
+// ReadPublicKeys reads the public keys stored in the files names.
+func ReadPublicKeys(names []string) ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0, len(names))
+	for _, name := range names {
+		key, err := ReadPublicKey(name)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// WritePrivateKey stores key in a new file name, readable only by its owner.
+func WritePrivateKey(name string, key ed25519.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writePEM(name, privateKeyType, der, 0600)
+}
+
+// WritePublicKey stores key in a new file name.
+func WritePublicKey(name string, key ed25519.PublicKey) error {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return err
+	}
+	return writePEM(name, publicKeyType, der, 0644)
+}
+
+// Fingerprint returns a short form of key for display, "SHA256:" followed by
+// the unpadded base64 of the key's SHA-256 hash.
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// readPEM returns the content of the PEM block of type typ in the file name.
+func readPEM(name, typ string) ([]byte, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != typ {
+		return nil, fmt.Errorf("%s: no %s PEM block found", name, typ)
+	}
+	return block.Bytes, nil
+}
+
+// writePEM writes der as a PEM block of type typ to a new file name. An
+// existing file is never overwritten.
+func writePEM(name, typ string, der []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	err = pem.Encode(f, &pem.Block{Type: typ, Bytes: der})
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
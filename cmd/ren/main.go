@@ -10,7 +10,13 @@ import (
 	"github.com/foohq/ren"
 	"github.com/foohq/ren/cmd/ren/actions"
 	"github.com/foohq/ren/cmd/ren/commands/build"
+	"github.com/foohq/ren/cmd/ren/commands/bundle"
+	"github.com/foohq/ren/cmd/ren/commands/inspect"
+	"github.com/foohq/ren/cmd/ren/commands/keygen"
 	"github.com/foohq/ren/cmd/ren/commands/run"
+	"github.com/foohq/ren/cmd/ren/commands/sign"
+	"github.com/foohq/ren/cmd/ren/commands/test"
+	"github.com/foohq/ren/cmd/ren/commands/verify"
 )
 
 var app = &cli.Command{
@@ -23,6 +29,12 @@ var app = &cli.Command{
 	Commands: []*cli.Command{
 		build.NewCommand(),
 		run.NewCommand(),
+		test.NewCommand(),
+		sign.NewCommand(),
+		verify.NewCommand(),
+		inspect.NewCommand(),
+		bundle.NewCommand(),
+		keygen.NewCommand(),
 	},
 	CommandNotFound: actions.CommandNotFound,
 	OnUsageError:    actions.UsageError,
@@ -33,6 +45,11 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
+	if code, ok := bundle.RunEmbedded(ctx); ok {
+		cancel()
+		os.Exit(code)
+	}
+
 	err := app.Run(ctx, os.Args)
 	if err != nil {
 		os.Exit(1)
@@ -0,0 +1,87 @@
+package ren
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+)
+
+// toObject converts a Go value into a Risor object. Slices and maps are
+// converted element by element so that errors nested in them become error
+// objects rather than opaque Go structs.
+func toObject(v any) (object.Object, error) {
+	switch v := v.(type) {
+	case nil:
+		return object.Nil, nil
+	case object.Object:
+		return v, nil
+	case error:
+		return object.NewError(v), nil
+	case []byte:
+		return object.NewBytes(v), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]object.Object, rv.Len())
+		for i := range items {
+			item, err := toObject(rv.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+			items[i] = item
+		}
+		return object.NewList(items), nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", rv.Type().Key())
+		}
+		items := make(map[string]object.Object, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			item, err := toObject(iter.Value().Interface())
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", key, err)
+			}
+			items[key] = item
+		}
+		return object.NewMap(items), nil
+	}
+
+	return object.DefaultRegistry().FromGo(v)
+}
+
+// fromObject converts a Risor object into a Go value. Objects without a Go
+// equivalent, such as functions and modules, are returned unchanged.
+func fromObject(obj object.Object) any {
+	switch obj := obj.(type) {
+	case nil:
+		return nil
+	case *object.NilType:
+		return nil
+	case *object.Error:
+		return obj.Value()
+	case *object.List:
+		items := obj.Value()
+		result := make([]any, len(items))
+		for i, item := range items {
+			result[i] = fromObject(item)
+		}
+		return result
+	case *object.Map:
+		items := obj.Value()
+		result := make(map[string]any, len(items))
+		for key, item := range items {
+			result[key] = fromObject(item)
+		}
+		return result
+	}
+
+	if v := obj.Interface(); v != nil {
+		return v
+	}
+	return obj
+}
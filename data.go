@@ -0,0 +1,100 @@
+package ren
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	modbuiltins "github.com/deepnoodle-ai/risor/v2/pkg/builtins"
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+)
+
+// dataCodecs maps the extensions of data files whose codec is not named after
+// the extension to the name of the codec.
+var dataCodecs = map[string]string{
+	"yml": "yaml",
+}
+
+// isDataFile reports whether the package path pth names a data file: a regular
+// file that is not a compiled module.
+func (imp *Importer) isDataFile(pth string) bool {
+	if _, ok := imp.modules[pth]; ok {
+		return false
+	}
+	info, err := fs.Stat(imp.files, pth)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// importData imports the data file at pth of the package. A data file is
+// imported as a value rather than as a module: it is decoded with the Risor
+// codec registered under the name of its extension, e.g. "json", "yaml",
+// "toml" or "csv" (see decode). A file with no such codec is imported as a
+// string, or as bytes if it is not valid UTF-8. The file is decoded once, and
+// each import returns a copy of the value, so that a module modifying it does
+// not affect the others.
+func (imp *Importer) importData(ctx context.Context, name, pth string) (object.Object, error) {
+	value, err := imp.importOnce(name, pth, func() (object.Object, error) {
+		b, err := fs.ReadFile(imp.files, pth)
+		if err != nil {
+			return nil, fmt.Errorf("cannot import %q: %w", name, unwrapPathError(err))
+		}
+
+		value, err := decodeData(ctx, pth, b)
+		if err != nil {
+			return nil, fmt.Errorf("cannot import %q: %w", name, err)
+		}
+		return value, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyData(value), nil
+}
+
+// copyData returns a deep copy of the decoded data value, whose maps and lists
+// are the only mutable objects.
+func copyData(value object.Object) object.Object {
+	switch value := value.(type) {
+	case *object.Map:
+		items := make(map[string]object.Object, value.Size())
+		for key, item := range value.Value() {
+			items[key] = copyData(item)
+		}
+		return object.NewMap(items)
+	case *object.List:
+		items := make([]object.Object, len(value.Value()))
+		for i, item := range value.Value() {
+			items[i] = copyData(item)
+		}
+		return object.NewList(items)
+	}
+	return value
+}
+
+// decodeData decodes the content b of the data file at pth.
+func decodeData(ctx context.Context, pth string, b []byte) (object.Object, error) {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(pth), "."))
+	if name, ok := dataCodecs[ext]; ok {
+		ext = name
+	}
+	if ext != "" {
+		if codec, err := modbuiltins.GetCodec(ext); err == nil && codec.Decode != nil {
+			value, err := codec.Decode(ctx, object.NewBytes(b))
+			if err != nil {
+				return nil, err
+			}
+			if errObj, ok := value.(*object.Error); ok {
+				return nil, errObj.Value()
+			}
+			return value, nil
+		}
+	}
+
+	if utf8.Valid(b) {
+		return object.NewString(string(b)), nil
+	}
+	return object.NewBytes(b), nil
+}
@@ -0,0 +1,72 @@
+package ren
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/foohq/ren/packager"
+)
+
+// ErrMissingDependency is returned when a package depends on a package that is
+// neither vendored in it nor given with WithPackageDependency.
+var ErrMissingDependency = errors.New("missing package dependency")
+
+// dependencySource is a package given with WithPackageDependency.
+type dependencySource struct {
+	reader io.ReaderAt
+	size   int64
+}
+
+// loadDependencies loads the dependencies recorded in lock: vendored ones from
+// their directory in files and the others from WithPackageDependency, checking
+// that their version satisfies the locked constraint.
+func loadDependencies(ctx context.Context, files fs.FS, lock *packager.Lock, opts *options, loading []string) (map[string]*Program, error) {
+	deps := make(map[string]*Program, len(lock.Dependencies))
+	for name, locked := range lock.Dependencies {
+		if locked.Vendored {
+			sub, err := fs.Sub(files, path.Join(packager.DependencyDir, name))
+			if err != nil {
+				return nil, err
+			}
+			dep := &Program{files: sub}
+			err = dep.loadFiles(ctx, opts, loading)
+			if err != nil {
+				return nil, fmt.Errorf("dependency %q: %w", name, err)
+			}
+			deps[name] = dep
+			continue
+		}
+
+		if slices.Contains(loading, name) {
+			return nil, fmt.Errorf("dependency %q: dependency cycle: %s", name, strings.Join(append(slices.Clip(loading), name), " -> "))
+		}
+		src, ok := opts.dependencies[name]
+		if !ok {
+			return nil, fmt.Errorf("dependency %q: %w", name, ErrMissingDependency)
+		}
+		dep, err := loadProgram(ctx, src.reader, src.size, opts, append(slices.Clip(loading), name))
+		if err != nil {
+			return nil, fmt.Errorf("dependency %q: %w", name, err)
+		}
+
+		var version string
+		if dep.manifest != nil {
+			version = dep.manifest.Version
+		}
+		ok, err = packager.SatisfiesConstraint(version, locked.Constraint)
+		if err != nil {
+			return nil, fmt.Errorf("dependency %q: %w", name, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("dependency %q: %w: version %q does not satisfy %q", name, packager.ErrInvalidDependency, version, locked.Constraint)
+		}
+		deps[name] = dep
+	}
+	return deps, nil
+}
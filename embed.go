@@ -0,0 +1,134 @@
+package ren
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNotEmbedded is returned by RunEmbedded and RunEmbeddedFile when the
+// executable has no package embedded in it, or cannot be read to find one.
+var ErrNotEmbedded = errors.New("no embedded package")
+
+// embedMagic ends an executable with an embedded package. It is preceded by
+// the size of the package and by flags describing how to run it, both
+// little-endian uint64, and those by the package itself:
+//
+//	executable | package | size | flags | magic
+const embedMagic = "ren\x00pkg1"
+
+// embedTrailerSize is the size of what follows the embedded package.
+const embedTrailerSize = 8 + 8 + len(embedMagic)
+
+// embedRestricted is the flag of a package run with
+// WithManifestRestriction(true).
+const embedRestricted = 1 << 0
+
+// Embed writes to w the executable read from exe followed by the package read
+// from pkg, so that the resulting executable can find and run the package
+// with RunEmbedded. exe is typically a host program calling RunEmbedded, such
+// as the ren binary itself; a package already embedded in it is replaced.
+// Among opt, only WithManifestRestriction is recorded, to be applied when the
+// package runs; other options are ignored.
+func Embed(w io.Writer, exe io.ReaderAt, exeSize int64, pkg io.ReaderAt, pkgSize int64, opt ...Option) error {
+	var opts options
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	_, err := zip.NewReader(pkg, pkgSize)
+	if err != nil {
+		return fmt.Errorf("invalid package: %w", err)
+	}
+
+	if section, _, err := embedded(exe, exeSize); err == nil {
+		exeSize -= section.Size() + int64(embedTrailerSize)
+	}
+
+	_, err = io.Copy(w, io.NewSectionReader(exe, 0, exeSize))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, io.NewSectionReader(pkg, 0, pkgSize))
+	if err != nil {
+		return err
+	}
+
+	var flags uint64
+	if opts.manifestRestriction {
+		flags |= embedRestricted
+	}
+	trailer := make([]byte, 0, embedTrailerSize)
+	trailer = binary.LittleEndian.AppendUint64(trailer, uint64(pkgSize))
+	trailer = binary.LittleEndian.AppendUint64(trailer, flags)
+	trailer = append(trailer, embedMagic...)
+	_, err = w.Write(trailer)
+	return err
+}
+
+// RunEmbedded executes the package embedded by Embed in the executable of the
+// current process, which lets a host program be distributed as a single file
+// together with the package it runs. It returns an error matching
+// ErrNotEmbedded if there is no such package or the executable cannot be read,
+// so that a host can fall back to its usual behaviour.
+func RunEmbedded(ctx context.Context, opts ...Option) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotEmbedded, err)
+	}
+	return RunEmbeddedFile(ctx, exe, opts...)
+}
+
+// RunEmbeddedFile executes the package embedded by Embed in the executable
+// filename. Options recorded by Embed apply before opts.
+func RunEmbeddedFile(ctx context.Context, filename string, opts ...Option) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotEmbedded, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	inf, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotEmbedded, err)
+	}
+
+	pkg, flags, err := embedded(f, inf.Size())
+	if err != nil {
+		return err
+	}
+	if flags&embedRestricted != 0 {
+		opts = append([]Option{WithManifestRestriction(true)}, opts...)
+	}
+	return Run(ctx, pkg, pkg.Size(), opts...)
+}
+
+// embedded locates the package embedded in the executable r of size bytes and
+// returns it along with the flags recorded with it.
+func embedded(r io.ReaderAt, size int64) (*io.SectionReader, uint64, error) {
+	if size < int64(embedTrailerSize) {
+		return nil, 0, ErrNotEmbedded
+	}
+	trailer := make([]byte, embedTrailerSize)
+	_, err := r.ReadAt(trailer, size-int64(embedTrailerSize))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrNotEmbedded, err)
+	}
+	if string(trailer[16:]) != embedMagic {
+		return nil, 0, ErrNotEmbedded
+	}
+
+	pkgSize := binary.LittleEndian.Uint64(trailer[0:8])
+	flags := binary.LittleEndian.Uint64(trailer[8:16])
+	if pkgSize > uint64(size-int64(embedTrailerSize)) {
+		return nil, 0, fmt.Errorf("%w: invalid size %d", ErrNotEmbedded, pkgSize)
+	}
+	offset := size - int64(embedTrailerSize) - int64(pkgSize)
+	return io.NewSectionReader(r, offset, int64(pkgSize)), flags, nil
+}
@@ -0,0 +1,74 @@
+package ren
+
+import (
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// environ is the environment of a single run. Scripts read and modify it
+// instead of the environment of the host process, so that concurrent runs
+// cannot observe each other's changes.
+type environ struct {
+	mu   sync.RWMutex
+	vars map[string]string
+}
+
+// newEnviron returns an environment holding vars. The environment takes
+// ownership of the map.
+func newEnviron(vars map[string]string) *environ {
+	return &environ{vars: vars}
+}
+
+// hostEnv returns the variables of the host process environment whose names
+// match any of patterns, as accepted by path.Match. Nil patterns match every
+// variable.
+func hostEnv(patterns []string) map[string]string {
+	vars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if patterns != nil && !matchAny(patterns, key) {
+			continue
+		}
+		vars[key] = value
+	}
+	return vars
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *environ) lookup(key string) (string, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	value, ok := e.vars[key]
+	return value, ok
+}
+
+func (e *environ) set(key, value string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.vars[key] = value
+}
+
+func (e *environ) unset(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.vars, key)
+}
+
+// keys returns the sorted names of the variables.
+func (e *environ) keys() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.Sorted(maps.Keys(e.vars))
+}
@@ -0,0 +1,270 @@
+package ren
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/deepnoodle-ai/risor/v2"
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+	"github.com/deepnoodle-ai/risor/v2/pkg/parser"
+)
+
+// excerptLines is how many lines of source FriendlyErrorMessage shows on each
+// side of the line an error was raised on.
+const excerptLines = 2
+
+// Error represents an error that occurred during script execution.
+type Error struct {
+	err  error
+	prog *Program
+}
+
+// Frame is a single call on the stack of a failed script.
+type Frame struct {
+	// Function is the name of the called function: "<anonymous>" for a
+	// function literal and "__main__" for the top level of the entrypoint or of
+	// a module.
+	Function string
+	// File is the path of the script within the package, e.g.
+	// "lib/util.risor". It is empty for packages built by earlier versions of
+	// the packager, which did not record it.
+	File string
+	// Line and Column are the 1-based position in File of the call or, in the
+	// innermost frame, of the error.
+	Line   int
+	Column int
+}
+
+// String returns the frame in the form "function (file:line:column)".
+func (f Frame) String() string {
+	return fmt.Sprintf("%s (%s:%d:%d)", f.Function, f.File, f.Line, f.Column)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Error returns the error message.
+func (e *Error) Error() string {
+	if parserErr, ok := errors.AsType[parser.ParserError](e.err); ok {
+		return parserErr.FriendlyErrorMessage()
+	}
+	return e.err.Error()
+}
+
+// Stack returns the call stack at the point the error was raised, innermost
+// frame first. A call into an imported module contributes frames from both the
+// module and the importing script. An error raised while a module is being
+// imported starts at the failing statement of that module, followed by the
+// frames of each import that led to it, up to the entrypoint. Stack returns
+// nil if the error carries no stack, as is the case for errors returned by Go
+// functions.
+func (e *Error) Stack() []Frame {
+	var frames []Frame
+	var imports [][]object.StackFrame
+	for err := e.err; err != nil; err = errors.Unwrap(err) {
+		if importErr, ok := err.(*importError); ok {
+			imports = append(imports, importErr.stack)
+			continue
+		}
+		if structured, ok := err.(*object.StructuredError); ok {
+			frames = e.frames(structured.Stack)
+			break
+		}
+	}
+	// The imports were unwrapped from the entrypoint's inwards.
+	for _, stack := range slices.Backward(imports) {
+		frames = append(frames, e.frames(stack)...)
+	}
+	if len(frames) == 0 {
+		return nil
+	}
+	return frames
+}
+
+// frames converts the stack of a VM to frames, innermost first.
+func (e *Error) frames(stack []object.StackFrame) []Frame {
+	frames := make([]Frame, 0, len(stack))
+	for _, sf := range stack {
+		frame := Frame{
+			Function: sf.Function,
+			File:     sf.Location.Filename,
+			Line:     sf.Location.Line,
+			Column:   sf.Location.Column,
+		}
+		// The packager compiles a module as a function literal called from a
+		// top level of its own (see packager.wrapModule). Fold the two into a
+		// single frame for the top level of the module.
+		if frame.Function == "__main__" && e.isModule(frame.File) && len(frames) > 0 && frames[len(frames)-1].File == frame.File {
+			frames[len(frames)-1].Function = frame.Function
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// importError is the error of a failed import, with the stack of the script
+// that called import at that point.
+type importError struct {
+	err   error
+	stack []object.StackFrame
+}
+
+// Error returns the message of the underlying error.
+func (e *importError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *importError) Unwrap() error {
+	return e.err
+}
+
+// callerStack returns the stack of the VM running the builtin called with
+// ctx, innermost frame first, or nil if ctx does not come from a VM.
+//
+// The VM records its stack in the errors raised by script code, but not in
+// those of builtins such as import, and it exposes no other way to read its
+// call frames. callerStack therefore calls a script function that fails at
+// once, probeSource, on that VM and keeps the stack of its error less the
+// probe's own frame. The frame is checked against the position of the failing
+// call in probeSource, so that a change to the VM's errors leaves import
+// errors without a stack rather than with a wrong one.
+func callerStack(ctx context.Context) []object.StackFrame {
+	call, ok := object.GetCallFunc(ctx)
+	if !ok {
+		return nil
+	}
+	probe, err := stackProbe()
+	if err != nil {
+		return nil
+	}
+	_, err = call(ctx, probe, nil)
+	structured, ok := errors.AsType[*object.StructuredError](err)
+	if !ok || len(structured.Stack) == 0 {
+		return nil
+	}
+	frame := structured.Stack[0]
+	if frame.Function != "<anonymous>" || frame.Location.Line != 1 || frame.Location.Column != probeColumn {
+		return nil
+	}
+	return structured.Stack[1:]
+}
+
+// probeSource is the function callerStack calls to read the stack of a VM.
+// probeColumn is the column of its failing call.
+const (
+	probeSource = "function() { return nil() }"
+	probeColumn = 21
+)
+
+// stackProbe compiles probeSource once for callerStack.
+var stackProbe = sync.OnceValues(func() (*object.Closure, error) {
+	ctx := context.Background()
+	code, err := risor.Compile(ctx, probeSource, risor.WithEnv(nil))
+	if err != nil {
+		return nil, err
+	}
+	fn, err := risor.Run(ctx, code, risor.WithEnv(nil), risor.WithRawResult())
+	if err != nil {
+		return nil, err
+	}
+	return fn.(*object.Closure), nil
+})
+
+// FriendlyErrorMessage returns a description of the error meant for display:
+// the message, an excerpt of the script's source marking where the error was
+// raised and the stack trace. The excerpt is left out if the package does not
+// include the source of the script.
+func (e *Error) FriendlyErrorMessage() string {
+	if parserErr, ok := errors.AsType[parser.ParserError](e.err); ok {
+		return parserErr.FriendlyErrorMessage()
+	}
+
+	var b strings.Builder
+	b.WriteString(e.Error())
+	b.WriteString("\n")
+
+	if structured, ok := errors.AsType[*object.StructuredError](e.err); ok {
+		e.writeExcerpt(&b, structured.Location)
+	}
+
+	if stack := e.Stack(); len(stack) > 0 {
+		b.WriteString("\nstack trace:\n")
+		for _, frame := range stack {
+			fmt.Fprintf(&b, "  at %s\n", frame)
+		}
+	}
+
+	return b.String()
+}
+
+// writeExcerpt writes the lines of source around loc to b, with a marker under
+// the position loc points to.
+func (e *Error) writeExcerpt(b *strings.Builder, loc object.SourceLocation) {
+	source := e.source(loc.Filename)
+	if source == "" {
+		return
+	}
+	lines := strings.Split(strings.TrimSuffix(source, "\n"), "\n")
+	if loc.Line < 1 || loc.Line > len(lines) {
+		return
+	}
+
+	first := max(loc.Line-excerptLines, 1)
+	last := min(loc.Line+excerptLines, len(lines))
+	width := len(strconv.Itoa(last))
+
+	fmt.Fprintf(b, "\n%*s--> %s:%d:%d\n", width, "", loc.Filename, loc.Line, loc.Column)
+	for n := first; n <= last; n++ {
+		line := strings.TrimRight(lines[n-1], "\r")
+		fmt.Fprintf(b, "%*d | %s\n", width, n, line)
+		if n == loc.Line && loc.Column > 0 {
+			fmt.Fprintf(b, "%*s | %s%s\n", width, "", markerIndent(line, loc.Column), strings.Repeat("^", max(loc.EndColumn-loc.Column, 1)))
+		}
+	}
+}
+
+// markerIndent returns the whitespace that lines a marker up with the 1-based
+// column of line, keeping tabs so that it stays aligned however they are
+// displayed.
+func markerIndent(line string, column int) string {
+	var indent strings.Builder
+	for i, r := range []rune(line) {
+		if i >= column-1 {
+			break
+		}
+		if r == '\t' {
+			indent.WriteRune('\t')
+		} else {
+			indent.WriteRune(' ')
+		}
+	}
+	return indent.String()
+}
+
+// source returns the source of the script compiled under filename, or an
+// empty string if the package does not include it.
+func (e *Error) source(filename string) string {
+	if filename == "" || e.prog == nil {
+		return ""
+	}
+	for _, code := range e.prog.modules {
+		if code.Filename() == filename {
+			return code.Source()
+		}
+	}
+	return ""
+}
+
+// isModule reports whether filename names a script other than the entrypoint.
+func (e *Error) isModule(filename string) bool {
+	return filename != "" && e.prog != nil && filename != e.prog.entrypoint.Filename()
+}
@@ -0,0 +1,36 @@
+package ren
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/deepnoodle-ai/risor/v2"
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCallerStack verifies that callerStack reads the stack of the script
+// calling a builtin. It fails if the VM changes how it records its stack in
+// errors, which callerStack depends on.
+func TestCallerStack(t *testing.T) {
+	var stack []object.StackFrame
+	probe := object.NewBuiltin("probe", func(ctx context.Context, args ...object.Object) (object.Object, error) {
+		stack = callerStack(ctx)
+		return object.Nil, nil
+	})
+
+	_, err := risor.Eval(
+		context.Background(),
+		"function f() {\n  return probe()\n}\nf()\n",
+		risor.WithEnv(map[string]any{"probe": probe}),
+	)
+	require.NoError(t, err)
+	var calls []string
+	for _, sf := range stack {
+		calls = append(calls, fmt.Sprintf("%s %d:%d", sf.Function, sf.Location.Line, sf.Location.Column))
+	}
+	require.Equal(t, []string{"f 2:10", "__main__ 4:1"}, calls)
+
+	require.Nil(t, callerStack(context.Background()))
+}
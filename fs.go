@@ -8,7 +8,11 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"math/rand/v2"
 	"os"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/foohq/urlpath"
@@ -46,7 +50,7 @@ func (f fsMiddleware) Mkdir(name string, perm FileMode) error {
 	if err != nil {
 		return err
 	}
-	pth, err := urlpath.Path(name)
+	pth, err := fsPath(name)
 	if err != nil {
 		return err
 	}
@@ -62,7 +66,7 @@ func (f fsMiddleware) MkdirAll(path string, perm FileMode) error {
 	if err != nil {
 		return err
 	}
-	pth, err := urlpath.Path(path)
+	pth, err := fsPath(path)
 	if err != nil {
 		return err
 	}
@@ -78,7 +82,7 @@ func (f fsMiddleware) MkdirTemp(dir, pattern string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	pth, err := urlpath.Path(dir)
+	pth, err := fsPath(dir)
 	if err != nil {
 		return "", err
 	}
@@ -94,7 +98,7 @@ func (f fsMiddleware) OpenFile(name string, flag int, perm FileMode) (File, erro
 	if err != nil {
 		return nil, err
 	}
-	pth, err := urlpath.Path(name)
+	pth, err := fsPath(name)
 	if err != nil {
 		return nil, err
 	}
@@ -110,13 +114,13 @@ func (f fsMiddleware) ReadFile(name string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	pth, err := urlpath.Path(name)
+	pth, err := fsPath(name)
 	if err != nil {
 		return nil, err
 	}
 	b, err := fs.ReadFile(pth)
 	if err != nil {
-		return nil, fmt.Errorf("open %s: %v", name, err)
+		return nil, fmt.Errorf("open %s: %w", name, err)
 	}
 	return b, nil
 }
@@ -126,7 +130,7 @@ func (f fsMiddleware) Remove(name string) error {
 	if err != nil {
 		return err
 	}
-	pth, err := urlpath.Path(name)
+	pth, err := fsPath(name)
 	if err != nil {
 		return err
 	}
@@ -142,7 +146,7 @@ func (f fsMiddleware) RemoveAll(path string) error {
 	if err != nil {
 		return err
 	}
-	pth, err := urlpath.Path(path)
+	pth, err := fsPath(path)
 	if err != nil {
 		return err
 	}
@@ -165,11 +169,11 @@ func (f fsMiddleware) Rename(oldPath, newPath string) error {
 	if oldFS != newFS {
 		return ErrCrossingFSBoundaries
 	}
-	oldPth, err := urlpath.Path(oldPath)
+	oldPth, err := fsPath(oldPath)
 	if err != nil {
 		return err
 	}
-	newPth, err := urlpath.Path(newPath)
+	newPth, err := fsPath(newPath)
 	if err != nil {
 		return err
 	}
@@ -185,7 +189,7 @@ func (f fsMiddleware) Stat(name string) (FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	pth, err := urlpath.Path(name)
+	pth, err := fsPath(name)
 	if err != nil {
 		return nil, err
 	}
@@ -208,11 +212,11 @@ func (f fsMiddleware) Symlink(oldName, newName string) error {
 	if oldFS != newFS {
 		return ErrCrossingFSBoundaries
 	}
-	oldPth, err := urlpath.Path(oldName)
+	oldPth, err := fsPath(oldName)
 	if err != nil {
 		return err
 	}
-	newPth, err := urlpath.Path(newName)
+	newPth, err := fsPath(newName)
 	if err != nil {
 		return err
 	}
@@ -228,7 +232,7 @@ func (f fsMiddleware) WriteFile(name string, data []byte, perm FileMode) error {
 	if err != nil {
 		return err
 	}
-	pth, err := urlpath.Path(name)
+	pth, err := fsPath(name)
 	if err != nil {
 		return err
 	}
@@ -245,7 +249,7 @@ func (f fsMiddleware) ReadDir(name string) ([]DirEntry, error) {
 		return nil, err
 	}
 
-	pth, err := urlpath.Path(name)
+	pth, err := fsPath(name)
 	if err != nil {
 		return nil, err
 	}
@@ -261,6 +265,21 @@ func (f fsMiddleware) ReadDir(name string) ([]DirEntry, error) {
 	return entries, nil
 }
 
+// fsPath returns the path component of the URL name as passed to the
+// filesystem. Package URLs have no host: like imports,
+// package://templates/a.txt names templates/a.txt relative to the package
+// root.
+func fsPath(name string) (string, error) {
+	scheme, err := urlpath.Scheme(name)
+	if err != nil {
+		return "", err
+	}
+	if scheme == packageScheme {
+		return "/" + strings.TrimPrefix(name, packageScheme+"://"), nil
+	}
+	return urlpath.Path(name)
+}
+
 func (f fsMiddleware) lookupFS(pth string) (FS, error) {
 	scheme, err := urlpath.Scheme(pth)
 	if err != nil {
@@ -280,8 +299,17 @@ func (f fsMiddleware) lookupFS(pth string) (FS, error) {
 
 var _ FS = &localFS{}
 
+// localFS is the filesystem of the host, registered under the file scheme by
+// default.
 type localFS struct{}
 
+// NewLocalFS returns the filesystem of the host, which the runtime registers
+// under the file scheme unless another is given with WithFilesystem. Paths are
+// passed to the os package as they are; see NewSubFS to confine it.
+func NewLocalFS() FS {
+	return &localFS{}
+}
+
 func (f *localFS) Mkdir(name string, perm FileMode) error {
 	err := os.Mkdir(name, perm)
 	if err != nil {
@@ -382,6 +410,32 @@ func (f *localFS) ReadDir(name string) ([]DirEntry, error) {
 	return entries, nil
 }
 
+// mkdirTemp creates a directory with a unique name in the slash-separated dir
+// with mkdir, following the rules of os.MkdirTemp for pattern, and returns its
+// path.
+func mkdirTemp(dir, pattern string, mkdir func(name string) error) (string, error) {
+	if strings.Contains(pattern, "/") {
+		return "", fs.ErrInvalid
+	}
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+
+	for range 10000 {
+		name := path.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+suffix)
+		err := mkdir(name)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	return "", fs.ErrExist
+}
+
 type (
 	// FileMode represents a file's mode and permission bits.
 	FileMode = fs.FileMode
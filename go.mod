@@ -3,11 +3,14 @@ module github.com/foohq/ren
 go 1.26
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/deepnoodle-ai/risor/v2 v2.1.0
 	github.com/foohq/urlpath v0.2.0
 	github.com/stretchr/testify v1.11.1
 	github.com/urfave/cli/v3 v3.8.0
+	golang.org/x/mod v0.35.0
 	golang.org/x/sys v0.43.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -18,5 +21,4 @@ require (
 	github.com/rogpeppe/go-internal v1.13.1 // indirect
 	github.com/stretchr/objx v0.5.3 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
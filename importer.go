@@ -1,18 +1,21 @@
 package ren
 
 import (
-	"archive/zip"
 	"context"
 	"fmt"
-	"io"
+	"io/fs"
+	"maps"
+	"slices"
 	"strings"
 	"sync"
 
-	"github.com/deepnoodle-ai/risor/v2/pkg/compiler"
+	"github.com/deepnoodle-ai/risor/v2/pkg/bytecode"
 	"github.com/deepnoodle-ai/risor/v2/pkg/object"
 	"github.com/deepnoodle-ai/risor/v2/pkg/vm"
 
 	"github.com/foohq/urlpath"
+
+	"github.com/foohq/ren/packager"
 )
 
 // Import URL schemes.
@@ -24,6 +27,9 @@ const (
 	// import("package://path/to/mod"). It is the explicit form of a bare
 	// package path.
 	packageScheme = "package"
+	// dependencyScheme imports a module from a dependency of the package,
+	// e.g. import("pkg://logging/json").
+	dependencyScheme = "pkg"
 )
 
 // moduleExt is the file extension of compiled modules inside a package.
@@ -36,32 +42,72 @@ const moduleExt = ".json"
 //
 //   - import("path/to/mod")           loads a compiled module from the package,
 //     relative to the package root.
+//   - import("./mod"), import("../mod") loads a compiled module from the
+//     package, relative to the directory of the importing module.
 //   - import("package://path/to/mod") is the explicit form of the above.
+//   - import("path/to/data.json")     returns the contents of a data file of
+//     the package, decoded (see importData). The forms above name a data file
+//     if the package has one at the path and has no compiled module there.
+//   - import("pkg://dep/path/to/mod") loads a compiled module, or a data file,
+//     from the dependency dep of the package (see WithPackageDependency).
 //   - import("builtin://name")        returns a built-in module registered with
 //     the runtime.
+//   - import("scheme://path/to/mod")  loads a module from the resolver
+//     registered for the scheme with WithResolver. A relative name imported by
+//     such a module resolves against it, within the same scheme.
 //
-// A package module is executed at most once; its exports are cached and reused
-// for subsequent imports. The packager compiles each module as a self-contained
-// function returning a map of its top-level names, so running the module yields
-// that map directly and its exported functions are usable in the importing VM.
+// A package or resolved module is executed, and a data file decoded, at most
+// once; its exports are cached and reused for subsequent imports, while each
+// import of a data file gets a copy of its value. Each dependency has an
+// Importer of its own, with its own module cache, that resolves the imports of
+// the dependency's modules within the dependency. The packager compiles each
+// module as a self-contained function returning a map of its top-level names,
+// so running the module yields that map directly and its exported functions are
+// usable in the importing VM.
 type Importer struct {
-	pkg      *zip.Reader
-	builtins map[string]*object.Module
-	env      map[string]any
+	files     fs.FS
+	modules   map[string]*bytecode.Code
+	builtins  map[string]*object.Module
+	resolvers map[string]ModuleResolver
+	env       map[string]any
+	policy    *Policy
+	limits    *limiter
+
+	// deps holds the importers of the package's dependencies, by name.
+	deps map[string]*Importer
+	// dependency is true if the package is a dependency of another.
+	dependency bool
 
 	mu      sync.Mutex
 	cache   map[string]object.Object
 	loading map[string]struct{}
 }
 
-func newImporter(pkg *zip.Reader, builtins map[string]*object.Module, env map[string]any) *Importer {
+func newImporter(files fs.FS, modules map[string]*bytecode.Code, builtins map[string]*object.Module, resolvers map[string]ModuleResolver, env map[string]any, policy *Policy, limits *limiter) *Importer {
 	return &Importer{
-		pkg:      pkg,
-		builtins: builtins,
-		env:      env,
-		cache:    make(map[string]object.Object),
-		loading:  make(map[string]struct{}),
+		files:     files,
+		modules:   modules,
+		builtins:  builtins,
+		resolvers: resolvers,
+		env:       env,
+		policy:    policy,
+		limits:    limits,
+		deps:      make(map[string]*Importer),
+		cache:     make(map[string]object.Object),
+		loading:   make(map[string]struct{}),
+	}
+}
+
+// newImporter returns an Importer for the modules of the program and, in turn,
+// of its dependencies.
+func (p *Program) newImporter(builtins map[string]*object.Module, resolvers map[string]ModuleResolver, env map[string]any, policy *Policy, limits *limiter) *Importer {
+	imp := newImporter(p.files, p.modules, builtins, resolvers, env, policy, limits)
+	for name, dep := range p.deps {
+		depImp := dep.newImporter(builtins, resolvers, env, policy, limits)
+		depImp.dependency = true
+		imp.deps[name] = depImp
 	}
+	return imp
 }
 
 type importerContextKey struct{}
@@ -77,9 +123,62 @@ func GetImporter(ctx context.Context) *Importer {
 	return imp
 }
 
+// moduleScope identifies the module whose code is running: its path within its
+// package, e.g. "lib/util.json", and the Importer of that package. For a module
+// of a resolver, scheme is the resolver's scheme and path the path of the
+// module within it, e.g. "lib/util".
+type moduleScope struct {
+	importer *Importer
+	scheme   string
+	path     string
+}
+
+type moduleContextKey struct{}
+
+// withModule returns a new context recording that the module of scope is
+// running, so that imports made by its code resolve within its package and
+// relative ones against its directory.
+func withModule(ctx context.Context, scope moduleScope) context.Context {
+	return context.WithValue(ctx, moduleContextKey{}, scope)
+}
+
+// currentModule returns the module whose code is running, or the zero
+// moduleScope for the entrypoint.
+func currentModule(ctx context.Context) moduleScope {
+	scope, _ := ctx.Value(moduleContextKey{}).(moduleScope)
+	return scope
+}
+
 // Import resolves name to a module. See the Importer documentation for the
-// supported import forms.
+// supported import forms. Imports made by a module of a dependency resolve
+// within the dependency. Relative names resolve against the module being
+// loaded, as recorded on ctx, or against the package root for the entrypoint.
+// The packager resolves relative names given as string literals when it builds
+// the package, so that those also work inside functions called after the
+// module has loaded. An error records the stack of the script that called
+// import, so that Error.Stack can include it.
 func (imp *Importer) Import(ctx context.Context, name string) (object.Object, error) {
+	mod, err := imp.importName(ctx, name)
+	if err != nil {
+		return nil, &importError{err: err, stack: callerStack(ctx)}
+	}
+	return mod, nil
+}
+
+// importName implements Import.
+func (imp *Importer) importName(ctx context.Context, name string) (object.Object, error) {
+	scope := currentModule(ctx)
+	if scope.importer != nil && scope.importer != imp {
+		return scope.importer.importName(ctx, name)
+	}
+	if scope.scheme != "" && packager.IsRelativeImport(name) {
+		pth, err := resolvedPath(scope.path, name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot import %q: %w", name, err)
+		}
+		name = scope.scheme + "://" + pth
+	}
+
 	scheme, err := urlpath.Scheme(name)
 	if err != nil {
 		return nil, fmt.Errorf("cannot import %q: %w", name, err)
@@ -88,15 +187,23 @@ func (imp *Importer) Import(ctx context.Context, name string) (object.Object, er
 	switch scheme {
 	case "", packageScheme:
 		return imp.importPackage(ctx, name)
+	case dependencyScheme:
+		return imp.importDependency(ctx, name)
 	case builtinScheme:
 		return imp.importBuiltin(name)
 	default:
+		if resolver, ok := imp.resolvers[scheme]; ok {
+			return imp.importResolved(ctx, name, scheme, resolver)
+		}
 		return nil, fmt.Errorf("cannot import %q: unsupported scheme %q", name, scheme)
 	}
 }
 
 func (imp *Importer) importBuiltin(name string) (object.Object, error) {
 	modName := strings.TrimPrefix(name, builtinScheme+"://")
+	if !imp.policy.allowModule(modName) {
+		return nil, &PolicyError{Op: "import", Name: name}
+	}
 	mod, ok := imp.builtins[modName]
 	if !ok {
 		return nil, fmt.Errorf("cannot import %q: no such built-in module", name)
@@ -104,61 +211,93 @@ func (imp *Importer) importBuiltin(name string) (object.Object, error) {
 	return mod, nil
 }
 
+func (imp *Importer) importDependency(ctx context.Context, name string) (object.Object, error) {
+	dep, pth, err := packager.DependencyPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot import %q: %w", name, err)
+	}
+	depImp, ok := imp.deps[dep]
+	if !ok {
+		return nil, fmt.Errorf("cannot import %q: no such dependency", name)
+	}
+	return depImp.importFile(ctx, name, pth)
+}
+
 func (imp *Importer) importPackage(ctx context.Context, name string) (object.Object, error) {
 	pkgName := strings.TrimPrefix(name, packageScheme+"://")
-	pth, err := packagePath(pkgName)
+	pth, err := packager.PackagePath(currentModule(ctx).path, pkgName)
 	if err != nil {
 		return nil, fmt.Errorf("cannot import %q: %w", name, err)
 	}
+	return imp.importFile(ctx, name, pth)
+}
+
+// importFile imports the module or the data file at pth of the package. A
+// compiled module takes precedence over a data file.
+func (imp *Importer) importFile(ctx context.Context, name, pth string) (object.Object, error) {
+	modPth := packager.ModulePath(pth)
+	if _, ok := imp.modules[modPth]; !ok && imp.isDataFile(pth) {
+		return imp.importData(ctx, name, pth)
+	}
+	return imp.importModule(ctx, name, modPth)
+}
 
+// importModule imports the module at pth of the package, once.
+func (imp *Importer) importModule(ctx context.Context, name, pth string) (object.Object, error) {
+	return imp.importOnce(name, pth, func() (object.Object, error) {
+		code, ok := imp.modules[pth]
+		if !ok {
+			return nil, fmt.Errorf("cannot import %q: open %s: %w", name, pth, fs.ErrNotExist)
+		}
+		return imp.runModule(ctx, name, code, moduleScope{importer: imp, path: pth})
+	})
+}
+
+// importOnce returns the module cached under key, or loads it with load and
+// caches it. Loading a module while it is being loaded is an import cycle.
+func (imp *Importer) importOnce(name, key string, load func() (object.Object, error)) (object.Object, error) {
 	imp.mu.Lock()
-	if mod, ok := imp.cache[pth]; ok {
+	if mod, ok := imp.cache[key]; ok {
 		imp.mu.Unlock()
 		return mod, nil
 	}
-	if _, ok := imp.loading[pth]; ok {
+	if _, ok := imp.loading[key]; ok {
 		imp.mu.Unlock()
 		return nil, fmt.Errorf("cannot import %q: import cycle detected", name)
 	}
-	imp.loading[pth] = struct{}{}
+	imp.loading[key] = struct{}{}
 	imp.mu.Unlock()
 
 	defer func() {
 		imp.mu.Lock()
-		delete(imp.loading, pth)
+		delete(imp.loading, key)
 		imp.mu.Unlock()
 	}()
 
-	mod, err := imp.loadPackage(ctx, name, pth)
+	mod, err := load()
 	if err != nil {
 		return nil, err
 	}
 
 	imp.mu.Lock()
-	imp.cache[pth] = mod
+	imp.cache[key] = mod
 	imp.mu.Unlock()
 
 	return mod, nil
 }
 
-// loadPackage reads and runs a compiled module from the package, returning it
-// as a module object. The packager compiles a module as a function that returns
+// runModule runs the compiled module of scope, returning it as a module
+// object. The packager compiles a module as a function that returns
 // a map of its top-level names, so running it yields that map; the exported
 // functions are self-contained closures usable directly by the importing
 // script. The map is wrapped in a module so that attribute access is not
 // shadowed by the built-in methods of a map (get, keys, values, ...).
-func (imp *Importer) loadPackage(ctx context.Context, name, pth string) (object.Object, error) {
-	b, err := imp.readFile(pth)
-	if err != nil {
-		return nil, fmt.Errorf("cannot import %q: %w", name, err)
-	}
-
-	code, err := compiler.UnmarshalCode(b)
-	if err != nil {
-		return nil, fmt.Errorf("cannot import %q: %w", name, err)
-	}
-
-	result, err := vm.Run(ctx, code.ToBytecode(), vm.WithGlobals(imp.env))
+func (imp *Importer) runModule(ctx context.Context, name string, code *bytecode.Code, scope moduleScope) (object.Object, error) {
+	// The module draws on the same execution limits as the importing script.
+	result, err := vm.Run(withModule(ctx, scope), code,
+		vm.WithGlobals(imp.env),
+		vm.WithObserver(imp.limits),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("cannot import %q: %w", name, err)
 	}
@@ -168,34 +307,99 @@ func (imp *Importer) loadPackage(ctx context.Context, name, pth string) (object.
 		return nil, fmt.Errorf("cannot import %q: module did not produce exports", name)
 	}
 
+	if imp.dependency || scope.scheme != "" {
+		// The functions of a dependency or of a resolver run in the VM of
+		// their caller. Bind them to the module so that the imports they make
+		// resolve within the dependency, or against the resolved module.
+		exports = bindValue(exports, scope).(*object.Map)
+	}
+
 	return object.NewBuiltinsModule(name, exports.Value()), nil
 }
 
-func (imp *Importer) readFile(pth string) ([]byte, error) {
-	f, err := imp.pkg.Open(pth)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		_ = f.Close()
-	}()
-	return io.ReadAll(f)
+// bindModule returns a builtin calling fn, a function of the module of scope,
+// with that module recorded as running. The functions fn is passed are bound
+// in turn to the module of its caller, and those it returns to the module of
+// scope, so that every function resolves its imports in the module that
+// defines it, whoever calls it.
+func bindModule(fn *object.Closure, scope moduleScope) *object.Builtin {
+	return object.NewBuiltin(fn.Name(), func(ctx context.Context, args ...object.Object) (object.Object, error) {
+		caller := currentModule(ctx)
+		args = slices.Clone(args)
+		for i, arg := range args {
+			args[i] = bindValue(arg, caller)
+		}
+		result, err := fn.Call(withModule(ctx, scope), args...)
+		if err != nil {
+			return nil, err
+		}
+		return bindValue(result, scope), nil
+	})
 }
 
-// packagePath converts an import name into a slash path within the package,
-// rooted at the package root. It rejects paths that escape the root.
-func packagePath(name string) (string, error) {
-	pth, err := urlpath.Path(name)
-	if err != nil {
-		return "", err
+// bindValue binds the functions of value to the module of scope with
+// bindModule. A function is returned bound. Maps and lists holding functions,
+// however deeply, are returned as copies holding the bound functions, so that
+// the objects the module or its caller keep are left as they are. Those
+// holding none are returned as they are, and stay shared.
+func bindValue(value object.Object, scope moduleScope) object.Object {
+	return bindValues(value, scope, make(map[object.Object]object.Object))
+}
+
+// bindValues implements bindValue. copies maps each function bound and each
+// container copied so far to the result, so that values shared or holding
+// themselves are bound once.
+func bindValues(value object.Object, scope moduleScope, copies map[object.Object]object.Object) object.Object {
+	if c, ok := copies[value]; ok {
+		return c
+	}
+	if !holdsFunctions(value, make(map[object.Object]bool)) {
+		return value
 	}
-	pth = strings.TrimPrefix(pth, "/")
-	if pth == "" || pth == "." || strings.HasPrefix(pth, "./") || pth == ".." || strings.HasPrefix(pth, "../") {
-		return "", fmt.Errorf("invalid import path")
+	switch value := value.(type) {
+	case *object.Closure:
+		c := bindModule(value, scope)
+		copies[value] = c
+		return c
+	case *object.Map:
+		items := make(map[string]object.Object, value.Size())
+		c := object.NewMap(items)
+		copies[value] = c
+		for key, item := range value.Value() {
+			items[key] = bindValues(item, scope, copies)
+		}
+		return c
+	case *object.List:
+		items := make([]object.Object, len(value.Value()))
+		c := object.NewList(items)
+		copies[value] = c
+		for i, item := range value.Value() {
+			items[i] = bindValues(item, scope, copies)
+		}
+		return c
 	}
+	return value
+}
 
-	if !strings.HasSuffix(pth, moduleExt) {
-		pth += moduleExt
+// holdsFunctions reports whether value is a function or a map or list holding
+// one, however deeply. seen records the containers visited so far.
+func holdsFunctions(value object.Object, seen map[object.Object]bool) bool {
+	var items []object.Object
+	switch value := value.(type) {
+	case *object.Closure:
+		return true
+	case *object.Map:
+		items = slices.Collect(maps.Values(value.Value()))
+	case *object.List:
+		items = value.Value()
+	default:
+		return false
+	}
+	if seen[value] {
+		return false
 	}
-	return pth, nil
+	seen[value] = true
+	return slices.ContainsFunc(items, func(item object.Object) bool {
+		return holdsFunctions(item, seen)
+	})
 }
@@ -0,0 +1,152 @@
+package ren
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+	"github.com/deepnoodle-ai/risor/v2/pkg/vm"
+
+	"github.com/foohq/ren/packager"
+)
+
+// Instance is a program whose entrypoint has run to completion and whose
+// top-level names remain available to the host. It lets Go code call the
+// functions a script defines, e.g. to dispatch events to a plugin.
+//
+// The exports of an instance are the names the entrypoint declares at the top
+// level, those an imported module returns in its export map (see
+// packager.Exports). Calls run on the VM that ran the entrypoint, so state kept
+// in top-level variables persists between them. An Instance is safe for
+// concurrent use, but executes one call at a time.
+type Instance struct {
+	rt      *runtime
+	exports []string
+
+	mu sync.Mutex
+	vm *vm.VirtualMachine
+}
+
+// Instantiate runs the program's entrypoint and returns an Instance exposing
+// its top-level names. The options apply to the entrypoint and to every later
+// call; execution limits are enforced on each of them separately. Like Run, it
+// checks the package signature and requirements before running any code.
+func (p *Program) Instantiate(ctx context.Context, opt ...Option) (*Instance, error) {
+	exports, err := packager.Exports(p.entrypoint)
+	if err != nil {
+		return nil, &Error{err: err, prog: p}
+	}
+
+	rt := p.newRuntime(ctx, opt)
+	err = rt.check()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := rt.begin(ctx)
+	defer stop()
+
+	machine, err := vm.New(p.entrypoint, rt.vmOptions()...)
+	if err != nil {
+		return nil, &Error{err: err, prog: p}
+	}
+	err = machine.Run(vmContext(ctx))
+	if err != nil {
+		return nil, rt.error(ctx, err)
+	}
+
+	return &Instance{
+		rt:      rt,
+		exports: exports,
+		vm:      machine,
+	}, nil
+}
+
+// vmContext returns ctx for the VM of an Instance to run with. A VM watches
+// the context of every run until that context is done, even once the run is
+// over, and would then halt whichever call it is executing; the context of a
+// call is therefore hidden from the VM, which the runtime's limiter halts
+// instead.
+func vmContext(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
+}
+
+// Exports returns the sorted names of the values exported by the instance.
+func (i *Instance) Exports() []string {
+	return slices.Clone(i.exports)
+}
+
+// export returns the current value of the exported name. i.mu must be held.
+func (i *Instance) export(name string) (object.Object, bool) {
+	if !slices.Contains(i.exports, name) {
+		return nil, false
+	}
+	obj, err := i.vm.Get(name)
+	if err != nil {
+		return nil, false
+	}
+	return obj, true
+}
+
+// Get returns the current value of the exported name, converted to a Go value
+// as described for Call.
+func (i *Instance) Get(name string) (any, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	obj, ok := i.export(name)
+	if !ok {
+		return nil, fmt.Errorf("%q is not exported", name)
+	}
+	return fromObject(obj), nil
+}
+
+// Call calls the exported function name with args and returns its result.
+//
+// Arguments are converted to Risor objects: nil, booleans, numbers, strings,
+// byte slices, errors, slices and maps with string keys are supported, as are
+// object.Object values, which are passed through. The result is converted back
+// to a Go value of the matching type: lists become []any and maps become
+// map[string]any. An error value returned by the function is reported as the
+// result, not as Call's error, which is reserved for failures to execute it.
+// Values without a Go equivalent, such as functions, are returned as
+// object.Object.
+func (i *Instance) Call(ctx context.Context, name string, args ...any) (any, error) {
+	argv := make([]object.Object, len(args))
+	for n, arg := range args {
+		obj, err := toObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot call %q: argument %d: %w", name, n, err)
+		}
+		argv[n] = obj
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	fn, ok := i.export(name)
+	if !ok {
+		return nil, fmt.Errorf("cannot call %q: not exported", name)
+	}
+
+	ctx, stop := i.rt.begin(ctx)
+	defer stop()
+
+	var result object.Object
+	var err error
+	switch fn := fn.(type) {
+	case *object.Closure:
+		result, err = i.vm.Call(vmContext(ctx), fn, argv)
+	case object.Callable:
+		result, err = fn.Call(ctx, argv...)
+	default:
+		return nil, fmt.Errorf("cannot call %q: not a function", name)
+	}
+	if err != nil {
+		return nil, i.rt.error(ctx, err)
+	}
+
+	return fromObject(result), nil
+}
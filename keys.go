@@ -0,0 +1,68 @@
+package ren
+
+import (
+	"archive/zip"
+	"context"
+	"io/fs"
+
+	"github.com/foohq/ren/packager"
+)
+
+// KeyProvider supplies the keys to decrypt encrypted packages with, e.g. from
+// a secrets manager or a hardware token. See WithKeyProvider.
+type KeyProvider interface {
+	// DecryptionKey returns the key whose ID, as computed by packager.KeyID,
+	// is keyID. It returns a nil key and no error if it does not hold the
+	// key.
+	DecryptionKey(ctx context.Context, keyID string) ([]byte, error)
+}
+
+// KeyProviderFunc adapts a function to the KeyProvider interface.
+type KeyProviderFunc func(ctx context.Context, keyID string) ([]byte, error)
+
+// DecryptionKey calls f(ctx, keyID).
+func (f KeyProviderFunc) DecryptionKey(ctx context.Context, keyID string) ([]byte, error) {
+	return f(ctx, keyID)
+}
+
+// decrypt returns the contents of the package, decrypted if the package is
+// encrypted.
+func (o *options) decrypt(ctx context.Context, zr *zip.Reader) (fs.FS, error) {
+	keyID, err := packager.EncryptionKeyID(zr)
+	if err != nil {
+		return nil, err
+	}
+	if keyID == "" {
+		return zr, nil
+	}
+
+	key, err := o.decryptionKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return packager.Decrypt(zr, key)
+}
+
+// decryptionKey returns the key with the ID keyID, looking first at the keys
+// added with WithDecryptionKey and then asking the key providers.
+func (o *options) decryptionKey(ctx context.Context, keyID string) ([]byte, error) {
+	for _, key := range o.decryptionKeys {
+		if packager.KeyID(key) == keyID {
+			return key, nil
+		}
+	}
+	for _, provider := range o.keyProviders {
+		key, err := provider.DecryptionKey(ctx, keyID)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			// A key that does not match is reported by packager.Decrypt.
+			return key, nil
+		}
+	}
+	if len(o.decryptionKeys) > 0 {
+		return nil, packager.ErrWrongKey
+	}
+	return nil, packager.ErrMissingKey
+}
@@ -0,0 +1,126 @@
+package ren
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/deepnoodle-ai/risor/v2/pkg/vm"
+)
+
+// Limit identifies an execution limit.
+type Limit int
+
+// Execution limits.
+//
+// There is no memory limit. The Go runtime counts heap allocations for the
+// whole process, not per goroutine, and the Risor VM does not account for the
+// values a script creates, so a run cannot be charged for the memory it uses
+// without also charging it for every other run and for the host. A host that
+// must bound the memory of scripts runs them in a process of their own, e.g.
+// under GOMEMLIMIT or an operating system resource limit.
+const (
+	// LimitSteps is the instruction budget set by WithMaxSteps.
+	LimitSteps Limit = iota + 1
+	// LimitTimeout is the wall-clock deadline set by WithTimeout.
+	LimitTimeout
+)
+
+// String returns the name of the limit.
+func (l Limit) String() string {
+	switch l {
+	case LimitSteps:
+		return "steps"
+	case LimitTimeout:
+		return "timeout"
+	default:
+		return fmt.Sprintf("Limit(%d)", int(l))
+	}
+}
+
+// LimitError is returned by Run when the script exceeds one of its execution
+// limits. Memory use is not limited (see Limit).
+type LimitError struct {
+	// Limit is the limit that was hit.
+	Limit Limit
+	// Max is the configured value of the limit: a number of instructions or a
+	// duration in nanoseconds.
+	Max int64
+}
+
+// Error returns the error message.
+func (e *LimitError) Error() string {
+	limit := fmt.Sprint(e.Max)
+	if e.Limit == LimitTimeout {
+		limit = time.Duration(e.Max).String()
+	}
+	return fmt.Sprintf("execution limit exceeded: %s (max %s)", e.Limit, limit)
+}
+
+// stepSampleInterval is how many instructions the VM executes between limit
+// checks. Step counting is therefore approximate: a script may run up to this
+// many instructions past its budget.
+const stepSampleInterval = 100
+
+var _ vm.Observer = (*limiter)(nil)
+
+// limiter enforces the step limit of a single run, if any. It observes
+// every VM of the run — the entrypoint's and those of imported modules — so
+// they all draw on the same budget. When the limit is hit it cancels the run's
+// context with a *LimitError as the cause.
+//
+// An Instance reuses its limiter for every call; reset starts a fresh budget.
+// The VM of an Instance does not watch the context of a call (see
+// Instance.Call), so the limiter also halts it once that context is done.
+type limiter struct {
+	maxSteps int64
+	cancel   context.CancelCauseFunc
+	done     <-chan struct{}
+
+	steps atomic.Int64
+}
+
+func newLimiter(maxSteps int64) *limiter {
+	return &limiter{
+		maxSteps: maxSteps,
+	}
+}
+
+// reset starts a fresh budget for the run whose context is done when done is
+// closed, reporting an exceeded limit to cancel. It must not be called while
+// script code is executing.
+func (l *limiter) reset(done <-chan struct{}, cancel context.CancelCauseFunc) {
+	l.cancel = cancel
+	l.done = done
+	l.steps.Store(0)
+}
+
+func (l *limiter) Config() vm.ObserverConfig {
+	return vm.ObserverConfig{
+		StepMode:       vm.StepSampled,
+		SampleInterval: stepSampleInterval,
+	}
+}
+
+func (l *limiter) OnStep(vm.StepEvent) bool {
+	select {
+	case <-l.done:
+		return false
+	default:
+	}
+	steps := l.steps.Add(stepSampleInterval)
+	if l.maxSteps > 0 && steps > l.maxSteps {
+		l.cancel(&LimitError{Limit: LimitSteps, Max: l.maxSteps})
+		return false
+	}
+	return true
+}
+
+func (l *limiter) OnCall(vm.CallEvent) bool {
+	return true
+}
+
+func (l *limiter) OnReturn(vm.ReturnEvent) bool {
+	return true
+}
@@ -0,0 +1,849 @@
+package ren
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var _ FS = (*MemFS)(nil)
+
+// maxSymlinks is how many symbolic links MemFS follows while resolving a path
+// before giving up with syscall.ELOOP.
+const maxSymlinks = 40
+
+// MemFS is a filesystem held entirely in memory. Registered with
+// WithFilesystem, e.g. for the file scheme, it gives a run an isolated scratch
+// space that disappears with it. It is safe for concurrent use.
+//
+// Paths are slash-separated and rooted at "/"; relative paths are resolved
+// against the root, and no path reaches above it. Errors are those of the os
+// package, unwrapped as localFS reports them: fs.ErrNotExist, fs.ErrExist,
+// fs.ErrPermission and, where os would report one, a syscall.Errno such as
+// syscall.ENOTDIR or syscall.ENOTEMPTY. Of the permission bits, those of the
+// owner are enforced: reading a file or directory requires its read bit, and
+// writing a file or creating, removing and renaming entries in a directory
+// requires the write bit.
+type MemFS struct {
+	mu   sync.Mutex
+	root *memNode
+}
+
+// memNode is a file, a directory or a symbolic link of a MemFS.
+type memNode struct {
+	mode    FileMode
+	modTime time.Time
+	// data is the content of a file.
+	data []byte
+	// target is the path a symbolic link points to, as given to Symlink.
+	target string
+	// children holds the entries of a directory by name.
+	children map[string]*memNode
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		root: newMemDir(0o755, time.Now()),
+	}
+}
+
+// NewMemFSFrom returns a MemFS holding a copy of the files, directories and
+// symbolic links of fsys, with their modes and modification times. Entries
+// without permission bits, as those of fstest.MapFS usually are, get 0644, or
+// 0755 for directories. Other kinds of files are skipped.
+func NewMemFSFrom(fsys fs.FS) (*MemFS, error) {
+	m := NewMemFS()
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		n := &memNode{
+			mode:    info.Mode(),
+			modTime: info.ModTime(),
+		}
+		switch {
+		case info.IsDir():
+			n.children = make(map[string]*memNode)
+		case info.Mode()&fs.ModeSymlink != 0:
+			n.target, err = fs.ReadLink(fsys, name)
+		case info.Mode().IsRegular():
+			n.data, err = fs.ReadFile(fsys, name)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n.mode.Perm() == 0 && !n.isSymlink() {
+			n.mode |= defaultMemPerm(n.isDir())
+		}
+		return m.add("/"+name, n)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewMemFSFromMap returns a MemFS holding files, which maps paths to their
+// contents. The files get mode 0644 and their parent directories 0755; a path
+// ending in a slash creates an empty directory.
+func NewMemFSFromMap(files map[string][]byte) (*MemFS, error) {
+	m := NewMemFS()
+	now := time.Now()
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		var n *memNode
+		if strings.HasSuffix(name, "/") {
+			n = newMemDir(0o755, now)
+		} else {
+			n = &memNode{mode: 0o644, modTime: now, data: slices.Clone(files[name])}
+		}
+		err := m.add(name, n)
+		if err != nil {
+			return nil, &fs.PathError{Op: "create", Path: name, Err: err}
+		}
+	}
+	return m, nil
+}
+
+func (m *MemFS) Mkdir(name string, perm FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mkdir(memPath(name), perm)
+}
+
+func (m *MemFS) MkdirAll(path string, perm FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mkdirAll(memPath(path), perm)
+}
+
+// MkdirTemp creates a directory with a unique name in dir, following the rules
+// of os.MkdirTemp. An empty dir stands for "/tmp", which is created if needed.
+func (m *MemFS) MkdirTemp(dir, pattern string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if dir == "" {
+		dir = "/tmp"
+		err := m.mkdirAll(dir, 0o777)
+		if err != nil {
+			return "", err
+		}
+	}
+	return mkdirTemp(memPath(dir), pattern, func(name string) error {
+		return m.mkdir(name, 0o700)
+	})
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pth := memPath(name)
+	n, _, err := m.walk(pth, true, new(int))
+	created := false
+	switch {
+	case err == nil:
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, fs.ErrExist
+		}
+	case errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE != 0:
+		n, err = m.create(pth, &memNode{mode: perm.Perm(), modTime: time.Now()})
+		if err != nil {
+			return nil, err
+		}
+		created = true
+	default:
+		return nil, err
+	}
+
+	f := &memFile{fs: m, node: n, name: path.Base(name), flag: flag}
+	if n.isDir() {
+		if f.writable() || flag&os.O_TRUNC != 0 {
+			return nil, syscall.EISDIR
+		}
+	}
+	if !created && (f.readable() && n.mode&0o400 == 0 || f.writable() && n.mode&0o200 == 0) {
+		return nil, fs.ErrPermission
+	}
+	if flag&os.O_TRUNC != 0 && f.writable() && len(n.data) > 0 {
+		n.data = nil
+		n.modTime = time.Now()
+	}
+	return f, nil
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, _, err := m.walk(memPath(name), true, new(int))
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir() {
+		return nil, syscall.EISDIR
+	}
+	if n.mode&0o400 == 0 {
+		return nil, fs.ErrPermission
+	}
+	return slices.Clone(n.data), nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pth := memPath(name)
+	if pth == "/" {
+		return fs.ErrPermission
+	}
+	dir, _, base, err := m.walkParent(pth, new(int))
+	if err != nil {
+		return err
+	}
+	n, ok := dir.children[base]
+	if !ok {
+		return fs.ErrNotExist
+	}
+	if n.isDir() && len(n.children) > 0 {
+		return syscall.ENOTEMPTY
+	}
+	if dir.mode&0o200 == 0 {
+		return fs.ErrPermission
+	}
+	delete(dir.children, base)
+	dir.modTime = time.Now()
+	return nil
+}
+
+// RemoveAll removes path and everything it contains, following the rules of
+// os.RemoveAll: a path that does not exist is not an error. Removing the root
+// empties the filesystem.
+func (m *MemFS) RemoveAll(path string) error {
+	if path == "" || path == "." || strings.HasSuffix(path, "/.") {
+		return fs.ErrInvalid
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pth := memPath(path)
+	if pth == "/" {
+		m.root.children = make(map[string]*memNode)
+		m.root.modTime = time.Now()
+		return nil
+	}
+	dir, _, base, err := m.walkParent(pth, new(int))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := dir.children[base]; !ok {
+		return nil
+	}
+	if dir.mode&0o200 == 0 {
+		return fs.ErrPermission
+	}
+	delete(dir.children, base)
+	dir.modTime = time.Now()
+	return nil
+}
+
+// Rename moves oldPath to newPath, following the rules of os.Rename: an
+// existing file at newPath is replaced, as is an empty directory if oldPath is
+// a directory too.
+func (m *MemFS) Rename(oldPath, newPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldPth, newPth := memPath(oldPath), memPath(newPath)
+	if oldPth == "/" || newPth == "/" {
+		return fs.ErrPermission
+	}
+	oldDir, oldDirPath, oldBase, err := m.walkParent(oldPth, new(int))
+	if err != nil {
+		return err
+	}
+	n, ok := oldDir.children[oldBase]
+	if !ok {
+		return fs.ErrNotExist
+	}
+	newDir, newDirPath, newBase, err := m.walkParent(newPth, new(int))
+	if err != nil {
+		return err
+	}
+
+	oldReal, newReal := path.Join(oldDirPath, oldBase), path.Join(newDirPath, newBase)
+	if oldReal == newReal {
+		return nil
+	}
+	if n.isDir() && strings.HasPrefix(newReal, oldReal+"/") {
+		return syscall.EINVAL
+	}
+	if oldDir.mode&0o200 == 0 || newDir.mode&0o200 == 0 {
+		return fs.ErrPermission
+	}
+	if existing, ok := newDir.children[newBase]; ok {
+		switch {
+		case existing.isDir() && !n.isDir():
+			return syscall.EISDIR
+		case !existing.isDir() && n.isDir():
+			return syscall.ENOTDIR
+		case existing.isDir() && len(existing.children) > 0:
+			return syscall.ENOTEMPTY
+		}
+	}
+
+	delete(oldDir.children, oldBase)
+	newDir.children[newBase] = n
+	now := time.Now()
+	oldDir.modTime = now
+	newDir.modTime = now
+	return nil
+}
+
+// Stat returns information about the file name, following symbolic links.
+func (m *MemFS) Stat(name string) (FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, _, err := m.walk(memPath(name), true, new(int))
+	if err != nil {
+		return nil, err
+	}
+	return n.info(path.Base(name)), nil
+}
+
+// Symlink creates newName as a symbolic link to oldName. A relative oldName is
+// resolved against the directory of the link when the link is followed.
+func (m *MemFS) Symlink(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.create(memPath(newName), &memNode{
+		mode:    fs.ModeSymlink | 0o777,
+		modTime: time.Now(),
+		target:  oldName,
+	})
+	return err
+}
+
+func (m *MemFS) WriteFile(name string, data []byte, perm FileMode) error {
+	f, err := m.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	return err
+}
+
+// ReadDir returns the entries of the directory name, sorted by name.
+func (m *MemFS) ReadDir(name string) ([]DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, _, err := m.walk(memPath(name), true, new(int))
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir() {
+		return nil, syscall.ENOTDIR
+	}
+	if n.mode&0o400 == 0 {
+		return nil, fs.ErrPermission
+	}
+	return n.entries(), nil
+}
+
+// FS returns a read-only view of the filesystem as an fs.FS, rooted at "/",
+// so that a host can examine what a script left behind with the io/fs
+// functions. The view also implements fs.ReadFileFS, fs.ReadDirFS,
+// fs.StatFS and fs.ReadLinkFS.
+func (m *MemFS) FS() fs.FS {
+	return memIOFS{m}
+}
+
+// Lstat returns information about the file name like Stat, but about the
+// symbolic link itself if name is one.
+func (m *MemFS) Lstat(name string) (FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, _, err := m.walk(memPath(name), false, new(int))
+	if err != nil {
+		return nil, err
+	}
+	return n.info(path.Base(name)), nil
+}
+
+// Readlink returns the target of the symbolic link name, as given to Symlink.
+// It fails with fs.ErrInvalid if name is not a symbolic link.
+func (m *MemFS) Readlink(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, _, err := m.walk(memPath(name), false, new(int))
+	if err != nil {
+		return "", err
+	}
+	if !n.isSymlink() {
+		return "", fs.ErrInvalid
+	}
+	return n.target, nil
+}
+
+func (m *MemFS) mkdir(pth string, perm FileMode) error {
+	if pth == "/" {
+		return fs.ErrExist
+	}
+	_, err := m.create(pth, newMemDir(perm.Perm(), time.Now()))
+	return err
+}
+
+func (m *MemFS) mkdirAll(pth string, perm FileMode) error {
+	n, _, err := m.walk(pth, true, new(int))
+	if err == nil {
+		if n.isDir() {
+			return nil
+		}
+		return syscall.ENOTDIR
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	err = m.mkdirAll(path.Dir(pth), perm)
+	if err != nil {
+		return err
+	}
+	return m.mkdir(pth, perm)
+}
+
+// create adds n to the filesystem at the cleaned path pth, which must not
+// exist, and returns it.
+func (m *MemFS) create(pth string, n *memNode) (*memNode, error) {
+	dir, _, base, err := m.walkParent(pth, new(int))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := dir.children[base]; ok {
+		return nil, fs.ErrExist
+	}
+	if dir.mode&0o200 == 0 {
+		return nil, fs.ErrPermission
+	}
+	dir.children[base] = n
+	dir.modTime = n.modTime
+	return n, nil
+}
+
+// add places n at name, creating missing parent directories, and leaves the
+// modification times of its parents alone. It bypasses permissions, to seed
+// the filesystem, and replaces the mode and modification time of a directory
+// added twice.
+func (m *MemFS) add(name string, n *memNode) error {
+	dir := m.root
+	elems := strings.Split(strings.TrimPrefix(memPath(name), "/"), "/")
+	for i, elem := range elems {
+		if elem == "" {
+			return nil
+		}
+		child, ok := dir.children[elem]
+		switch {
+		case i == len(elems)-1 && ok && child.isDir() && n.isDir():
+			child.mode, child.modTime = n.mode, n.modTime
+			return nil
+		case i == len(elems)-1 && ok:
+			return fs.ErrExist
+		case i == len(elems)-1:
+			dir.children[elem] = n
+			return nil
+		case !ok:
+			child = newMemDir(0o755, n.modTime)
+			dir.children[elem] = child
+		case !child.isDir():
+			return syscall.ENOTDIR
+		}
+		dir = child
+	}
+	return nil
+}
+
+// walk resolves the cleaned path pth to its node and returns it with its path
+// once symbolic links are resolved. A symbolic link that is the last element
+// of pth is itself returned unless follow is set. links counts the links
+// followed so far.
+func (m *MemFS) walk(pth string, follow bool, links *int) (*memNode, string, error) {
+	if pth == "/" {
+		return m.root, "/", nil
+	}
+	dir, dirPath, base, err := m.walkParent(pth, links)
+	if err != nil {
+		return nil, "", err
+	}
+	n, ok := dir.children[base]
+	if !ok {
+		return nil, "", fs.ErrNotExist
+	}
+	if !follow || !n.isSymlink() {
+		return n, path.Join(dirPath, base), nil
+	}
+
+	*links++
+	if *links > maxSymlinks {
+		return nil, "", syscall.ELOOP
+	}
+	target := n.target
+	if !path.IsAbs(target) {
+		target = path.Join(dirPath, target)
+	}
+	return m.walk(memPath(target), true, links)
+}
+
+// walkParent resolves the directory holding the last element of the cleaned
+// path pth, other than the root, and returns it with its resolved path and the
+// name of the element.
+func (m *MemFS) walkParent(pth string, links *int) (*memNode, string, string, error) {
+	dirName, base := path.Split(pth)
+	dir, dirPath, err := m.walk(path.Clean(dirName), true, links)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if !dir.isDir() {
+		return nil, "", "", syscall.ENOTDIR
+	}
+	return dir, dirPath, base, nil
+}
+
+// memPath cleans name into an absolute path of a MemFS.
+func memPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// defaultMemPerm returns the permissions of seeded entries that have none.
+func defaultMemPerm(dir bool) FileMode {
+	if dir {
+		return 0o755
+	}
+	return 0o644
+}
+
+func newMemDir(perm FileMode, modTime time.Time) *memNode {
+	return &memNode{
+		mode:     fs.ModeDir | perm,
+		modTime:  modTime,
+		children: make(map[string]*memNode),
+	}
+}
+
+func (n *memNode) isDir() bool {
+	return n.mode.IsDir()
+}
+
+func (n *memNode) isSymlink() bool {
+	return n.mode&fs.ModeSymlink != 0
+}
+
+// info returns a snapshot of the information about n, named name.
+func (n *memNode) info(name string) FileInfo {
+	size := int64(len(n.data))
+	if n.isSymlink() {
+		size = int64(len(n.target))
+	}
+	return &memInfo{
+		name:    name,
+		size:    size,
+		mode:    n.mode,
+		modTime: n.modTime,
+	}
+}
+
+// entries returns the entries of the directory n, sorted by name.
+func (n *memNode) entries() []DirEntry {
+	entries := make([]DirEntry, 0, len(n.children))
+	for _, name := range slices.Sorted(maps.Keys(n.children)) {
+		entries = append(entries, fs.FileInfoToDirEntry(n.children[name].info(name)))
+	}
+	return entries
+}
+
+var _ File = (*memFile)(nil)
+
+// memFile is a file or directory opened from a MemFS.
+type memFile struct {
+	fs     *MemFS
+	node   *memNode
+	name   string
+	flag   int
+	offset int64
+	closed bool
+	// dirOffset is the number of directory entries returned by ReadDir.
+	dirOffset int
+}
+
+func (f *memFile) readable() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != os.O_WRONLY
+}
+
+func (f *memFile) writable() bool {
+	return f.flag&(os.O_WRONLY|os.O_RDWR) != os.O_RDONLY
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes from the file at offset off.
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	n, err := f.readAt(p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *memFile) readAt(p []byte, off int64) (int, error) {
+	switch {
+	case f.closed:
+		return 0, fs.ErrClosed
+	case f.node.isDir():
+		return 0, syscall.EISDIR
+	case !f.readable():
+		return 0, fs.ErrPermission
+	case off >= int64(len(f.node.data)):
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	return copy(p, f.node.data[off:]), nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	switch {
+	case f.closed:
+		return 0, fs.ErrClosed
+	case !f.writable():
+		return 0, fs.ErrPermission
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	if end := f.offset + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.data = slices.Grow(f.node.data, int(end)-len(f.node.data))[:end]
+	}
+	n := copy(f.node.data[f.offset:], p)
+	f.offset += int64(n)
+	f.node.modTime = time.Now()
+	return n, nil
+}
+
+// Seek sets the offset of the next Read or Write, as io.Seeker describes.
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	case io.SeekStart:
+	default:
+		return 0, fs.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) Stat() (FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return nil, fs.ErrClosed
+	}
+	return f.node.info(f.name), nil
+}
+
+// ReadDir reads the entries of an open directory, as fs.ReadDirFile
+// describes.
+func (f *memFile) ReadDir(count int) ([]DirEntry, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	switch {
+	case f.closed:
+		return nil, fs.ErrClosed
+	case !f.node.isDir():
+		return nil, syscall.ENOTDIR
+	}
+	entries := f.node.entries()[min(f.dirOffset, len(f.node.children)):]
+	if count > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		entries = entries[:min(count, len(entries))]
+	}
+	f.dirOffset += len(entries)
+	return entries, nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+// memIOFS is the fs.FS view of a MemFS. Unlike MemFS, it takes only the paths
+// fs.ValidPath accepts and reports errors as *fs.PathError.
+type memIOFS struct {
+	m *MemFS
+}
+
+func (f memIOFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	file, err := f.m.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return file, nil
+}
+
+func (f memIOFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	b, err := f.m.ReadFile(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return b, nil
+}
+
+func (f memIOFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries, err := f.m.ReadDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+func (f memIOFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := f.m.Stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+func (f memIOFS) ReadLink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	target, err := f.m.Readlink(name)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return target, nil
+}
+
+func (f memIOFS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := f.m.Lstat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+var _ FileInfo = (*memInfo)(nil)
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    FileMode
+	modTime time.Time
+}
+
+func (fi *memInfo) Name() string {
+	return fi.name
+}
+
+func (fi *memInfo) Size() int64 {
+	return fi.size
+}
+
+func (fi *memInfo) Mode() FileMode {
+	return fi.mode
+}
+
+func (fi *memInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+func (fi *memInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (fi *memInfo) Sys() any {
+	return nil
+}
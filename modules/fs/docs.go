@@ -31,4 +31,5 @@ var docs = []object.FuncSpec{
 	{Name: "err_permission", Doc: "Error sentinel: permission denied", Returns: "error"},
 	{Name: "err_closed", Doc: "Error sentinel: the file is already closed", Returns: "error"},
 	{Name: "err_invalid", Doc: "Error sentinel: invalid argument", Returns: "error"},
+	{Name: "err_denied", Doc: "Error sentinel: the operation is denied by the runtime's policy", Returns: "error"},
 }
@@ -316,5 +316,6 @@ func Module() *object.Module {
 		"err_permission": object.NewError(fs.ErrPermission),
 		"err_closed":     object.NewError(fs.ErrClosed),
 		"err_invalid":    object.NewError(fs.ErrInvalid),
+		"err_denied":     object.NewError(ren.ErrDenied),
 	})
 }
@@ -11,8 +11,11 @@ import (
 	modfilepath "github.com/foohq/ren/modules/filepath"
 	modfs "github.com/foohq/ren/modules/fs"
 	modos "github.com/foohq/ren/modules/os"
+	modtesting "github.com/foohq/ren/modules/testing"
 )
 
+// modules is the registry. The "testing" module is not in it: only the tester
+// provides it, to the test scripts it runs (see tester.Run).
 var modules = map[string]*object.Module{
 	//"cli":      modcli.Module(),
 	"dll": moddll.Module(),
@@ -48,5 +51,6 @@ func Docs() []ModuleDocs {
 		{Name: "fs", Doc: modfs.ModuleDoc(), Funcs: modfs.Docs()},
 		{Name: "filepath", Doc: modfilepath.ModuleDoc(), Funcs: modfilepath.Docs()},
 		{Name: "dll", Doc: moddll.ModuleDoc(), Funcs: moddll.Docs()},
+		{Name: "testing", Doc: modtesting.ModuleDoc(), Funcs: modtesting.Docs()},
 	}
 }
@@ -20,8 +20,8 @@ var docs = []object.FuncSpec{
 	{Name: "getwd", Doc: "Return the script's current working directory", Returns: "string"},
 	{Name: "temp_dir", Doc: "Return the default directory for temporary files", Returns: "string"},
 	{Name: "getenv", Doc: "Return the value of an environment variable, or an empty string if unset", Args: []string{"key"}, Returns: "string"},
-	{Name: "setenv", Doc: "Set an environment variable", Args: []string{"key", "value"}, Returns: "nil"},
-	{Name: "unsetenv", Doc: "Remove an environment variable", Args: []string{"key"}, Returns: "nil"},
+	{Name: "setenv", Doc: "Set an environment variable in the script's environment", Args: []string{"key", "value"}, Returns: "nil"},
+	{Name: "unsetenv", Doc: "Remove an environment variable from the script's environment", Args: []string{"key"}, Returns: "nil"},
 	{Name: "environ", Doc: "Return the environment as a list of \"key=value\" strings", Returns: "list"},
 	{Name: "getpid", Doc: "Return the process ID of the caller", Returns: "int"},
 	{Name: "getuid", Doc: "Return the numeric user ID of the caller", Returns: "int"},
@@ -34,6 +34,8 @@ var docs = []object.FuncSpec{
 	{Name: "user_home_dir", Doc: "Return the current user's home directory", Returns: "string"},
 	{Name: "user_cache_dir", Doc: "Return the default root directory for user-specific cached data", Returns: "string"},
 	{Name: "user_config_dir", Doc: "Return the default root directory for user-specific configuration", Returns: "string"},
+	{Name: "err_denied", Doc: "Error sentinel: the operation is denied by the runtime's policy", Returns: "error"},
 	{Name: "stdin", Doc: "The standard input stream as a file object", Returns: "file"},
 	{Name: "stdout", Doc: "The standard output stream as a file object", Returns: "file"},
+	{Name: "stderr", Doc: "The standard error stream as a file object", Returns: "file"},
 }
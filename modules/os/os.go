@@ -40,7 +40,13 @@ func Exit(ctx context.Context, args ...object.Object) (object.Object, error) {
 	if !ok {
 		return nil, fmt.Errorf("os.exit: expected int, got %s", args[0].Type())
 	}
-	ren.GetOS(ctx).Exit(int(code.Value()))
+	o := ren.GetOS(ctx)
+	if checker, ok := o.(ren.ExitChecker); ok {
+		if err := checker.CheckExit(int(code.Value())); err != nil {
+			return nil, object.NewError(err)
+		}
+	}
+	o.Exit(int(code.Value()))
 	if code.Value() != 0 {
 		return nil, fmt.Errorf("os.exit: exited with code %d", code.Value())
 	}
@@ -300,6 +306,13 @@ func Stdin(ctx context.Context, name string) (object.Object, error) {
 	return objects.NewFile(ctx, f, "/dev/stdin"), nil
 }
 
+// Stderr resolves the "stderr" module attribute to a file object wrapping the
+// script's standard error.
+func Stderr(ctx context.Context, name string) (object.Object, error) {
+	f := ren.GetOS(ctx).Stderr()
+	return objects.NewFile(ctx, f, "/dev/stderr"), nil
+}
+
 // Stdout resolves the "stdout" module attribute to a file object wrapping the
 // script's standard output.
 func Stdout(ctx context.Context, name string) (object.Object, error) {
@@ -352,7 +365,9 @@ func Module() *object.Module {
 		"user_cache_dir":  object.NewBuiltin("user_cache_dir", UserCacheDir),
 		"user_config_dir": object.NewBuiltin("user_config_dir", UserConfigDir),
 		"user_home_dir":   object.NewBuiltin("user_home_dir", UserHomeDir),
+		"err_denied":      object.NewError(ren.ErrDenied),
 		"stdin":           object.NewDynamicAttr("stdin", Stdin),
 		"stdout":          object.NewDynamicAttr("stdout", Stdout),
+		"stderr":          object.NewDynamicAttr("stderr", Stderr),
 	})
 }
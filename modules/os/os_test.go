@@ -221,3 +221,15 @@ func TestStdout(t *testing.T) {
 	require.IsType(t, &objects.File{}, result)
 	require.Equal(t, stdoutPipe, result.(*objects.File).Value())
 }
+
+func TestStderr(t *testing.T) {
+	m := &testutils.MockOS{}
+	ctx := ren.WithOS(context.Background(), m)
+
+	stderrPipe := ren.NewPipe()
+	m.On("Stderr").Return(stderrPipe)
+	result, err := modos.Stderr(ctx, "stderr")
+	require.NoError(t, err)
+	require.IsType(t, &objects.File{}, result)
+	require.Equal(t, stderrPipe, result.(*objects.File).Value())
+}
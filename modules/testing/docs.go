@@ -0,0 +1,23 @@
+package testing
+
+import "github.com/deepnoodle-ai/risor/v2/pkg/object"
+
+// ModuleDoc returns the module-level documentation for "testing".
+func ModuleDoc() string {
+	return "Assertions and helpers for test functions run by `ren test`, which is the only command that provides the module. The functions fail when called outside a test."
+}
+
+// Docs returns documentation for every name exposed by the "testing" module.
+func Docs() []object.FuncSpec {
+	return docs
+}
+
+var docs = []object.FuncSpec{
+	{Name: "assert", Doc: "Fail and stop the test unless a condition is truthy", Args: []string{"cond", "msg?"}, Returns: "nil"},
+	{Name: "equal", Doc: "Fail and stop the test unless two values are equal", Args: []string{"got", "want", "msg?"}, Returns: "nil"},
+	{Name: "not_equal", Doc: "Fail and stop the test if two values are equal", Args: []string{"got", "other", "msg?"}, Returns: "nil"},
+	{Name: "fail", Doc: "Fail and stop the test", Args: []string{"msg?"}, Returns: "nil"},
+	{Name: "skip", Doc: "Skip the rest of the test", Args: []string{"msg?"}, Returns: "nil"},
+	{Name: "log", Doc: "Record a line in the test's output", Args: []string{"args..."}, Returns: "nil"},
+	{Name: "tmp_dir", Doc: "Create a temporary directory that is removed when the test ends", Returns: "string"},
+}
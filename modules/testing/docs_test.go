@@ -0,0 +1,25 @@
+package testing_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	modtesting "github.com/foohq/ren/modules/testing"
+)
+
+// TestDocsResolve guards that every name documented in docs.go is actually
+// registered by the module, so the documentation cannot reference functions
+// that do not exist.
+func TestDocsResolve(t *testing.T) {
+	m := modtesting.Module()
+	seen := make(map[string]bool)
+	for _, spec := range modtesting.Docs() {
+		require.NotEmpty(t, spec.Name)
+		require.Falsef(t, seen[spec.Name], "duplicate documentation for %q", spec.Name)
+		seen[spec.Name] = true
+
+		_, ok := m.GetAttr(spec.Name)
+		require.Truef(t, ok, "documented name %q is not registered by the module", spec.Name)
+	}
+}
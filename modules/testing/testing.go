@@ -0,0 +1,261 @@
+// Package testing implements the Ren "testing" module, the helpers that test
+// functions run by `ren test` use to make assertions, skip, log and create
+// temporary directories. The outcome of the running test is recorded in the T
+// stored on the context.
+package testing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+
+	"github.com/foohq/ren"
+)
+
+// errNoTest is returned by the helpers when they are called outside a test.
+var errNoTest = errors.New("not running a test")
+
+// T records the outcome of a single test. The test runner creates a T for
+// every test function and stores it on the context with WithT.
+type T struct {
+	mu       sync.Mutex
+	failed   bool
+	skipped  bool
+	messages []string
+	logs     []string
+	cleanups []func()
+}
+
+// NewT returns a T for a test that has not failed yet.
+func NewT() *T {
+	return &T{}
+}
+
+// Failed reports whether the test has failed.
+func (t *T) Failed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failed
+}
+
+// Skipped reports whether the test was skipped.
+func (t *T) Skipped() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.skipped
+}
+
+// Message returns the messages the test failed or was skipped with, one per
+// line.
+func (t *T) Message() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.Join(t.messages, "\n")
+}
+
+// Logs returns the lines the test logged with testing.log.
+func (t *T) Logs() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.logs)
+}
+
+// Cleanup runs the cleanup functions registered by the test, such as the
+// removal of its temporary directories, in the reverse order of registration.
+func (t *T) Cleanup() {
+	t.mu.Lock()
+	cleanups := t.cleanups
+	t.cleanups = nil
+	t.mu.Unlock()
+
+	for _, fn := range slices.Backward(cleanups) {
+		fn()
+	}
+}
+
+// fail marks the test failed and returns the error that stops it.
+func (t *T) fail(msg string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failed = true
+	t.messages = append(t.messages, msg)
+	return errors.New(msg)
+}
+
+// skip marks the test skipped and returns the error that stops it.
+func (t *T) skip(msg string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.skipped = true
+	t.messages = append(t.messages, msg)
+	return errors.New(msg)
+}
+
+type contextKey struct{}
+
+// WithT returns a copy of ctx carrying t, the test the helpers report to.
+func WithT(ctx context.Context, t *T) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// GetT returns the test stored on ctx by WithT, if any.
+func GetT(ctx context.Context) (*T, bool) {
+	t, ok := ctx.Value(contextKey{}).(*T)
+	return t, ok
+}
+
+// Equal fails and stops the test unless its first two arguments are equal.
+// Lists and maps are compared element by element. An optional third argument
+// is a message describing the assertion.
+func Equal(ctx context.Context, args ...object.Object) (object.Object, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, object.NewArgsRangeError("testing.equal", 2, 3, len(args))
+	}
+	t, ok := GetT(ctx)
+	if !ok {
+		return nil, fmt.Errorf("testing.equal: %w", errNoTest)
+	}
+	if !args[0].Equals(args[1]) {
+		return nil, t.fail(withMessage(fmt.Sprintf("got %s, want %s", args[0].Inspect(), args[1].Inspect()), args[2:]))
+	}
+	return object.Nil, nil
+}
+
+// NotEqual fails and stops the test if its first two arguments are equal. An
+// optional third argument is a message describing the assertion.
+func NotEqual(ctx context.Context, args ...object.Object) (object.Object, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, object.NewArgsRangeError("testing.not_equal", 2, 3, len(args))
+	}
+	t, ok := GetT(ctx)
+	if !ok {
+		return nil, fmt.Errorf("testing.not_equal: %w", errNoTest)
+	}
+	if args[0].Equals(args[1]) {
+		return nil, t.fail(withMessage(fmt.Sprintf("got %s, want a different value", args[0].Inspect()), args[2:]))
+	}
+	return object.Nil, nil
+}
+
+// Assert fails and stops the test unless its first argument is truthy. An
+// optional second argument is a message describing the assertion.
+func Assert(ctx context.Context, args ...object.Object) (object.Object, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, object.NewArgsRangeError("testing.assert", 1, 2, len(args))
+	}
+	t, ok := GetT(ctx)
+	if !ok {
+		return nil, fmt.Errorf("testing.assert: %w", errNoTest)
+	}
+	if !args[0].IsTruthy() {
+		return nil, t.fail(withMessage("assertion failed", args[1:]))
+	}
+	return object.Nil, nil
+}
+
+// Fail fails and stops the test. It takes an optional message.
+func Fail(ctx context.Context, args ...object.Object) (object.Object, error) {
+	if len(args) > 1 {
+		return nil, object.NewArgsRangeError("testing.fail", 0, 1, len(args))
+	}
+	t, ok := GetT(ctx)
+	if !ok {
+		return nil, fmt.Errorf("testing.fail: %w", errNoTest)
+	}
+	return nil, t.fail(message(args, "test failed"))
+}
+
+// Skip marks the test skipped and stops it. It takes an optional message.
+func Skip(ctx context.Context, args ...object.Object) (object.Object, error) {
+	if len(args) > 1 {
+		return nil, object.NewArgsRangeError("testing.skip", 0, 1, len(args))
+	}
+	t, ok := GetT(ctx)
+	if !ok {
+		return nil, fmt.Errorf("testing.skip: %w", errNoTest)
+	}
+	return nil, t.skip(message(args, "test skipped"))
+}
+
+// Log records its arguments, separated by spaces, in the output of the test.
+func Log(ctx context.Context, args ...object.Object) (object.Object, error) {
+	t, ok := GetT(ctx)
+	if !ok {
+		return nil, fmt.Errorf("testing.log: %w", errNoTest)
+	}
+	values := make([]string, len(args))
+	for i, arg := range args {
+		values[i] = toString(arg)
+	}
+	t.mu.Lock()
+	t.logs = append(t.logs, strings.Join(values, " "))
+	t.mu.Unlock()
+	return object.Nil, nil
+}
+
+// TmpDir creates a new temporary directory and returns its path. The
+// directory is removed when the test ends. It takes no arguments.
+func TmpDir(ctx context.Context, args ...object.Object) (object.Object, error) {
+	if len(args) != 0 {
+		return nil, object.NewArgsError("testing.tmp_dir", 0, len(args))
+	}
+	t, ok := GetT(ctx)
+	if !ok {
+		return nil, fmt.Errorf("testing.tmp_dir: %w", errNoTest)
+	}
+	o := ren.GetOS(ctx)
+	dir, err := o.MkdirTemp(o.TempDir(), "ren-test-*")
+	if err != nil {
+		return nil, object.NewError(err)
+	}
+	t.mu.Lock()
+	t.cleanups = append(t.cleanups, func() {
+		_ = o.RemoveAll(dir)
+	})
+	t.mu.Unlock()
+	return object.NewString(dir), nil
+}
+
+// withMessage prefixes msg with the optional message argument of an
+// assertion.
+func withMessage(msg string, args []object.Object) string {
+	if len(args) == 0 {
+		return msg
+	}
+	return toString(args[0]) + ": " + msg
+}
+
+// message returns the optional message argument, or def if it is missing.
+func message(args []object.Object, def string) string {
+	if len(args) == 0 {
+		return def
+	}
+	return toString(args[0])
+}
+
+// toString returns the value of a string object and the inspected form of
+// any other object.
+func toString(obj object.Object) string {
+	if s, ok := obj.(*object.String); ok {
+		return s.Value()
+	}
+	return obj.Inspect()
+}
+
+// Module returns the "testing" module with all of its functions registered.
+func Module() *object.Module {
+	return object.NewBuiltinsModule("testing", map[string]object.Object{
+		"assert":    object.NewBuiltin("assert", Assert),
+		"equal":     object.NewBuiltin("equal", Equal),
+		"fail":      object.NewBuiltin("fail", Fail),
+		"log":       object.NewBuiltin("log", Log),
+		"not_equal": object.NewBuiltin("not_equal", NotEqual),
+		"skip":      object.NewBuiltin("skip", Skip),
+		"tmp_dir":   object.NewBuiltin("tmp_dir", TmpDir),
+	})
+}
@@ -0,0 +1,77 @@
+package testing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+	"github.com/stretchr/testify/require"
+
+	"github.com/foohq/ren"
+	modtesting "github.com/foohq/ren/modules/testing"
+	"github.com/foohq/ren/testutils"
+)
+
+func TestEqual(t *testing.T) {
+	tt := modtesting.NewT()
+	ctx := modtesting.WithT(context.Background(), tt)
+
+	list := func(items ...int64) *object.List {
+		objs := make([]object.Object, len(items))
+		for i, item := range items {
+			objs[i] = object.NewInt(item)
+		}
+		return object.NewList(objs)
+	}
+
+	_, err := modtesting.Equal(ctx, list(1, 2), list(1, 2))
+	require.NoError(t, err)
+	require.False(t, tt.Failed())
+
+	_, err = modtesting.Equal(ctx, object.NewInt(1), object.NewInt(2), object.NewString("sum"))
+	require.Error(t, err)
+	require.True(t, tt.Failed())
+	require.Equal(t, "sum: got 1, want 2", tt.Message())
+}
+
+func TestSkip(t *testing.T) {
+	tt := modtesting.NewT()
+	ctx := modtesting.WithT(context.Background(), tt)
+
+	_, err := modtesting.Skip(ctx, object.NewString("not on this platform"))
+	require.Error(t, err)
+	require.True(t, tt.Skipped())
+	require.False(t, tt.Failed())
+	require.Equal(t, "not on this platform", tt.Message())
+}
+
+func TestLog(t *testing.T) {
+	tt := modtesting.NewT()
+	ctx := modtesting.WithT(context.Background(), tt)
+
+	_, err := modtesting.Log(ctx, object.NewString("n ="), object.NewInt(3))
+	require.NoError(t, err)
+	require.Equal(t, []string{"n = 3"}, tt.Logs())
+}
+
+func TestTmpDir(t *testing.T) {
+	m := &testutils.MockOS{}
+	m.On("TempDir").Return("/tmp")
+	m.On("MkdirTemp", "/tmp", "ren-test-*").Return("/tmp/ren-test-1", nil)
+	m.On("RemoveAll", "/tmp/ren-test-1").Return(nil)
+
+	tt := modtesting.NewT()
+	ctx := modtesting.WithT(ren.WithOS(context.Background(), m), tt)
+
+	result, err := modtesting.TmpDir(ctx)
+	require.NoError(t, err)
+	require.Equal(t, object.NewString("/tmp/ren-test-1"), result)
+
+	tt.Cleanup()
+	m.AssertCalled(t, "RemoveAll", "/tmp/ren-test-1")
+}
+
+func TestOutsideTest(t *testing.T) {
+	_, err := modtesting.Equal(context.Background(), object.NewInt(1), object.NewInt(1))
+	require.ErrorContains(t, err, "not running a test")
+}
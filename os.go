@@ -8,7 +8,10 @@ import (
 	"errors"
 	"os"
 	"os/user"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/foohq/urlpath"
 )
@@ -49,6 +52,7 @@ type OS interface {
 	UserHomeDir() (string, error)
 	Stdin() File
 	Stdout() File
+	Stderr() File
 	PathSeparator() rune
 	PathListSeparator() rune
 	CurrentUser() (User, error)
@@ -76,18 +80,33 @@ func isOS(ctx context.Context) bool {
 	return ok
 }
 
+// ExitChecker is implemented by an OS that may refuse a call to os.exit, as the
+// runtime's does under a Policy that does not grant Exit. The os module calls
+// CheckExit before Exit and reports its error to the script instead of
+// exiting.
+type ExitChecker interface {
+	CheckExit(code int) error
+}
+
 // ExitHandler is a function that handles os.exit calls.
 type ExitHandler func(int)
 
-var _ OS = (*osMiddleware)(nil)
+var (
+	_ OS          = (*osMiddleware)(nil)
+	_ ExitChecker = (*osMiddleware)(nil)
+)
 
 type osMiddleware struct {
 	wd          string
-	fs          fsMiddleware
+	fs          FS
 	stdin       File
 	stdout      File
+	stderr      File
 	args        []string
+	env         *environ
 	exitHandler ExitHandler
+	policy      *Policy
+	auditor     Auditor
 }
 
 func (o *osMiddleware) Mkdir(name string, perm os.FileMode) error {
@@ -206,11 +225,12 @@ func (o *osMiddleware) PathListSeparator() rune {
 	return urlpath.PathListSeparator
 }
 
-func (o *osMiddleware) Chdir(dir string) error {
+func (o *osMiddleware) Chdir(dir string) (err error) {
 	pth, err := urlpath.Abs(dir, o.wd)
 	if err != nil {
 		return err
 	}
+	defer o.audit("Chdir", auditURL(pth), time.Now(), &err)
 	info, err := o.fs.Stat(pth)
 	if err != nil {
 		return err
@@ -243,6 +263,10 @@ func (o *osMiddleware) Stdout() File {
 	return o.stdout
 }
 
+func (o *osMiddleware) Stderr() File {
+	return o.stderr
+}
+
 func (o *osMiddleware) Stdin() File {
 	return o.stdin
 }
@@ -252,31 +276,95 @@ func (o *osMiddleware) Args() []string {
 }
 
 func (o *osMiddleware) Environ() []string {
-	return os.Environ()
+	var result []string
+	for _, key := range o.env.keys() {
+		if !o.policy.allowEnv(key, false) {
+			continue
+		}
+		value, ok := o.env.lookup(key)
+		if !ok {
+			continue
+		}
+		result = append(result, key+"="+value)
+	}
+	return result
 }
 
 func (o *osMiddleware) Getenv(key string) string {
-	return os.Getenv(key)
+	value, _ := o.LookupEnv(key)
+	return value
 }
 
-func (o *osMiddleware) Setenv(key, value string) error {
-	return os.Setenv(key, value)
+func (o *osMiddleware) Setenv(key, value string) (err error) {
+	defer o.audit("Setenv", key, time.Now(), &err)
+	if !o.policy.allowEnv(key, true) {
+		return &PolicyError{Op: "setenv", Name: key}
+	}
+	if key == "" || strings.ContainsAny(key, "=\x00") || strings.ContainsRune(value, 0) {
+		return syscall.EINVAL
+	}
+	o.env.set(key, value)
+	return nil
 }
 
-func (o *osMiddleware) Unsetenv(key string) error {
-	return os.Unsetenv(key)
+func (o *osMiddleware) Unsetenv(key string) (err error) {
+	defer o.audit("Unsetenv", key, time.Now(), &err)
+	if !o.policy.allowEnv(key, true) {
+		return &PolicyError{Op: "unsetenv", Name: key}
+	}
+	o.env.unset(key)
+	return nil
 }
 
+// LookupEnv reports a variable the policy does not let the script read as
+// unset.
 func (o *osMiddleware) LookupEnv(key string) (string, bool) {
-	return os.LookupEnv(key)
+	if !o.policy.allowEnv(key, false) {
+		return "", false
+	}
+	return o.env.lookup(key)
+}
+
+// CheckExit refuses the exit if the policy does not grant it, and reports the
+// refusal to the auditor.
+func (o *osMiddleware) CheckExit(code int) error {
+	if o.policy.allowExit() {
+		return nil
+	}
+	var err error = &PolicyError{Op: "exit", Name: strconv.Itoa(code)}
+	o.audit("Exit", strconv.Itoa(code), time.Now(), &err)
+	return err
 }
 
+// Exit does nothing if the policy does not grant it. Otherwise it reports the
+// call to the auditor before the exit handler runs, since the handler may not
+// return.
 func (o *osMiddleware) Exit(code int) {
+	if o.CheckExit(code) != nil {
+		return
+	}
+	var err error
+	o.audit("Exit", strconv.Itoa(code), time.Now(), &err)
 	if o.exitHandler != nil {
 		o.exitHandler(code)
 	}
 }
 
+// audit reports the operation op on name, started at start, to the auditor if
+// there is one. err points to the result of the operation.
+func (o *osMiddleware) audit(op, name string, start time.Time, err *error) {
+	if o.auditor == nil {
+		return
+	}
+	o.auditor(AuditEvent{
+		Time:     start,
+		Op:       op,
+		Name:     name,
+		Duration: time.Since(start),
+		Err:      *err,
+	})
+}
+
 func (o *osMiddleware) Getpid() int {
 	return os.Getpid()
 }
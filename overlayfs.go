@@ -0,0 +1,853 @@
+package ren
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var (
+	_ FS     = (*OverlayFS)(nil)
+	_ linkFS = (*OverlayFS)(nil)
+)
+
+// OverlayFS stacks a writable upper filesystem on a lower one that it never
+// modifies, so that a script can run against a tree it must not change. Reads
+// are served by the upper layer if it has the file and fall through to the
+// lower layer otherwise. Writes go to the upper layer: a file or directory of
+// the lower layer is copied up, along with its parent directories, before it
+// is modified. Removing an entry of the lower layer records a whiteout, which
+// hides it from then on. Once the run is over, Changes lists what the script
+// changed, and Apply carries the changes over to another filesystem, e.g. the
+// lower layer itself after review.
+//
+// Paths are cleaned and rooted at "/" before being passed to either layer,
+// which are therefore typically a MemFS or confined with NewSubFS. It is safe
+// for concurrent use, provided that the layers are.
+type OverlayFS struct {
+	lower FS
+	upper FS
+
+	mu sync.Mutex
+	// whiteouts holds the paths of the lower layer that were removed.
+	whiteouts map[string]bool
+	// opaque holds the paths that were removed and created again where a
+	// directory is involved, whose entries in the lower layer are hidden.
+	opaque map[string]bool
+}
+
+// NewOverlayFS returns an OverlayFS that reads through to lower and writes to
+// upper, e.g. a MemFS.
+func NewOverlayFS(lower, upper FS) *OverlayFS {
+	return &OverlayFS{
+		lower:     lower,
+		upper:     upper,
+		whiteouts: make(map[string]bool),
+		opaque:    make(map[string]bool),
+	}
+}
+
+// ChangeKind is the kind of a change recorded by an OverlayFS.
+type ChangeKind int
+
+// Kinds of changes.
+const (
+	// ChangeAdd is a file, directory or symbolic link created in the upper
+	// layer that the lower layer does not have.
+	ChangeAdd ChangeKind = iota + 1
+	// ChangeModify is a file or symbolic link of the lower layer whose content,
+	// target or permissions differ in the upper layer.
+	ChangeModify
+	// ChangeDelete is an entry of the lower layer that was removed, with
+	// everything below it.
+	ChangeDelete
+)
+
+// String returns the name of the kind of change.
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdd:
+		return "add"
+	case ChangeModify:
+		return "modify"
+	case ChangeDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("ChangeKind(%d)", int(k))
+	}
+}
+
+// Change is a difference between the merged view of an OverlayFS and its
+// lower layer.
+type Change struct {
+	// Path is the path of the changed entry, e.g. "/etc/app.conf".
+	Path string
+	Kind ChangeKind
+}
+
+// String returns the change in the form "kind path".
+func (c Change) String() string {
+	return c.Kind.String() + " " + c.Path
+}
+
+func (o *OverlayFS) Mkdir(name string, perm FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.mkdir(overlayPath(name), perm)
+}
+
+func (o *OverlayFS) MkdirAll(path string, perm FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.mkdirAll(overlayPath(path), perm)
+}
+
+// MkdirTemp creates a directory with a unique name in dir, following the rules
+// of os.MkdirTemp. An empty dir stands for "/tmp", which is created if needed.
+func (o *OverlayFS) MkdirTemp(dir, pattern string) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if dir == "" {
+		dir = "/tmp"
+		err := o.mkdirAll(dir, 0o777)
+		if err != nil {
+			return "", err
+		}
+	}
+	return mkdirTemp(overlayPath(dir), pattern, func(name string) error {
+		return o.mkdir(name, 0o700)
+	})
+}
+
+func (o *OverlayFS) OpenFile(name string, flag int, perm FileMode) (File, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pth := overlayPath(name)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		layer, err := o.layer(pth)
+		if err != nil {
+			return nil, err
+		}
+		return layer.OpenFile(pth, flag, perm)
+	}
+
+	if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		if _, _, err := o.lstat(pth); err == nil {
+			return nil, fs.ErrExist
+		}
+	}
+	// A file is written through a symbolic link, which stays as it is.
+	pth, err := o.resolve(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := o.stat(pth)
+	switch {
+	case err == nil:
+		if info.IsDir() {
+			return nil, syscall.EISDIR
+		}
+		if _, err := o.upper.Stat(pth); err == nil {
+			break
+		}
+		if flag&os.O_TRUNC != 0 {
+			err = o.copyUpParents(pth)
+			flag |= os.O_CREATE
+			perm = info.Mode().Perm()
+		} else {
+			err = o.copyUp(pth)
+		}
+		if err != nil {
+			return nil, err
+		}
+	case errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE != 0:
+		err = o.checkParent(pth)
+		if err == nil {
+			err = o.copyUpParents(pth)
+		}
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	file, err := o.upper.OpenFile(pth, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	o.created(pth, false)
+	return file, nil
+}
+
+func (o *OverlayFS) ReadFile(name string) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pth := overlayPath(name)
+	layer, err := o.layer(pth)
+	if err != nil {
+		return nil, err
+	}
+	return layer.ReadFile(pth)
+}
+
+func (o *OverlayFS) Remove(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pth := overlayPath(name)
+	if pth == "/" {
+		return fs.ErrPermission
+	}
+	info, inUpper, err := o.lstat(pth)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		entries, err := o.readDir(pth)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return syscall.ENOTEMPTY
+		}
+	}
+	if inUpper {
+		err = o.upper.Remove(pth)
+		if err != nil {
+			return err
+		}
+	}
+	o.whiteout(pth)
+	return nil
+}
+
+func (o *OverlayFS) RemoveAll(path string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.removeAll(overlayPath(path))
+}
+
+// Rename moves oldPath to newPath, following the rules of os.Rename. An entry
+// of the lower layer is copied up to newPath, a directory with everything in
+// it, and whited out at oldPath.
+func (o *OverlayFS) Rename(oldPath, newPath string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	oldPth, newPth := overlayPath(oldPath), overlayPath(newPath)
+	if oldPth == "/" || newPth == "/" {
+		return fs.ErrPermission
+	}
+	info, inUpper, err := o.lstat(oldPth)
+	if err != nil {
+		return err
+	}
+	if oldPth == newPth {
+		return nil
+	}
+	if info.IsDir() && strings.HasPrefix(newPth, oldPth+"/") {
+		return syscall.EINVAL
+	}
+	err = o.checkParent(newPth)
+	if err != nil {
+		return err
+	}
+	if existing, _, err := o.lstat(newPth); err == nil {
+		switch {
+		case existing.IsDir() && !info.IsDir():
+			return syscall.EISDIR
+		case !existing.IsDir() && info.IsDir():
+			return syscall.ENOTDIR
+		case existing.IsDir():
+			entries, err := o.readDir(newPth)
+			if err != nil {
+				return err
+			}
+			if len(entries) > 0 {
+				return syscall.ENOTEMPTY
+			}
+		}
+		err = o.removeAll(newPth)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = o.copyUpParents(newPth)
+	if err != nil {
+		return err
+	}
+	if inUpper && !o.lowerHas(oldPth) {
+		err = o.upper.Rename(oldPth, newPth)
+	} else {
+		err = o.copyTree(oldPth, newPth)
+	}
+	if err != nil {
+		return err
+	}
+	o.created(newPth, info.IsDir())
+	return o.removeAll(oldPth)
+}
+
+// Stat returns information about the file name, following symbolic links
+// within the layer that has it.
+func (o *OverlayFS) Stat(name string) (FileInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.stat(overlayPath(name))
+}
+
+func (o *OverlayFS) Symlink(oldName, newName string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pth := overlayPath(newName)
+	if _, _, err := o.lstat(pth); err == nil {
+		return fs.ErrExist
+	}
+	err := o.checkParent(pth)
+	if err == nil {
+		err = o.copyUpParents(pth)
+	}
+	if err == nil {
+		err = o.upper.Symlink(oldName, pth)
+	}
+	if err != nil {
+		return err
+	}
+	o.created(pth, false)
+	return nil
+}
+
+func (o *OverlayFS) WriteFile(name string, data []byte, perm FileMode) error {
+	f, err := o.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	return err
+}
+
+// ReadDir returns the entries of the directory name in both layers, sorted by
+// name. The entries of the upper layer take precedence.
+func (o *OverlayFS) ReadDir(name string) ([]DirEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pth := overlayPath(name)
+	info, err := o.stat(pth)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+	return o.readDir(pth)
+}
+
+func (o *OverlayFS) Lstat(name string) (FileInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	info, _, err := o.lstat(overlayPath(name))
+	return info, err
+}
+
+func (o *OverlayFS) Readlink(name string) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.readlink(overlayPath(name))
+}
+
+// Changes returns the changes made through the overlay, sorted by path, with
+// a deletion before an addition of the same path. A directory removed and
+// created again, or replaced by a file or the other way around, is reported as
+// deleted and added. Files that were
+// opened for writing but left as they were in the lower layer are not
+// reported.
+func (o *OverlayFS) Changes() ([]Change, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var changes []Change
+	for pth := range o.whiteouts {
+		changes = append(changes, Change{Path: pth, Kind: ChangeDelete})
+	}
+	for pth := range o.opaque {
+		changes = append(changes, Change{Path: pth, Kind: ChangeDelete})
+	}
+	err := o.diff("/", &changes)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(changes, func(a, b Change) int {
+		if c := strings.Compare(a.Path, b.Path); c != 0 {
+			return c
+		}
+		return int(b.Kind) - int(a.Kind)
+	})
+	return changes, nil
+}
+
+// Apply carries the changes made through the overlay over to dst, in the
+// order Changes returns them: deleted entries are removed from dst and added
+// or modified ones are written to it from the upper layer. The overlay itself
+// is left as it is.
+func (o *OverlayFS) Apply(dst FS) error {
+	changes, err := o.Changes()
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, change := range changes {
+		err := o.apply(dst, change)
+		if err != nil {
+			return fmt.Errorf("%s: %w", change, err)
+		}
+	}
+	return nil
+}
+
+func (o *OverlayFS) apply(dst FS, change Change) error {
+	pth := change.Path
+	if change.Kind == ChangeDelete {
+		return dst.RemoveAll(pth)
+	}
+
+	info, err := lstatFS(o.upper, pth)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.IsDir():
+		err := dst.Mkdir(pth, info.Mode().Perm())
+		if errors.Is(err, fs.ErrExist) {
+			return nil
+		}
+		return err
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := o.upper.(linkFS).Readlink(pth)
+		if err != nil {
+			return err
+		}
+		err = dst.Remove(pth)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return dst.Symlink(target, pth)
+	}
+	b, err := o.upper.ReadFile(pth)
+	if err != nil {
+		return err
+	}
+	return dst.WriteFile(pth, b, info.Mode().Perm())
+}
+
+// diff appends to changes the entries of the upper layer below the directory
+// dir that differ from the lower layer.
+func (o *OverlayFS) diff(dir string, changes *[]Change) error {
+	entries, err := o.upper.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		pth := path.Join(dir, entry.Name())
+		info, err := lstatFS(o.upper, pth)
+		if err != nil {
+			return err
+		}
+
+		var lowerInfo FileInfo
+		if o.inLower(pth) && !o.opaque[pth] {
+			lowerInfo, _ = lstatFS(o.lower, pth)
+		}
+		switch {
+		case lowerInfo == nil:
+			*changes = append(*changes, Change{Path: pth, Kind: ChangeAdd})
+		case info.IsDir() && lowerInfo.IsDir():
+		default:
+			same, err := o.same(pth, info, lowerInfo)
+			if err != nil {
+				return err
+			}
+			if !same {
+				*changes = append(*changes, Change{Path: pth, Kind: ChangeModify})
+			}
+		}
+		if info.IsDir() {
+			err := o.diff(pth, changes)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// same reports whether the entry pth of the upper layer, described by info, is
+// the same as that of the lower layer, described by lowerInfo.
+func (o *OverlayFS) same(pth string, info, lowerInfo FileInfo) (bool, error) {
+	if info.Mode() != lowerInfo.Mode() {
+		return false, nil
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := o.upper.(linkFS).Readlink(pth)
+		if err != nil {
+			return false, err
+		}
+		lowerTarget, err := o.lower.(linkFS).Readlink(pth)
+		return target == lowerTarget, err
+	}
+	if info.Size() != lowerInfo.Size() {
+		return false, nil
+	}
+	b, err := o.upper.ReadFile(pth)
+	if err != nil {
+		return false, err
+	}
+	lowerB, err := o.lower.ReadFile(pth)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(b, lowerB), nil
+}
+
+func (o *OverlayFS) mkdir(pth string, perm FileMode) error {
+	if _, _, err := o.lstat(pth); err == nil {
+		return fs.ErrExist
+	}
+	err := o.checkParent(pth)
+	if err == nil {
+		err = o.copyUpParents(pth)
+	}
+	if err == nil {
+		err = o.upper.Mkdir(pth, perm)
+	}
+	if err != nil {
+		return err
+	}
+	o.created(pth, true)
+	return nil
+}
+
+func (o *OverlayFS) mkdirAll(pth string, perm FileMode) error {
+	info, err := o.stat(pth)
+	if err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return syscall.ENOTDIR
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	err = o.mkdirAll(path.Dir(pth), perm)
+	if err != nil {
+		return err
+	}
+	return o.mkdir(pth, perm)
+}
+
+func (o *OverlayFS) removeAll(pth string) error {
+	if pth == "/" {
+		return fs.ErrPermission
+	}
+	_, inUpper, err := o.lstat(pth)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if inUpper {
+		err = o.upper.RemoveAll(pth)
+		if err != nil {
+			return err
+		}
+	}
+	o.whiteout(pth)
+	return nil
+}
+
+// whiteout hides the entry pth of the lower layer, if there is one, once it
+// has been removed from the upper layer, and forgets the changes recorded
+// below it.
+func (o *OverlayFS) whiteout(pth string) {
+	inLower := o.lowerHas(pth)
+	for _, recorded := range []map[string]bool{o.whiteouts, o.opaque} {
+		maps.DeleteFunc(recorded, func(p string, _ bool) bool {
+			return p == pth || strings.HasPrefix(p, pth+"/")
+		})
+	}
+	if inLower {
+		o.whiteouts[pth] = true
+	}
+}
+
+// created records that pth was created in the upper layer: a whiteout of pth
+// is lifted and, if either the new or the removed entry is a directory, pth is
+// marked as replaced so that the entries of the lower directory stay hidden.
+func (o *OverlayFS) created(pth string, dir bool) {
+	if !o.whiteouts[pth] {
+		return
+	}
+	delete(o.whiteouts, pth)
+	if info, err := lstatFS(o.lower, pth); dir || err == nil && info.IsDir() {
+		o.opaque[pth] = true
+	}
+}
+
+// inLower reports whether the lower layer may be consulted for pth, i.e. that
+// neither pth nor any of its parents was removed, and that none of its
+// parents was replaced.
+func (o *OverlayFS) inLower(pth string) bool {
+	for p := pth; ; p = path.Dir(p) {
+		if o.whiteouts[p] || p != pth && o.opaque[p] {
+			return false
+		}
+		if p == "/" {
+			return true
+		}
+	}
+}
+
+// resolve follows the symbolic link pth, and the links it leads to, in the
+// merged view, and returns the path of the entry they lead to, which may not
+// exist. A path that is not a link is returned as it is.
+func (o *OverlayFS) resolve(pth string) (string, error) {
+	for range maxSymlinks {
+		info, _, err := o.lstat(pth)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			return pth, nil
+		}
+		target, err := o.readlink(pth)
+		if err != nil {
+			return "", err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(pth), target)
+		}
+		pth = path.Clean(target)
+	}
+	return "", syscall.ELOOP
+}
+
+// lowerHas reports whether the lower layer has an entry pth that is not hidden
+// by a removed or replaced parent.
+func (o *OverlayFS) lowerHas(pth string) bool {
+	if !o.inLower(pth) {
+		return false
+	}
+	_, err := lstatFS(o.lower, pth)
+	return err == nil
+}
+
+// layer returns the layer that holds pth.
+func (o *OverlayFS) layer(pth string) (FS, error) {
+	_, err := o.upper.Stat(pth)
+	if err == nil {
+		return o.upper, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) || !o.inLower(pth) {
+		return nil, fs.ErrNotExist
+	}
+	return o.lower, nil
+}
+
+func (o *OverlayFS) stat(pth string) (FileInfo, error) {
+	info, err := o.upper.Stat(pth)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return info, err
+	}
+	if !o.inLower(pth) {
+		return nil, fs.ErrNotExist
+	}
+	return o.lower.Stat(pth)
+}
+
+// lstat returns information about pth like stat, without following a link
+// that is its last element, and whether it is in the upper layer.
+func (o *OverlayFS) lstat(pth string) (FileInfo, bool, error) {
+	info, err := lstatFS(o.upper, pth)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return info, err == nil, err
+	}
+	if !o.inLower(pth) {
+		return nil, false, fs.ErrNotExist
+	}
+	info, err = lstatFS(o.lower, pth)
+	return info, false, err
+}
+
+func (o *OverlayFS) readlink(pth string) (string, error) {
+	_, inUpper, err := o.lstat(pth)
+	if err != nil {
+		return "", err
+	}
+	layer := o.lower
+	if inUpper {
+		layer = o.upper
+	}
+	links, ok := layer.(linkFS)
+	if !ok {
+		return "", fs.ErrInvalid
+	}
+	return links.Readlink(pth)
+}
+
+// readDir returns the merged entries of the directory pth.
+func (o *OverlayFS) readDir(pth string) ([]DirEntry, error) {
+	entries := make(map[string]DirEntry)
+	if o.inLower(pth) && !o.opaque[pth] {
+		lowerEntries, err := o.lower.ReadDir(pth)
+		if err == nil {
+			for _, entry := range lowerEntries {
+				if !o.whiteouts[path.Join(pth, entry.Name())] {
+					entries[entry.Name()] = entry
+				}
+			}
+		}
+	}
+	upperEntries, err := o.upper.ReadDir(pth)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range upperEntries {
+		entries[entry.Name()] = entry
+	}
+
+	result := make([]DirEntry, 0, len(entries))
+	for _, name := range slices.Sorted(maps.Keys(entries)) {
+		result = append(result, entries[name])
+	}
+	return result, nil
+}
+
+// checkParent verifies that the parent of pth is a directory.
+func (o *OverlayFS) checkParent(pth string) error {
+	info, err := o.stat(path.Dir(pth))
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return syscall.ENOTDIR
+	}
+	return nil
+}
+
+// copyUpParents creates in the upper layer the parent directories of pth that
+// only the lower layer has, with their permissions.
+func (o *OverlayFS) copyUpParents(pth string) error {
+	dir := path.Dir(pth)
+	if dir == "/" {
+		return nil
+	}
+	if info, err := o.upper.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return syscall.ENOTDIR
+		}
+		return nil
+	}
+	err := o.copyUpParents(dir)
+	if err != nil {
+		return err
+	}
+	info, err := o.stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return syscall.ENOTDIR
+	}
+	return o.upper.Mkdir(dir, info.Mode().Perm())
+}
+
+// copyUp copies the entry pth of the lower layer, but not what a directory
+// contains, to the upper layer.
+func (o *OverlayFS) copyUp(pth string) error {
+	if _, err := lstatFS(o.upper, pth); err == nil {
+		return nil
+	}
+	err := o.copyUpParents(pth)
+	if err != nil {
+		return err
+	}
+	return o.copyEntry(pth, pth)
+}
+
+// copyTree copies the entry src of the merged view, with everything in it, to
+// dst in the upper layer.
+func (o *OverlayFS) copyTree(src, dst string) error {
+	err := o.copyEntry(src, dst)
+	if err != nil {
+		return err
+	}
+	info, _, err := o.lstat(src)
+	if err != nil || !info.IsDir() {
+		return err
+	}
+	entries, err := o.readDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err := o.copyTree(path.Join(src, entry.Name()), path.Join(dst, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyEntry copies the file, symbolic link or directory src of the merged view
+// to dst in the upper layer, without the content of a directory.
+func (o *OverlayFS) copyEntry(src, dst string) error {
+	info, _, err := o.lstat(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.IsDir():
+		return o.upper.Mkdir(dst, info.Mode().Perm())
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := o.readlink(src)
+		if err != nil {
+			return err
+		}
+		return o.upper.Symlink(target, dst)
+	}
+	layer, err := o.layer(src)
+	if err != nil {
+		return err
+	}
+	b, err := layer.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return o.upper.WriteFile(dst, b, info.Mode().Perm())
+}
+
+// overlayPath cleans name into an absolute path of an OverlayFS.
+func overlayPath(name string) string {
+	return path.Clean("/" + name)
+}
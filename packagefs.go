@@ -0,0 +1,203 @@
+package ren
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/deepnoodle-ai/risor/v2/pkg/bytecode"
+
+	"github.com/foohq/ren/packager"
+)
+
+var _ FS = (*packageFS)(nil)
+
+// packageFS is a read-only filesystem backed by the package archive. It is
+// registered under the package scheme so that scripts can read the data files
+// bundled with them, e.g. fs.read_file("package://templates/a.txt"). Every
+// operation that would modify the package fails with fs.ErrPermission.
+//
+// Compiled modules are hidden unless showModules is set, so that a package
+// exposes only the files its author shipped as data. The files that describe
+// the package, such as its manifest and signature, and the vendored
+// dependencies, which scripts import with the pkg:// scheme, are always
+// hidden. The entries of an encrypted package are decrypted as they are read.
+type packageFS struct {
+	pkg         fs.FS
+	modules     map[string]*bytecode.Code
+	showModules bool
+	// vendored lists the directories of the vendored dependencies.
+	vendored []string
+}
+
+func newPackageFS(prog *Program, showModules bool) *packageFS {
+	var vendored []string
+	if prog.lock != nil {
+		for name, dep := range prog.lock.Dependencies {
+			if dep.Vendored {
+				vendored = append(vendored, path.Join(packager.DependencyDir, name))
+			}
+		}
+	}
+	return &packageFS{
+		pkg:         prog.files,
+		modules:     prog.modules,
+		showModules: showModules,
+		vendored:    vendored,
+	}
+}
+
+func (f *packageFS) Mkdir(name string, perm FileMode) error {
+	return fs.ErrPermission
+}
+
+func (f *packageFS) MkdirAll(path string, perm FileMode) error {
+	return fs.ErrPermission
+}
+
+func (f *packageFS) MkdirTemp(dir, pattern string) (string, error) {
+	return "", fs.ErrPermission
+}
+
+func (f *packageFS) OpenFile(name string, flag int, perm FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC|os.O_EXCL) != 0 {
+		return nil, fs.ErrPermission
+	}
+	pth, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := f.pkg.Open(pth)
+	if err != nil {
+		return nil, unwrapPathError(err)
+	}
+	return &packageFile{File: file}, nil
+}
+
+func (f *packageFS) ReadFile(name string) ([]byte, error) {
+	pth, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := fs.ReadFile(f.pkg, pth)
+	if err != nil {
+		return nil, unwrapPathError(err)
+	}
+	return b, nil
+}
+
+func (f *packageFS) Remove(name string) error {
+	return fs.ErrPermission
+}
+
+func (f *packageFS) RemoveAll(path string) error {
+	return fs.ErrPermission
+}
+
+func (f *packageFS) Rename(oldPath, newPath string) error {
+	return fs.ErrPermission
+}
+
+func (f *packageFS) Stat(name string) (FileInfo, error) {
+	pth, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(f.pkg, pth)
+	if err != nil {
+		return nil, unwrapPathError(err)
+	}
+	return info, nil
+}
+
+func (f *packageFS) Symlink(oldName, newName string) error {
+	return fs.ErrPermission
+}
+
+func (f *packageFS) WriteFile(name string, data []byte, perm FileMode) error {
+	return fs.ErrPermission
+}
+
+func (f *packageFS) ReadDir(name string) ([]DirEntry, error) {
+	pth, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	results, err := fs.ReadDir(f.pkg, pth)
+	if err != nil {
+		return nil, unwrapPathError(err)
+	}
+
+	entries := make([]DirEntry, 0, len(results))
+	for _, entry := range results {
+		if f.isHidden(path.Join(pth, entry.Name())) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// lookup converts name into a path within the package archive and reports
+// fs.ErrNotExist for the entries that are hidden.
+func (f *packageFS) lookup(name string) (string, error) {
+	pth := packageFSPath(name)
+	if f.isHidden(pth) {
+		return "", fs.ErrNotExist
+	}
+	return pth, nil
+}
+
+// isHidden reports whether the package path pth must not be visible through
+// the filesystem: a file describing the package, a vendored dependency or,
+// unless showModules is set, a compiled module.
+func (f *packageFS) isHidden(pth string) bool {
+	if packager.IsMetadataFile(pth) {
+		return true
+	}
+	for _, dir := range f.vendored {
+		if pth == dir || strings.HasPrefix(pth, dir+"/") {
+			return true
+		}
+	}
+	if f.showModules {
+		return false
+	}
+	_, ok := f.modules[pth]
+	return ok
+}
+
+// packageFSPath converts a filesystem path into a path within the package
+// archive. Package paths are rooted at the package root, so leading slashes and
+// any attempt to climb above the root are dropped.
+func packageFSPath(name string) string {
+	pth := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if pth == "" {
+		return "."
+	}
+	return pth
+}
+
+// unwrapPathError strips the *fs.PathError added by the archive, leaving the
+// caller to report the path in its own terms, as localFS does.
+func unwrapPathError(err error) error {
+	if pathErr, ok := errors.AsType[*fs.PathError](err); ok {
+		return pathErr.Err
+	}
+	return err
+}
+
+var _ File = (*packageFile)(nil)
+
+// packageFile is a file opened from the package archive. Writes always fail
+// with fs.ErrPermission.
+type packageFile struct {
+	fs.File
+}
+
+func (f *packageFile) Write(p []byte) (int, error) {
+	return 0, fs.ErrPermission
+}
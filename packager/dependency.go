@@ -0,0 +1,365 @@
+package packager
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// LockFile is the path of the lockfile inside a package. It records the
+// dependencies the package was built with; a source directory may contain one
+// too, to pin the vendored dependencies (see Lock).
+const LockFile = "ren.lock"
+
+// DependencyDir is the directory of a package holding its vendored
+// dependencies, each under a directory of its name.
+const DependencyDir = "pkg"
+
+// dependencyScheme is the import scheme of modules of a dependency, e.g.
+// import("pkg://logging/json").
+const dependencyScheme = "pkg"
+
+// ErrInvalidDependency is returned when a dependency cannot be vendored into a
+// package: it is not a valid package, is encrypted, does not satisfy its
+// version constraint or does not match the lockfile.
+var ErrInvalidDependency = errors.New("invalid dependency")
+
+// Dependency declares a package whose modules the package imports with the
+// pkg:// scheme. In ren.toml dependencies are listed by name:
+//
+//	[dependencies]
+//	logging = { version = "^1.2.0", path = "deps/logging.zip" }
+//	metrics = { version = ">=0.3.0, <0.5.0" }
+//
+// A dependency with a path is vendored: Build copies its modules into the
+// package, under DependencyDir. Any other must be provided to the runtime with
+// ren.WithPackageDependency.
+type Dependency struct {
+	// Version is the constraint the version of the dependency must satisfy,
+	// e.g. "^1.2.0" (see SatisfiesConstraint). An empty constraint accepts
+	// any version.
+	Version string `json:"version,omitempty" toml:"version"`
+	// Path is the slash-separated path, within the source directory, of the
+	// dependency's package file.
+	Path string `json:"path,omitempty" toml:"path"`
+}
+
+// Lock records the dependencies of a package. Build writes it to the package
+// as LockFile, and the runtime checks the dependencies it is given against it.
+// If the source directory holds a LockFile, the digests it records pin the
+// vendored dependencies: Build fails with ErrInvalidDependency if a package
+// file has changed.
+type Lock struct {
+	Dependencies map[string]LockedDependency `json:"dependencies"`
+}
+
+// LockedDependency is the locked state of a dependency.
+type LockedDependency struct {
+	// Constraint is the version constraint of the dependency.
+	Constraint string `json:"constraint,omitempty"`
+	// Vendored is true if the dependency is included in the package.
+	Vendored bool `json:"vendored,omitempty"`
+	// Version is the version of the vendored package.
+	Version string `json:"version,omitempty"`
+	// Digest is the SHA-256 hash of the vendored package file, in the form
+	// "sha256:<hex>".
+	Digest string `json:"digest,omitempty"`
+}
+
+// ReadLock reads the LockFile of a package or source tree, or returns nil if
+// it has none.
+func ReadLock(fsys fs.FS) (*Lock, error) {
+	b, err := fs.ReadFile(fsys, LockFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lock Lock
+	err = json.Unmarshal(b, &lock)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", LockFile, err)
+	}
+	return &lock, nil
+}
+
+// writeLock writes lock to pw as LockFile.
+func writeLock(pw *packageWriter, lock *Lock) error {
+	b, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	h := &zip.FileHeader{
+		Name:   LockFile,
+		Method: zip.Deflate,
+	}
+	h.SetMode(0644)
+	return pw.create(h, append(b, '\n'))
+}
+
+// writeDependencies vendors the dependencies of the manifest that have a path
+// into pw and returns the lock of all of them. The modules and data files of
+// each vendored dependency are recorded in imports. pinned is the lockfile of
+// the source tree, or nil.
+func writeDependencies(pw *packageWriter, fsys fs.FS, manifest *Manifest, pinned *Lock, imports *importGraph) (*Lock, error) {
+	lock := &Lock{Dependencies: make(map[string]LockedDependency, len(manifest.Dependencies))}
+	for name, dep := range manifest.Dependencies {
+		locked := LockedDependency{Constraint: dep.Version}
+		if dep.Path == "" {
+			imports.addDependency(name, nil)
+			lock.Dependencies[name] = locked
+			continue
+		}
+
+		b, err := fs.ReadFile(fsys, dep.Path)
+		if err != nil {
+			return nil, fmt.Errorf("dependency %q: %w", name, err)
+		}
+		sum := sha256.Sum256(b)
+		locked.Vendored = true
+		locked.Digest = "sha256:" + hex.EncodeToString(sum[:])
+		if pinned != nil {
+			if p, ok := pinned.Dependencies[name]; ok && p.Digest != "" && p.Digest != locked.Digest {
+				return nil, fmt.Errorf("%w: %q: %s does not match the digest in %s", ErrInvalidDependency, name, dep.Path, LockFile)
+			}
+		}
+
+		locked.Version, err = vendorDependency(pw, name, dep, b, imports)
+		if err != nil {
+			return nil, err
+		}
+		lock.Dependencies[name] = locked
+	}
+	return lock, nil
+}
+
+// vendorDependency copies the package file b of the dependency name into pw,
+// under DependencyDir, and returns its version. Its entrypoint is left out:
+// only its modules and data files can be used.
+func vendorDependency(pw *packageWriter, name string, dep Dependency, b []byte, imports *importGraph) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return "", fmt.Errorf("%w: %q: %w", ErrInvalidDependency, name, err)
+	}
+	enc, err := readEncryption(zr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q: %w", ErrInvalidDependency, name, err)
+	}
+	if enc != nil {
+		return "", fmt.Errorf("%w: %q: cannot vendor an encrypted package", ErrInvalidDependency, name)
+	}
+	_, err = Signer(zr)
+	if err != nil && !errors.Is(err, ErrUnsigned) {
+		return "", fmt.Errorf("%w: %q: %w", ErrInvalidDependency, name, err)
+	}
+
+	var version string
+	mb, err := fs.ReadFile(zr, ManifestFile)
+	if err == nil {
+		m, err := ParseManifest(mb)
+		if err != nil {
+			return "", fmt.Errorf("%w: %q: %w", ErrInvalidDependency, name, err)
+		}
+		version = m.Version
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%w: %q: %w", ErrInvalidDependency, name, err)
+	}
+	ok, err := SatisfiesConstraint(version, dep.Version)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%w: %q: version %q does not satisfy %q", ErrInvalidDependency, name, version, dep.Version)
+	}
+
+	var files []string
+	for _, f := range zr.File {
+		switch {
+		case strings.HasSuffix(f.Name, "/"):
+			continue
+		case f.Name == entrypointModule || f.Name == SignatureFile || f.Name == EncryptionFile:
+			continue
+		}
+		files = append(files, f.Name)
+
+		content, err := readZipFile(f)
+		if err == nil && f.Name == IndexFile {
+			content, err = vendoredIndex(content)
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %q: %w", ErrInvalidDependency, name, err)
+		}
+		dst := path.Join(DependencyDir, name, f.Name)
+		err = pw.addDir(path.Dir(dst))
+		if err != nil {
+			return "", err
+		}
+		h := &zip.FileHeader{
+			Name:     dst,
+			Method:   zip.Deflate,
+			Modified: f.Modified,
+		}
+		h.SetMode(f.Mode())
+		err = pw.create(h, content)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	imports.addDependency(name, files)
+	return version, nil
+}
+
+// validDependencyName reports whether name can name a dependency: it must be a
+// single path element.
+func validDependencyName(name string) bool {
+	return fs.ValidPath(name) && name != "." && !strings.Contains(name, "/")
+}
+
+// normalizeDependencies trims and validates the dependencies of the manifest.
+func (m *Manifest) normalizeDependencies() error {
+	for name, dep := range m.Dependencies {
+		field := "dependencies." + name
+		if !validDependencyName(name) {
+			return fmt.Errorf("%w: %s: invalid dependency name", ErrInvalidManifest, strconv.Quote(name))
+		}
+		dep.Path = strings.TrimSpace(dep.Path)
+		if dep.Path != "" && !fs.ValidPath(dep.Path) {
+			return fmt.Errorf("%w: %s.path: %q is not a path within the source directory", ErrInvalidManifest, field, dep.Path)
+		}
+
+		constraint, err := parseConstraint(dep.Version)
+		if err != nil {
+			return fmt.Errorf("%w: %s.version: %w", ErrInvalidManifest, field, err)
+		}
+		dep.Version = constraint.String()
+		m.Dependencies[name] = dep
+	}
+	if len(m.Dependencies) == 0 {
+		m.Dependencies = nil
+	}
+	return nil
+}
+
+// SatisfiesConstraint reports whether the semantic version v satisfies the
+// version constraint c, a comma-separated list of comparisons that must all
+// hold. A comparison is a version preceded by one of the operators
+//
+//	=, >, >=, <, <=  compare with the version; = is implied without operator
+//	^                accepts the same major version, at or above the version
+//	                 (the same minor version for major version 0)
+//	~                accepts the same minor version, at or above the version
+//
+// An empty constraint accepts any version, even an empty one; otherwise an
+// empty or invalid v does not satisfy c. SatisfiesConstraint returns an error
+// if c is not a valid constraint.
+func SatisfiesConstraint(v, c string) (bool, error) {
+	constraint, err := parseConstraint(c)
+	if err != nil {
+		return false, err
+	}
+	if len(constraint) == 0 {
+		return true, nil
+	}
+
+	v = "v" + strings.TrimPrefix(v, "v")
+	if !semver.IsValid(v) {
+		return false, nil
+	}
+	for _, cmp := range constraint {
+		if !cmp.match(v) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// constraint is a parsed version constraint.
+type constraint []comparison
+
+// comparison is a single comparison of a constraint.
+type comparison struct {
+	op string
+	// version is the canonical semantic version, with a "v" prefix.
+	version string
+}
+
+// operators are the comparison operators, longest first so that ">=" is not
+// read as ">".
+var operators = []string{">=", "<=", ">", "<", "=", "^", "~"}
+
+func parseConstraint(s string) (constraint, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	var c constraint
+	for part := range strings.SplitSeq(s, ",") {
+		part = strings.TrimSpace(part)
+		op := "="
+		for _, o := range operators {
+			if strings.HasPrefix(part, o) {
+				op = o
+				part = strings.TrimSpace(strings.TrimPrefix(part, o))
+				break
+			}
+		}
+		v := "v" + strings.TrimPrefix(part, "v")
+		if !semver.IsValid(v) {
+			return nil, fmt.Errorf("%q is not a semantic version", part)
+		}
+		c = append(c, comparison{op: op, version: semver.Canonical(v)})
+	}
+	return c, nil
+}
+
+// String returns the constraint in normalized form, e.g. ">=1.2.0, <2.0.0".
+func (c constraint) String() string {
+	parts := make([]string, 0, len(c))
+	for _, cmp := range c {
+		op := cmp.op
+		if op == "=" {
+			op = ""
+		}
+		parts = append(parts, op+strings.TrimPrefix(cmp.version, "v"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// match reports whether the valid semantic version v satisfies the comparison.
+func (cmp comparison) match(v string) bool {
+	n := semver.Compare(v, cmp.version)
+	switch cmp.op {
+	case "=":
+		return n == 0
+	case ">":
+		return n > 0
+	case ">=":
+		return n >= 0
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case "^":
+		if semver.Major(cmp.version) == "v0" {
+			return n >= 0 && semver.MajorMinor(v) == semver.MajorMinor(cmp.version)
+		}
+		return n >= 0 && semver.Major(v) == semver.Major(cmp.version)
+	case "~":
+		return n >= 0 && semver.MajorMinor(v) == semver.MajorMinor(cmp.version)
+	}
+	return false
+}
@@ -0,0 +1,370 @@
+package packager
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// EncryptionFile is the path, inside an encrypted package, of the header that
+// holds the package's wrapped content key.
+const EncryptionFile = "encryption.json"
+
+// KeySize is the size in bytes of an encryption key: packages are encrypted
+// with AES-256.
+const KeySize = 32
+
+// encryptionAlgorithm is the only supported encryption algorithm.
+const encryptionAlgorithm = "aes-256-gcm"
+
+// keyIDPrefix and contentKeyData separate the uses of the key material, so
+// that neither the key ID nor the wrapped content key can be mistaken for
+// anything else.
+const (
+	keyIDPrefix    = "ren key id\x00"
+	contentKeyData = "ren package content key"
+)
+
+var (
+	// ErrMissingKey is returned when a package is encrypted and no decryption
+	// key was given.
+	ErrMissingKey = errors.New("missing decryption key")
+	// ErrWrongKey is returned when the decryption key given for an encrypted
+	// package is not the one it was encrypted with.
+	ErrWrongKey = errors.New("wrong decryption key")
+	// ErrCorrupted is returned when an entry of an encrypted package fails to
+	// decrypt although the key is right, i.e. it was modified after the
+	// package was built.
+	ErrCorrupted = errors.New("corrupted package entry")
+)
+
+// encryption is the content of EncryptionFile.
+type encryption struct {
+	Algorithm string `json:"algorithm"`
+	// KeyID identifies the key the content key is wrapped with (see KeyID).
+	KeyID string `json:"key_id"`
+	// WrappedKey is the content key, encrypted with the package key.
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+// KeyID returns the identifier of an encryption key, recorded in the packages
+// it encrypts so that the runtime can tell which key a package needs. It is
+// derived from the key by a one-way hash.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte(keyIDPrefix), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// isPlainEntry reports whether the package entry name is stored unencrypted in
+// an encrypted package: directories, and the files the runtime must read before
+// it can decrypt anything or that describe the package as a whole.
+func isPlainEntry(name string) bool {
+	switch name {
+	case EncryptionFile, SignatureFile, ManifestFile:
+		return true
+	}
+	return strings.HasSuffix(name, "/")
+}
+
+// newGCM returns an AES-GCM cipher for key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with aead under a random nonce, which it prepends to
+// the result. The ciphertext is bound to data: it only decrypts with the same
+// data.
+func seal(aead cipher.AEAD, plaintext, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, data), nil
+}
+
+// unseal decrypts ciphertext produced by seal with the same data.
+func unseal(aead cipher.AEAD, ciphertext, data []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, data)
+}
+
+// encrypter encrypts the entries of a package with a random content key,
+// itself wrapped with the package key.
+type encrypter struct {
+	aead   cipher.AEAD
+	header []byte
+}
+
+func newEncrypter(key []byte) (*encrypter, error) {
+	keyAEAD, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	contentKey := make([]byte, KeySize)
+	_, err = rand.Read(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := seal(keyAEAD, contentKey, []byte(contentKeyData))
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.MarshalIndent(encryption{
+		Algorithm:  encryptionAlgorithm,
+		KeyID:      KeyID(key),
+		WrappedKey: wrapped,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return &encrypter{
+		aead:   aead,
+		header: append(header, '\n'),
+	}, nil
+}
+
+// encrypt returns the encrypted content of the entry name, bound to the name
+// so that entries cannot be swapped.
+func (e *encrypter) encrypt(name string, content []byte) ([]byte, error) {
+	return seal(e.aead, content, []byte(name))
+}
+
+// EncryptionKeyID returns the ID of the key the package is encrypted with (see
+// KeyID), or an empty string if the package is not encrypted.
+func EncryptionKeyID(zr *zip.Reader) (string, error) {
+	enc, err := readEncryption(zr)
+	if err != nil || enc == nil {
+		return "", err
+	}
+	return enc.KeyID, nil
+}
+
+// readEncryption reads the encryption header of the package, or returns nil if
+// the package is not encrypted.
+func readEncryption(zr *zip.Reader) (*encryption, error) {
+	b, err := fs.ReadFile(zr, EncryptionFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var enc encryption
+	err = json.Unmarshal(b, &enc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", EncryptionFile, err)
+	}
+	if enc.Algorithm != encryptionAlgorithm {
+		return nil, fmt.Errorf("%s: unsupported algorithm %q", EncryptionFile, enc.Algorithm)
+	}
+	return &enc, nil
+}
+
+// Decrypt returns a filesystem presenting the contents of the package
+// decrypted with key. Entries are decrypted as they are read. If the package is
+// not encrypted, Decrypt returns zr itself and ignores key. It returns
+// ErrMissingKey if key is nil and ErrWrongKey if the package was encrypted
+// with another key.
+func Decrypt(zr *zip.Reader, key []byte) (fs.FS, error) {
+	enc, err := readEncryption(zr)
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
+		return zr, nil
+	}
+	if key == nil {
+		return nil, ErrMissingKey
+	}
+	if KeyID(key) != enc.KeyID {
+		return nil, ErrWrongKey
+	}
+
+	keyAEAD, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	contentKey, err := unseal(keyAEAD, enc.WrappedKey, []byte(contentKeyData))
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	aead, err := newGCM(contentKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", EncryptionFile, err)
+	}
+
+	return &decryptFS{zr: zr, aead: aead}, nil
+}
+
+// decryptFS presents the decrypted contents of an encrypted package.
+type decryptFS struct {
+	zr   *zip.Reader
+	aead cipher.AEAD
+}
+
+var (
+	_ fs.ReadDirFS = (*decryptFS)(nil)
+	_ fs.StatFS    = (*decryptFS)(nil)
+)
+
+func (d *decryptFS) Open(name string) (fs.File, error) {
+	f, err := d.zr.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		if dir, ok := f.(fs.ReadDirFile); ok {
+			return &decryptedDir{ReadDirFile: dir, d: d, name: name}, nil
+		}
+		return f, nil
+	}
+	if isPlainEntry(name) {
+		return f, nil
+	}
+
+	ciphertext, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := unseal(d.aead, ciphertext, []byte(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrCorrupted}
+	}
+	return &decryptedFile{
+		Reader: bytes.NewReader(plaintext),
+		info:   decryptedInfo{FileInfo: info, size: int64(len(plaintext))},
+	}, nil
+}
+
+func (d *decryptFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fs.Stat(d.zr, name)
+	if err != nil {
+		return nil, err
+	}
+	return d.info(name, info), nil
+}
+
+func (d *decryptFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(d.zr, name)
+	if err != nil {
+		return nil, err
+	}
+	return d.entries(name, entries), nil
+}
+
+// info returns the information about the entry name with its decrypted size.
+func (d *decryptFS) info(name string, info fs.FileInfo) fs.FileInfo {
+	if info.IsDir() || isPlainEntry(name) {
+		return info
+	}
+	size := info.Size() - int64(d.aead.NonceSize()+d.aead.Overhead())
+	return decryptedInfo{FileInfo: info, size: max(size, 0)}
+}
+
+// entries returns the entries of the directory dir with their decrypted
+// sizes.
+func (d *decryptFS) entries(dir string, entries []fs.DirEntry) []fs.DirEntry {
+	result := make([]fs.DirEntry, len(entries))
+	for i, entry := range entries {
+		result[i] = decryptedEntry{DirEntry: entry, d: d, name: joinPath(dir, entry.Name())}
+	}
+	return result
+}
+
+// joinPath joins the package directory dir and name.
+func joinPath(dir, name string) string {
+	if dir == "." {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// decryptedFile is an open, decrypted entry of an encrypted package.
+type decryptedFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *decryptedFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *decryptedFile) Close() error {
+	return nil
+}
+
+// decryptedDir is an open directory of an encrypted package.
+type decryptedDir struct {
+	fs.ReadDirFile
+	d    *decryptFS
+	name string
+}
+
+func (f *decryptedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := f.ReadDirFile.ReadDir(n)
+	return f.d.entries(f.name, entries), err
+}
+
+// decryptedEntry is a directory entry of an encrypted package.
+type decryptedEntry struct {
+	fs.DirEntry
+	d    *decryptFS
+	name string
+}
+
+func (e decryptedEntry) Info() (fs.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return e.d.info(e.name, info), nil
+}
+
+// decryptedInfo reports the size of an entry once decrypted.
+type decryptedInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i decryptedInfo) Size() int64 {
+	return i.size
+}
+
+func (i decryptedInfo) Sys() any {
+	return nil
+}
@@ -0,0 +1,419 @@
+package packager
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/deepnoodle-ai/risor/v2/pkg/ast"
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+
+	"github.com/foohq/urlpath"
+)
+
+// Import URL schemes, as resolved by the runtime's importer (see also
+// dependencyScheme).
+const (
+	builtinScheme = "builtin"
+	packageScheme = "package"
+)
+
+// ErrUnresolvedImport is returned, wrapped in an ImportError, when a script
+// imports a module that does not exist or imports its way into a cycle.
+var ErrUnresolvedImport = errors.New("unresolved import")
+
+// ImportError reports an import() call that cannot succeed at run time, at the
+// position of the call in the importing script. Build collects every such error
+// before failing, joined with errors.Join.
+type ImportError struct {
+	// File is the package-relative path of the importing script.
+	File string
+	// Line and Column locate the import() call, starting at 1.
+	Line   int
+	Column int
+	// Import is the imported name, e.g. "lib/util" or "builtin://os".
+	Import string
+	// Reason describes why the import cannot succeed.
+	Reason string
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: cannot import %q: %s", e.File, e.Line, e.Column, e.Import, e.Reason)
+}
+
+func (e *ImportError) Unwrap() error {
+	return ErrUnresolvedImport
+}
+
+// Warning is a problem found while building a package that does not prevent
+// the build, such as an import that cannot be checked. See WithWarnings.
+type Warning struct {
+	// File is the package-relative path of the script the warning is about.
+	File string
+	// Line and Column locate the problem, starting at 1. They are 0 for a
+	// warning about the whole file.
+	Line    int
+	Column  int
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Line == 0 {
+		return fmt.Sprintf("%s: %s", w.File, w.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", w.File, w.Line, w.Column, w.Message)
+}
+
+// importRef is an import() call with a string literal argument.
+type importRef struct {
+	file   string
+	line   int
+	column int
+	name   string
+	// topLevel is true if the call runs when the module is loaded, i.e. it is
+	// not inside a function, and so takes part in import cycles.
+	topLevel bool
+}
+
+func (r importRef) String() string {
+	return fmt.Sprintf("%s:%d:%d", r.file, r.line, r.column)
+}
+
+// packageName returns the imported name without the package scheme.
+func (r importRef) packageName() string {
+	return strings.TrimPrefix(r.name, packageScheme+"://")
+}
+
+// path returns the path of the package module the import refers to.
+func (r importRef) path() (string, error) {
+	return importPath(r.file, r.packageName())
+}
+
+// dataPath returns the path of the package data file the import refers to.
+func (r importRef) dataPath() (string, error) {
+	return PackagePath(r.file, r.packageName())
+}
+
+func (r importRef) error(reason string) *ImportError {
+	return &ImportError{
+		File:   r.file,
+		Line:   r.line,
+		Column: r.column,
+		Import: r.name,
+		Reason: reason,
+	}
+}
+
+// importGraph records the modules compiled into a package, its data files and
+// the imports of every script, so that they can be checked once the whole
+// package is built.
+type importGraph struct {
+	// modules maps the path of each importable module in the package, e.g.
+	// "lib/util.json", to the script it was compiled from.
+	modules map[string]string
+	// files is the set of the paths of the data files in the package, which
+	// can be imported as values.
+	files map[string]bool
+	// imports maps each script to its literal imports, in source order.
+	imports map[string][]importRef
+	// scripts lists the scripts in the order they were compiled.
+	scripts []string
+	// deps maps the name of each dependency to the set of the paths of its
+	// modules and data files, or to nil if it is provided at run time and
+	// cannot be checked.
+	deps map[string]map[string]bool
+}
+
+func newImportGraph() *importGraph {
+	return &importGraph{
+		modules: make(map[string]string),
+		files:   make(map[string]bool),
+		imports: make(map[string][]importRef),
+		deps:    make(map[string]map[string]bool),
+	}
+}
+
+// addDependency records the dependency name, vendored with the given modules
+// and data files, or provided at run time if files is nil.
+func (g *importGraph) addDependency(name string, files []string) {
+	if files == nil {
+		g.deps[name] = nil
+		return
+	}
+	set := make(map[string]bool, len(files))
+	for _, f := range files {
+		set[f] = true
+	}
+	g.deps[name] = set
+}
+
+// addFile records the data file at the package path pth.
+func (g *importGraph) addFile(pth string) {
+	g.files[pth] = true
+}
+
+// isVendored reports whether the package path rel lies in the directory of a
+// vendored dependency.
+func (g *importGraph) isVendored(rel string) bool {
+	for name, modules := range g.deps {
+		if modules != nil && strings.HasPrefix(rel, DependencyDir+"/"+name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// add records the script file, compiled from prog, and its imports. A module
+// is a script that others can import; the entrypoint is not. Imports whose
+// name is not a string literal cannot be checked and are reported to warn.
+//
+// Relative imports ("./util", "../shared/log") are rewritten in prog to the
+// path they resolve to from the script's directory. The runtime can only
+// resolve a relative name against the module being loaded, which is not the
+// module an exported function comes from once it is called from elsewhere.
+func (g *importGraph) add(file, module string, prog *ast.Program, warn func(Warning)) {
+	if module != "" {
+		g.modules[module] = file
+	}
+	g.scripts = append(g.scripts, file)
+
+	var visit func(node ast.Node, topLevel bool)
+	visit = func(node ast.Node, topLevel bool) {
+		ast.Inspect(node, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.Func:
+				// The body of a function runs when it is called, not when the
+				// module is loaded.
+				if node.Body != nil {
+					visit(node.Body, false)
+				}
+				return false
+			case *ast.Call:
+				fun, ok := node.Fun.(*ast.Ident)
+				if !ok || fun.Name != "import" || len(node.Args) == 0 {
+					return true
+				}
+				pos := node.Pos()
+				s, ok := node.Args[0].(*ast.String)
+				if !ok || s.Template != nil {
+					if warn != nil {
+						warn(Warning{
+							File:    file,
+							Line:    pos.LineNumber(),
+							Column:  pos.ColumnNumber(),
+							Message: "import of a non-literal name cannot be checked",
+						})
+					}
+					return true
+				}
+				ref := importRef{
+					file:     file,
+					line:     pos.LineNumber(),
+					column:   pos.ColumnNumber(),
+					name:     s.Value,
+					topLevel: topLevel,
+				}
+				g.imports[file] = append(g.imports[file], ref)
+				if IsRelativeImport(ref.packageName()) {
+					if pth, err := ref.dataPath(); err == nil {
+						s.Value = pth
+						s.Literal = strconv.Quote(pth)
+					}
+				}
+			}
+			return true
+		})
+	}
+	visit(prog, true)
+}
+
+// check resolves every recorded import against the package's modules and the
+// built-in modules configured with WithModule, and looks for import cycles.
+// Imports of the schemes declared with WithScheme are left to the runtime. It
+// returns all the problems found, joined.
+func (g *importGraph) check(opts *options) error {
+	var errs []error
+	// deps maps each script to the scripts its top-level imports load.
+	deps := make(map[string][]importRef)
+	for _, file := range g.scripts {
+		for _, ref := range g.imports[file] {
+			scheme, err := urlpath.Scheme(ref.name)
+			if err != nil {
+				errs = append(errs, ref.error(err.Error()))
+				continue
+			}
+
+			switch scheme {
+			case "", packageScheme:
+				pth, err := ref.path()
+				if err != nil {
+					errs = append(errs, ref.error(err.Error()))
+					continue
+				}
+				if _, ok := g.modules[pth]; !ok {
+					if data, _ := ref.dataPath(); g.files[data] {
+						continue
+					}
+					errs = append(errs, ref.error("no such module in the package"))
+					continue
+				}
+				if ref.topLevel {
+					deps[file] = append(deps[file], ref)
+				}
+			case dependencyScheme:
+				dep, data, err := DependencyPath(ref.name)
+				if err != nil {
+					errs = append(errs, ref.error(err.Error()))
+					continue
+				}
+				files, ok := g.deps[dep]
+				if !ok {
+					errs = append(errs, ref.error(fmt.Sprintf("no dependency %q in the manifest", dep)))
+					continue
+				}
+				if files != nil && !files[ModulePath(data)] && !files[data] {
+					errs = append(errs, ref.error(fmt.Sprintf("no such module in dependency %q", dep)))
+				}
+			case builtinScheme:
+				// Without configured modules, the runtime's set is unknown.
+				if len(opts.modules) == 0 {
+					continue
+				}
+				name := strings.TrimPrefix(ref.name, builtinScheme+"://")
+				if !slices.ContainsFunc(opts.modules, func(mod *object.Module) bool {
+					return mod.Name().Value() == name
+				}) {
+					errs = append(errs, ref.error("no such built-in module"))
+				}
+			default:
+				if slices.Contains(opts.schemes, scheme) {
+					continue
+				}
+				errs = append(errs, ref.error(fmt.Sprintf("unsupported scheme %q", scheme)))
+			}
+		}
+	}
+
+	errs = append(errs, g.cycles(deps)...)
+	return errors.Join(errs...)
+}
+
+// cycles returns an error for each import cycle among the scripts, following
+// only imports made at load time: an import inside a function runs after the
+// importing module has loaded and does not deadlock.
+func (g *importGraph) cycles(deps map[string][]importRef) []error {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var (
+		errs  []error
+		stack []importRef
+		visit func(file string)
+	)
+	visit = func(file string) {
+		state[file] = visiting
+		for _, ref := range deps[file] {
+			pth, _ := ref.path()
+			target := g.modules[pth]
+			stack = append(stack, ref)
+			switch state[target] {
+			case unvisited:
+				visit(target)
+			case visiting:
+				// The cycle is the part of the stack starting at target.
+				start := slices.IndexFunc(stack, func(r importRef) bool {
+					return r.file == target
+				})
+				chain := make([]string, 0, len(stack)-start)
+				for _, r := range stack[start:] {
+					chain = append(chain, fmt.Sprintf("%s imports %q", r, r.name))
+				}
+				errs = append(errs, ref.error("import cycle: "+strings.Join(chain, ", ")))
+			}
+			stack = stack[:len(stack)-1]
+		}
+		state[file] = done
+	}
+
+	for _, file := range g.scripts {
+		if state[file] == unvisited {
+			visit(file)
+		}
+	}
+	return errs
+}
+
+// importPath converts a package import name into the path of the compiled
+// module within the package (see PackagePath).
+func importPath(file, name string) (string, error) {
+	pth, err := PackagePath(file, name)
+	if err != nil {
+		return "", err
+	}
+	return ModulePath(pth), nil
+}
+
+// PackagePath converts a package import name into a path within the package,
+// following the runtime's rules: the path is relative to the package root, or
+// to the directory of file, the importing script or module, if name starts
+// with "./" or "../", and cannot escape the root. It names a data file, if the
+// package has one at the path, and a module otherwise (see ModulePath).
+func PackagePath(file, name string) (string, error) {
+	var pth string
+	if IsRelativeImport(name) {
+		pth = path.Join(path.Dir(file), name)
+		if pth == ".." || strings.HasPrefix(pth, "../") {
+			return "", errors.New("import path escapes the package root")
+		}
+	} else {
+		var err error
+		pth, err = urlpath.Path(name)
+		if err != nil {
+			return "", err
+		}
+		pth = strings.TrimPrefix(pth, "/")
+	}
+	if pth == "" || pth == "." || strings.HasPrefix(pth, "./") || pth == ".." || strings.HasPrefix(pth, "../") {
+		return "", errors.New("invalid import path")
+	}
+	return pth, nil
+}
+
+// ModulePath returns the path of the compiled module the package path pth
+// names, i.e. pth with the module extension.
+func ModulePath(pth string) string {
+	if !strings.HasSuffix(pth, moduleExt) {
+		pth += moduleExt
+	}
+	return pth
+}
+
+// IsRelativeImport reports whether the package import name is relative to the
+// importing script or module.
+func IsRelativeImport(name string) bool {
+	return strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../")
+}
+
+// DependencyPath splits the import name of a module or data file of a
+// dependency, e.g. "pkg://logging/json", into the name of the dependency and
+// the path within it, "logging" and "json" (see PackagePath).
+func DependencyPath(name string) (string, string, error) {
+	dep, mod, _ := strings.Cut(strings.TrimPrefix(name, dependencyScheme+"://"), "/")
+	if !validDependencyName(dep) {
+		return "", "", errors.New("invalid dependency name")
+	}
+	if IsRelativeImport(mod) {
+		return "", "", errors.New("invalid import path")
+	}
+	pth, err := PackagePath("", mod)
+	if err != nil {
+		return "", "", err
+	}
+	return dep, pth, nil
+}
@@ -0,0 +1,286 @@
+package packager
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/deepnoodle-ai/risor/v2/pkg/bytecode"
+	"github.com/deepnoodle-ai/risor/v2/pkg/op"
+)
+
+// PackageInfo describes the contents of a package, as returned by Inspect.
+type PackageInfo struct {
+	// Manifest is the manifest of the package, or nil if it has none.
+	Manifest *Manifest `json:"manifest,omitempty"`
+	// Signature is the state of the package signature.
+	Signature SignatureInfo `json:"signature"`
+	// EncryptionKeyID is the ID of the key the package is encrypted with (see
+	// KeyID), or empty if it is not encrypted.
+	EncryptionKeyID string `json:"encryption_key_id,omitempty"`
+	// Lock is the lockfile of the package, listing its dependencies, or nil
+	// if it has none.
+	Lock *Lock `json:"lock,omitempty"`
+	// Modules lists the compiled scripts of the package, the entrypoint
+	// included, sorted by path.
+	Modules []ModuleInfo `json:"modules"`
+	// Files lists the data files of the package, sorted by path. The entries
+	// of an encrypted package inspected without its key are all listed here.
+	Files []FileInfo `json:"files"`
+}
+
+// SignatureInfo is the state of the signature of a package.
+type SignatureInfo struct {
+	// Signed is true if the package has a signature, valid or not.
+	Signed bool `json:"signed"`
+	// Valid is true if the signature matches the contents of the package.
+	Valid bool `json:"valid"`
+	// PublicKey is the key the package was signed with, if the signature is
+	// valid. Whether it is trusted is up to the caller (see Verify).
+	PublicKey ed25519.PublicKey `json:"public_key,omitempty"`
+	// Error describes why the signature is invalid.
+	Error string `json:"error,omitempty"`
+}
+
+// FileInfo describes a data file of a package.
+type FileInfo struct {
+	Path string `json:"path"`
+	// Size is the size of the file in bytes, once decompressed.
+	Size int64 `json:"size"`
+}
+
+// ModuleInfo describes a compiled script of a package.
+type ModuleInfo struct {
+	Path string `json:"path"`
+	// Size is the size of the compiled module in bytes, once decompressed.
+	Size int64 `json:"size"`
+	// Source is the path of the script the module was compiled from, e.g.
+	// "lib/util.risor".
+	Source string `json:"source,omitempty"`
+	// Exports lists, sorted, the top-level names of the script, which a
+	// module exports to its importers and the entrypoint to an instance.
+	Exports []string `json:"exports"`
+	// Imports lists the names the script imports with string literals, as
+	// written, in source order.
+	Imports []string `json:"imports"`
+	// Globals lists the globals the script uses but does not define, i.e.
+	// the builtins it needs at run time.
+	Globals []string `json:"globals"`
+	// Code is the bytecode of the module, e.g. to disassemble it.
+	Code *bytecode.Code `json:"-"`
+}
+
+// Inspect reads the package from r and describes it: its manifest, signature,
+// dependencies, modules and data files. The exports and imports of a module
+// are found in the source recorded in its bytecode. An encrypted package is
+// decrypted with the key given with WithEncryptionKey; without it, its entries
+// are listed as files, with their encrypted sizes.
+func Inspect(r io.ReaderAt, size int64, opt ...Option) (*PackageInfo, error) {
+	var opts options
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &PackageInfo{
+		Modules: []ModuleInfo{},
+		Files:   []FileInfo{},
+	}
+	info.Signature = inspectSignature(zr)
+	info.EncryptionKeyID, err = EncryptionKeyID(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := fs.ReadFile(zr, ManifestFile)
+	if err == nil {
+		info.Manifest, err = ParseManifest(b)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	var files fs.FS = zr
+	if info.EncryptionKeyID != "" && opts.encryptionKey == nil {
+		files = nil
+	} else if info.EncryptionKeyID != "" {
+		files, err = Decrypt(zr, opts.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var modules map[string]*bytecode.Code
+	if files != nil {
+		modules, err = ReadModules(files)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") || IsMetadataFile(f.Name) {
+			continue
+		}
+		if files == nil {
+			info.Files = append(info.Files, FileInfo{Path: f.Name, Size: int64(f.UncompressedSize64)})
+			continue
+		}
+
+		fi, err := fs.Stat(files, f.Name)
+		if err != nil {
+			return nil, err
+		}
+		code, ok := modules[f.Name]
+		if !ok {
+			info.Files = append(info.Files, FileInfo{Path: f.Name, Size: fi.Size()})
+			continue
+		}
+		mod, err := inspectModule(f.Name, code)
+		if err != nil {
+			return nil, err
+		}
+		mod.Size = fi.Size()
+		info.Modules = append(info.Modules, mod)
+	}
+	if files != nil {
+		info.Lock, err = ReadLock(files)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slices.SortFunc(info.Modules, func(a, b ModuleInfo) int { return strings.Compare(a.Path, b.Path) })
+	slices.SortFunc(info.Files, func(a, b FileInfo) int { return strings.Compare(a.Path, b.Path) })
+	return info, nil
+}
+
+// IsMetadataFile reports whether the package path name is one of the files
+// that describe the package rather than belong to it: its manifest, module
+// index, lockfile, signature and encryption header. A source directory cannot
+// hold files that would be packaged at these paths.
+func IsMetadataFile(name string) bool {
+	switch name {
+	case ManifestFile, SignatureFile, EncryptionFile, LockFile, IndexFile:
+		return true
+	}
+	return false
+}
+
+func inspectSignature(zr *zip.Reader) SignatureInfo {
+	key, err := Signer(zr)
+	switch {
+	case errors.Is(err, ErrUnsigned):
+		return SignatureInfo{}
+	case err != nil:
+		return SignatureInfo{Signed: true, Error: err.Error()}
+	}
+	return SignatureInfo{Signed: true, Valid: true, PublicKey: key}
+}
+
+// inspectModule describes the module at pth compiled to code.
+func inspectModule(pth string, code *bytecode.Code) (ModuleInfo, error) {
+	mod := ModuleInfo{
+		Path:    pth,
+		Source:  code.Filename(),
+		Exports: []string{},
+		Imports: []string{},
+		Globals: GlobalsUsed(code),
+		Code:    code,
+	}
+
+	source := code.Source()
+	if source == "" {
+		return mod, nil
+	}
+	prog, err := parseSource(context.Background(), mod.Source, source)
+	if err != nil {
+		return ModuleInfo{}, err
+	}
+
+	imports := newImportGraph()
+	imports.add(mod.Source, "", prog, nil)
+	for _, ref := range imports.imports[mod.Source] {
+		mod.Imports = append(mod.Imports, ref.name)
+	}
+
+	mod.Exports, err = Exports(code)
+	if err != nil {
+		return ModuleInfo{}, err
+	}
+	return mod, nil
+}
+
+// Exports returns the sorted top-level names of the script compiled to code,
+// i.e. the names a module returns in its export map (see wrapModule). They are
+// found in the source recorded in code; a script without one exports nothing.
+func Exports(code *bytecode.Code) ([]string, error) {
+	source := code.Source()
+	if source == "" {
+		return []string{}, nil
+	}
+	prog, err := parseSource(context.Background(), code.Filename(), source)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compiling the script unwrapped reveals its top-level names, as when it
+	// was built (see compileScript).
+	globals := GlobalsUsed(code)
+	compiled, err := compileProgram(code.Filename(), source, prog, globals)
+	if err != nil {
+		return nil, err
+	}
+	names, err := topLevelNames(compiled, globals)
+	if err != nil {
+		return nil, err
+	}
+	if names == nil {
+		return []string{}, nil
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// GlobalsUsed returns the sorted names of the globals code loads but never
+// stores, i.e. the builtins it expects the runtime to provide.
+func GlobalsUsed(code *bytecode.Code) []string {
+	loaded := make(map[string]bool)
+	stored := make(map[string]bool)
+	for _, c := range code.Flatten() {
+		iter := bytecode.NewInstructionIter(c)
+		for {
+			instr, ok := iter.Next()
+			if !ok {
+				break
+			}
+			if len(instr) < 2 {
+				continue
+			}
+			switch instr[0] {
+			case op.LoadGlobal:
+				loaded[code.GlobalNameAt(int(instr[1]))] = true
+			case op.StoreGlobal:
+				stored[code.GlobalNameAt(int(instr[1]))] = true
+			}
+		}
+	}
+
+	globals := []string{}
+	for name := range loaded {
+		if name != "" && !stored[name] {
+			globals = append(globals, name)
+		}
+	}
+	slices.Sort(globals)
+	return globals
+}
@@ -0,0 +1,177 @@
+package packager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/mod/semver"
+)
+
+// ManifestFile is the path of the manifest inside a package.
+const ManifestFile = "ren.json"
+
+// tomlManifestFile is the path of the manifest inside a source directory.
+const tomlManifestFile = "ren.toml"
+
+// ErrInvalidManifest is returned when the manifest of a source directory
+// cannot be parsed or holds invalid values.
+var ErrInvalidManifest = errors.New("invalid manifest")
+
+// Manifest describes a package: its metadata and what it requires from the
+// runtime that executes it. A source directory may declare it in a ren.toml at
+// its root; Build validates it and stores it in the package, normalized and in
+// JSON form, as ManifestFile.
+//
+// In ren.toml the manifest looks like
+//
+//	name = "hello"
+//	version = "1.0.0"
+//	authors = ["Jane Doe <jane@example.com>"]
+//
+//	[requires]
+//	ren = "0.2.0"
+//	modules = ["os"]
+//	builtins = ["print"]
+//
+//	[dependencies]
+//	logging = { version = "^1.2.0", path = "deps/logging.zip" }
+type Manifest struct {
+	// Name is the name of the package.
+	Name string `json:"name,omitempty" toml:"name"`
+	// Version is the version of the package, in semantic versioning form.
+	Version string `json:"version,omitempty" toml:"version"`
+	// Description is a short description of the package.
+	Description string `json:"description,omitempty" toml:"description"`
+	// Authors lists the authors of the package.
+	Authors []string `json:"authors,omitempty" toml:"authors"`
+	// License is the license of the package, e.g. an SPDX identifier.
+	License string `json:"license,omitempty" toml:"license"`
+	// Requires declares what the package needs from the runtime.
+	Requires Requirements `json:"requires,omitzero" toml:"requires"`
+	// Dependencies lists, by name, the packages whose modules the package
+	// imports with the pkg:// scheme (see Dependency).
+	Dependencies map[string]Dependency `json:"dependencies,omitempty" toml:"dependencies"`
+}
+
+// Requirements declares what a package needs from the runtime that executes
+// it. The runtime refuses to run a package whose requirements it does not
+// satisfy.
+type Requirements struct {
+	// Ren is the minimum version of Ren, in semantic versioning form. A
+	// pre-release of Ren satisfies it as the release it leads to would, e.g.
+	// 0.2.0-rc2 satisfies "0.2.0".
+	Ren string `json:"ren,omitempty" toml:"ren"`
+	// Modules lists the modules the package imports via the builtin:// scheme.
+	Modules []string `json:"modules,omitempty" toml:"modules"`
+	// Builtins lists the builtin functions the package calls.
+	Builtins []string `json:"builtins,omitempty" toml:"builtins"`
+}
+
+// ParseManifest parses a manifest in its JSON form, as stored in a package.
+// Unknown fields are rejected.
+func ParseManifest(b []byte) (*Manifest, error) {
+	var m Manifest
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&m)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidManifest, err)
+	}
+	return &m, nil
+}
+
+// parseTOMLManifest parses a manifest in its TOML form. Unknown fields are
+// rejected.
+func parseTOMLManifest(b []byte) (*Manifest, error) {
+	var m Manifest
+	md, err := toml.Decode(string(b), &m)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidManifest, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidManifest, undecoded[0].String())
+	}
+	return &m, nil
+}
+
+// readManifest reads the manifest of the source tree fsys from its ren.toml.
+// It returns nil if there is none.
+func readManifest(fsys fs.FS) (*Manifest, error) {
+	b, err := fs.ReadFile(fsys, tomlManifestFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := parseTOMLManifest(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", tomlManifestFile, err)
+	}
+	err = m.normalize()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", tomlManifestFile, err)
+	}
+	return m, nil
+}
+
+// normalize trims the manifest's values, sorts and deduplicates its lists and
+// validates its versions, dropping any "v" prefix, and its dependencies.
+func (m *Manifest) normalize() error {
+	m.Name = strings.TrimSpace(m.Name)
+	m.Description = strings.TrimSpace(m.Description)
+	m.License = strings.TrimSpace(m.License)
+	m.Authors = trimList(m.Authors)
+	m.Requires.Modules = slices.Compact(slices.Sorted(slices.Values(trimList(m.Requires.Modules))))
+	m.Requires.Builtins = slices.Compact(slices.Sorted(slices.Values(trimList(m.Requires.Builtins))))
+
+	var err error
+	m.Version, err = normalizeVersion("version", m.Version)
+	if err != nil {
+		return err
+	}
+	m.Requires.Ren, err = normalizeVersion("requires.ren", m.Requires.Ren)
+	if err != nil {
+		return err
+	}
+	return m.normalizeDependencies()
+}
+
+// trimList trims the values of list and drops those left empty.
+func trimList(list []string) []string {
+	var result []string
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// normalizeVersion validates the semantic version v of the manifest field
+// and returns it without a "v" prefix. An empty version is left empty.
+func normalizeVersion(field, v string) (string, error) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if v == "" {
+		return "", nil
+	}
+	if !semver.IsValid("v" + v) {
+		return "", fmt.Errorf("%w: %s: %q is not a semantic version", ErrInvalidManifest, field, v)
+	}
+	return v, nil
+}
+
+// CompareVersions compares the semantic versions a and b, with or without a
+// "v" prefix, and returns -1, 0 or +1 as a is lower than, equal to or greater
+// than b. An invalid version is lower than any valid one.
+func CompareVersions(a, b string) int {
+	return semver.Compare("v"+strings.TrimPrefix(a, "v"), "v"+strings.TrimPrefix(b, "v"))
+}
@@ -0,0 +1,194 @@
+package packager
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/deepnoodle-ai/risor/v2/pkg/bytecode"
+	"github.com/deepnoodle-ai/risor/v2/pkg/compiler"
+)
+
+// IndexFile is the path of the module index inside a package. It lists the
+// compiled modules of the package, so that they are told apart from data files
+// that share the module extension without looking at their content.
+const IndexFile = "ren.index"
+
+// entrypointModule is the path of the compiled entrypoint inside a package.
+const entrypointModule = "entrypoint" + moduleExt
+
+// ErrNotModule is returned by DecodeModule when its input is not a compiled
+// module.
+var ErrNotModule = errors.New("not a compiled module")
+
+// index is the content of IndexFile.
+type index struct {
+	// Modules lists the paths of the compiled modules, the entrypoint
+	// included, sorted.
+	Modules []string `json:"modules"`
+}
+
+// writeIndex writes the paths of the modules to pw as IndexFile.
+func writeIndex(pw *packageWriter, modules []string) error {
+	b, err := json.MarshalIndent(index{Modules: slices.Sorted(slices.Values(modules))}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	h := &zip.FileHeader{
+		Name:   IndexFile,
+		Method: zip.Deflate,
+	}
+	h.SetMode(0644)
+	return pw.create(h, append(b, '\n'))
+}
+
+// readIndex reads the IndexFile of the package files fsys, or returns nil if
+// it has none.
+func readIndex(fsys fs.FS) (*index, error) {
+	b, err := fs.ReadFile(fsys, IndexFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var idx index
+	err = json.Unmarshal(b, &idx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", IndexFile, err)
+	}
+	for _, name := range idx.Modules {
+		if !fs.ValidPath(name) || !strings.HasSuffix(name, moduleExt) || IsMetadataFile(name) {
+			return nil, fmt.Errorf("%s: invalid module path %q", IndexFile, name)
+		}
+	}
+	return &idx, nil
+}
+
+// vendoredIndex returns the IndexFile b of a dependency without its entrypoint,
+// which is not vendored.
+func vendoredIndex(b []byte) ([]byte, error) {
+	var idx index
+	err := json.Unmarshal(b, &idx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", IndexFile, err)
+	}
+	idx.Modules = slices.DeleteFunc(idx.Modules, func(name string) bool {
+		return name == entrypointModule
+	})
+	b, err = json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// ReadModules decodes the compiled modules of the package files fsys, keyed by
+// path, the entrypoint included. They are the modules listed in its IndexFile.
+// Packages built by earlier versions have no index; their modules are the
+// entries with the module extension that DecodeModule accepts, outside the
+// vendored dependencies.
+func ReadModules(fsys fs.FS) (map[string]*bytecode.Code, error) {
+	idx, err := readIndex(fsys)
+	if err != nil {
+		return nil, err
+	}
+	if idx == nil {
+		return findModules(fsys)
+	}
+
+	modules := make(map[string]*bytecode.Code, len(idx.Modules))
+	for _, name := range idx.Modules {
+		code, err := readModule(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		modules[name] = code
+	}
+	return modules, nil
+}
+
+// findModules decodes the modules of a package without an IndexFile (see
+// ReadModules). The entrypoint is always decoded.
+func findModules(fsys fs.FS) (map[string]*bytecode.Code, error) {
+	_, err := fs.Stat(fsys, LockFile)
+	vendored := err == nil
+
+	modules := make(map[string]*bytecode.Code)
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if vendored && name == DependencyDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(name, moduleExt) || IsMetadataFile(name) {
+			return nil
+		}
+
+		code, err := readModule(fsys, name)
+		if err != nil && name != entrypointModule {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		modules[name] = code
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return modules, nil
+}
+
+// readModule reads and decodes the compiled module at name.
+func readModule(fsys fs.FS, name string) (*bytecode.Code, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	code, err := DecodeModule(b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load %q: %w", name, err)
+	}
+	return code, nil
+}
+
+// DecodeModule decodes a compiled module, as written to a package by Build or
+// returned by CompileModule. Besides the packager's own encoding, it reads the
+// compiler's, used by packages built by earlier versions. It returns an error
+// wrapping ErrNotModule if b is in neither encoding.
+func DecodeModule(b []byte) (*bytecode.Code, error) {
+	var state struct {
+		Codes       []json.RawMessage `json:"codes"`
+		Code        []json.RawMessage `json:"code"`
+		SymbolTable json.RawMessage   `json:"symbol_table"`
+	}
+	err := json.Unmarshal(b, &state)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotModule, err)
+	}
+
+	switch {
+	case len(state.Codes) > 0:
+		// The packager's encoding, which records the source filename.
+		return bytecode.Unmarshal(b)
+	case len(state.Code) > 0 && len(state.SymbolTable) > 0:
+		code, err := compiler.UnmarshalCode(b)
+		if err != nil {
+			return nil, err
+		}
+		return code.ToBytecode(), nil
+	}
+	return nil, ErrNotModule
+}
@@ -1,23 +1,30 @@
 // Package packager builds runnable Ren packages from a source directory.
 //
 // A source directory must contain an entrypoint script (entrypoint.risor or
-// entrypoint.rsr). Build compiles every Risor script to bytecode — wrapping
-// non-entrypoint modules so their exports become self-contained closures — and
-// writes the compiled scripts plus any other files into a zip archive that the
-// ren runtime can execute.
+// entrypoint.rsr). It can live on disk or in any fs.FS (see BuildFS). Build
+// compiles every Risor script to bytecode — wrapping non-entrypoint modules so
+// their exports become self-contained closures — and writes the compiled
+// scripts plus any other files into a zip archive that the ren runtime can
+// execute. An optional ren.toml describes the package and its runtime
+// requirements (see Manifest).
 package packager
 
 import (
 	"archive/zip"
 	"context"
+	"crypto/ed25519"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/deepnoodle-ai/risor/v2/pkg/ast"
+	"github.com/deepnoodle-ai/risor/v2/pkg/bytecode"
 	"github.com/deepnoodle-ai/risor/v2/pkg/compiler"
 	"github.com/deepnoodle-ai/risor/v2/pkg/object"
 	"github.com/deepnoodle-ai/risor/v2/pkg/parser"
@@ -32,6 +39,9 @@ var (
 // exts are the recognized Risor script file extensions.
 var exts = []string{".risor", ".rsr"}
 
+// moduleExt is the extension of a compiled script inside a package.
+const moduleExt = ".json"
+
 // fileExt is the extension of a built package archive.
 const fileExt = "zip"
 
@@ -48,123 +58,272 @@ func NewFilename(name string) string {
 // dst. It requires src to contain an entrypoint script and returns
 // ErrMissingEntrypoint otherwise.
 func Build(src, dst string, opt ...Option) error {
+	// Write the archive to a temporary file in the destination directory and
+	// rename it into place once complete, so that dst is never left
+	// half-written. The rename stays within a single volume: renaming across
+	// drives fails on Windows, and across filesystems on Unix.
+	f, err := os.CreateTemp(filepath.Dir(dst), "ren*."+fileExt)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	err = BuildFS(context.Background(), os.DirFS(src), f, opt...)
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), dst)
+}
+
+// BuildFS compiles the source tree fsys into a package archive written to w,
+// following the same rules as Build. The archive is streamed to w as it is
+// built, without touching the disk, so packages can be built from generated
+// or embedded sources, e.g. an fstest.MapFS or an embed.FS. Compilation stops
+// with the context's error if ctx is canceled.
+func BuildFS(ctx context.Context, fsys fs.FS, w io.Writer, opt ...Option) error {
 	var opts options
 	for _, o := range opt {
 		o(&opts)
 	}
+	if opts.signingKey != nil && len(opts.signingKey) != ed25519.PrivateKeySize {
+		return ErrInvalidKey
+	}
+	if opts.encryptionKey != nil && len(opts.encryptionKey) != KeySize {
+		return ErrInvalidKey
+	}
 
-	err := isEntrypoint(src)
+	err := isEntrypoint(fsys, opts.entrypoint)
 	if err != nil {
 		return err
 	}
 
-	tmpDir, err := walkSourceDir(src, &opts)
+	manifest, err := readManifest(fsys)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = os.RemoveAll(tmpDir)
-	}()
 
-	// Create the temporary zip in the destination directory so that the final
-	// rename stays within a single volume. Renaming across drives fails on
-	// Windows, and across filesystems on Unix.
-	tmpZip, err := createTempZip(tmpDir, filepath.Dir(dst))
+	pw, err := newPackageWriter(w, &opts)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = os.Remove(tmpZip)
-	}()
+	if manifest != nil {
+		err = writeManifest(pw, manifest)
+		if err != nil {
+			return err
+		}
+	}
+
+	imports := newImportGraph()
+	if manifest != nil && len(manifest.Dependencies) > 0 {
+		pinned, err := ReadLock(fsys)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidDependency, err)
+		}
+		lock, err := writeDependencies(pw, fsys, manifest, pinned, imports)
+		if err != nil {
+			return err
+		}
+		err = writeLock(pw, lock)
+		if err != nil {
+			return err
+		}
+	}
 
-	err = os.Rename(tmpZip, dst)
+	modules, err := writeSourceFS(ctx, pw, fsys, &opts, manifest, imports)
+	if err != nil {
+		return err
+	}
+	err = writeIndex(pw, modules)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	err = imports.check(&opts)
+	if err != nil {
+		return err
+	}
+
+	return pw.close()
 }
 
-// walkSourceDir copies src into a new temporary directory, compiling Risor
-// scripts to bytecode and copying all other files verbatim. It returns the path
-// to the temporary directory, which the caller is responsible for removing.
-func walkSourceDir(src string, opts *options) (string, error) {
-	tmpDir, err := os.MkdirTemp(".", "ren*")
-	if err != nil {
-		return "", err
+// writeSourceFS writes the contents of fsys to pw, compiling Risor scripts to
+// bytecode and copying all other files verbatim, and returns the paths of the
+// compiled modules. The imports of every script are recorded in imports. The package files of the dependencies declared in
+// manifest, which may be nil, are left out.
+func writeSourceFS(ctx context.Context, pw *packageWriter, fsys fs.FS, opts *options, manifest *Manifest, imports *importGraph) ([]string, error) {
+	depFiles := make(map[string]bool)
+	if manifest != nil {
+		for _, dep := range manifest.Dependencies {
+			if dep.Path != "" {
+				depFiles[dep.Path] = true
+			}
+		}
 	}
 
-	srcPrefix := ""
-	err = filepath.Walk(src, func(srcPth string, info os.FileInfo, err error) error {
+	var modules []string
+	err := fs.WalkDir(fsys, ".", func(rel string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-
-		srcPth = filepath.ToSlash(srcPth)
-		if srcPrefix == "" {
-			srcPrefix = filepath.ToSlash(filepath.Clean(srcPth))
+		if d.IsDir() {
+			return nil
+		}
+		err = ctx.Err()
+		if err != nil {
+			return err
 		}
 
-		if info.IsDir() {
-			// Skip a directory...
+		dst := rel
+		isScript := isRisorScript(rel)
+		if isScript {
+			dst = replaceScriptExt(rel)
+		}
+		wrap := !isEntrypointFile(rel)
+		switch {
+		case rel == opts.entrypoint:
+			// The script chosen with WithEntrypoint replaces the entrypoint.
+			dst = entrypointModule
+			wrap = false
+		case opts.entrypoint != "" && isEntrypointFile(rel):
+			return nil
+		case IsTestFile(rel) && !opts.tests:
+			if opts.warn != nil {
+				opts.warn(Warning{File: rel, Message: "test script left out of the package"})
+			}
+			return nil
+		case rel == tomlManifestFile:
+			// The manifest is written normalized by writeManifest.
+			return nil
+		case rel == LockFile:
+			// The lockfile is written, updated, by writeLock.
 			return nil
+		case depFiles[rel]:
+			return nil
+		case dst == ManifestFile && isScript:
+			return fmt.Errorf("%s: compiles to %s, the name of the package manifest", rel, dst)
+		case IsMetadataFile(dst) && dst != rel:
+			return fmt.Errorf("%s: compiles to %s, a reserved file name", rel, dst)
+		case IsMetadataFile(dst):
+			return fmt.Errorf("%s: reserved file name", rel)
+		case imports.isVendored(dst):
+			return fmt.Errorf("%s: conflicts with a vendored dependency", rel)
 		}
 
-		dstPth := filepath.Join(tmpDir, strings.TrimPrefix(srcPth, srcPrefix))
-
-		err = os.MkdirAll(filepath.Dir(dstPth), 0755)
+		info, err := d.Info()
 		if err != nil {
 			return err
 		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			// Follow symbolic links to the file they point to.
+			info, err = fs.Stat(fsys, rel)
+			if err != nil {
+				return err
+			}
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s: not a regular file", rel)
+		}
 
-		if isRisorScript(srcPth) {
-			rel := strings.TrimPrefix(srcPth, srcPrefix)
-			err = compileScript(context.Background(), srcPth, dstPth, opts.GlobalNames(), !isEntrypointFile(rel))
+		b, err := fs.ReadFile(fsys, rel)
+		if err != nil {
+			return err
+		}
+		if isScript {
+			source := string(b)
+			prog, err := parseSource(ctx, rel, source)
+			if err != nil {
+				return err
+			}
+			module := ""
+			if wrap {
+				module = dst
+			}
+			imports.add(rel, module, prog, opts.warn)
+
+			b, err = compileScript(prog, source, rel, opts.GlobalNames(), wrap)
+			if err != nil {
+				return err
+			}
 		} else {
-			err = copyFile(srcPth, dstPth)
+			imports.addFile(dst)
 		}
+
+		err = pw.addDir(path.Dir(dst))
 		if err != nil {
 			return err
 		}
 
+		h := &zip.FileHeader{
+			Name:     dst,
+			Method:   zip.Deflate,
+			Modified: info.ModTime(),
+		}
+		h.SetMode(info.Mode())
+		err = pw.create(h, b)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		if isScript {
+			modules = append(modules, dst)
+		}
 		return nil
 	})
 	if err != nil {
-		_ = os.RemoveAll(tmpDir)
+		return nil, err
 	}
-
-	return tmpDir, err
+	return modules, nil
 }
 
-// createTempZip zips the contents of src into a new temporary archive created
-// in dir and returns the archive's path.
-func createTempZip(src, dir string) (string, error) {
-	f, err := os.CreateTemp(dir, "ren*."+fileExt)
+// writeManifest writes the normalized manifest to pw as ManifestFile.
+func writeManifest(pw *packageWriter, manifest *Manifest) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer func() {
-		_ = f.Close()
-	}()
-
-	zw := zip.NewWriter(f)
-	defer func() {
-		_ = zw.Close()
-	}()
 
-	err = zw.AddFS(os.DirFS(src))
-	if err != nil {
-		return "", err
+	h := &zip.FileHeader{
+		Name:   ManifestFile,
+		Method: zip.Deflate,
 	}
+	h.SetMode(0644)
+	return pw.create(h, append(b, '\n'))
+}
 
-	return f.Name(), nil
+// parentDirs returns dir and each of its parent directories, outermost first.
+// It returns nil for the root, ".".
+func parentDirs(dir string) []string {
+	if dir == "." {
+		return nil
+	}
+	return append(parentDirs(path.Dir(dir)), dir)
 }
 
-// isEntrypoint reports whether dir contains an entrypoint script, returning
-// ErrMissingEntrypoint if not.
-func isEntrypoint(dir string) error {
-	for _, ext := range exts {
-		info, err := os.Stat(filepath.Join(dir, "entrypoint"+ext))
+// isEntrypoint reports whether fsys contains an entrypoint script, returning
+// ErrMissingEntrypoint if not. If name is set, it is the package-relative path
+// of the script to use instead of the default entrypoint.
+func isEntrypoint(fsys fs.FS, name string) error {
+	names := make([]string, 0, len(exts))
+	if name != "" {
+		names = append(names, name)
+	} else {
+		for _, ext := range exts {
+			names = append(names, "entrypoint"+ext)
+		}
+	}
+	for _, name := range names {
+		if !fs.ValidPath(name) {
+			continue
+		}
+		info, err := fs.Stat(fsys, name)
 		if err == nil && info.Mode().IsRegular() {
 			return nil
 		}
@@ -175,6 +334,18 @@ func isEntrypoint(dir string) error {
 	return ErrMissingEntrypoint
 }
 
+// IsTestFile reports whether filename names a test script, i.e. a Risor script
+// whose name ends in "_test", such as "lib/math_test.risor". Test scripts are
+// left out of a package unless WithTests is given.
+func IsTestFile(filename string) bool {
+	for _, ext := range exts {
+		if strings.HasSuffix(filename, "_test"+ext) {
+			return true
+		}
+	}
+	return false
+}
+
 // isRisorScript reports whether filename has a recognized Risor extension.
 func isRisorScript(filename string) bool {
 	for _, ext := range exts {
@@ -185,25 +356,16 @@ func isRisorScript(filename string) bool {
 	return false
 }
 
-// compileScript parses and compiles the Risor script at src, writing the
-// resulting bytecode to dst with a .json extension. When wrap is true the
-// script is treated as an importable module and wrapped so its top-level names
-// become exports (see wrapModule).
-func compileScript(ctx context.Context, src, dst string, globalNames []string, wrap bool) error {
-	b, err := os.ReadFile(src)
-	if err != nil {
-		return err
-	}
-
-	source := string(b)
-	prog, err := parseSource(ctx, src, source)
-	if err != nil {
-		return err
-	}
-
-	code, err := compileProgram(src, source, prog, globalNames)
+// compileScript compiles prog, parsed from the Risor source of the script at
+// name, its path within the package, and returns the resulting bytecode. Compiling
+// under the package path makes error locations and stack traces refer to the
+// package rather than to the build host. When wrap is true the script is
+// treated as an importable module and wrapped so its top-level names become
+// exports (see wrapModule).
+func compileScript(prog *ast.Program, source, name string, globalNames []string, wrap bool) ([]byte, error) {
+	code, err := compileProgram(name, source, prog, globalNames)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// A module is compiled as an immediately-invoked function returning a map of
@@ -216,22 +378,38 @@ func compileScript(ctx context.Context, src, dst string, globalNames []string, w
 	if wrap {
 		names, err := topLevelNames(code, globalNames)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		prog = wrapModule(prog, names)
-		code, err = compileProgram(src, source, prog, globalNames)
+		code, err = compileProgram(name, source, prog, globalNames)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	out, err := code.MarshalJSON()
-	if err != nil {
-		return err
+	// The bytecode encoding, unlike the compiler's, records the filename, which
+	// the runtime needs to attribute stack frames to their scripts.
+	return bytecode.Marshal(code.ToBytecode())
+}
+
+// CompileModule compiles the Risor source of a module the way Build compiles
+// the modules of a package, and returns the compiled module in the same
+// encoding. name identifies the module in error locations and stack traces.
+// The builtins added with WithBuiltin are treated as pre-declared globals. It
+// lets a host compile modules that are not part of a package, e.g. for a
+// ren.ModuleResolver.
+func CompileModule(ctx context.Context, name, source string, opt ...Option) ([]byte, error) {
+	var opts options
+	for _, o := range opt {
+		o(&opts)
 	}
 
-	return os.WriteFile(replaceScriptExt(dst), out, 0644)
+	prog, err := parseSource(ctx, name, source)
+	if err != nil {
+		return nil, err
+	}
+	return compileScript(prog, source, name, opts.GlobalNames(), true)
 }
 
 // parseSource parses Risor source into an AST program.
@@ -307,37 +485,22 @@ func isEntrypointFile(rel string) bool {
 	return false
 }
 
-// copyFile copies the contents of src to dst.
-func copyFile(src, dst string) error {
-	fileSrc, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = fileSrc.Close()
-	}()
-
-	fileDst, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = fileDst.Close()
-	}()
-
-	_, err = io.Copy(fileDst, fileSrc)
-	return err
-}
-
 // replaceScriptExt replaces filename's extension with .json, the extension used
 // for compiled scripts inside a package.
 func replaceScriptExt(filename string) string {
 	name := strings.TrimSuffix(filename, filepath.Ext(filename))
-	return name + ".json"
+	return name + moduleExt
 }
 
 type options struct {
-	builtins []*object.Builtin
+	builtins      []*object.Builtin
+	modules       []*object.Module
+	schemes       []string
+	warn          func(Warning)
+	tests         bool
+	entrypoint    string
+	signingKey    ed25519.PrivateKey
+	encryptionKey []byte
 }
 
 // GlobalNames returns the names of the configured builtins, which are treated
@@ -364,3 +527,77 @@ func WithBuiltin(builtin *object.Builtin) Option {
 		options.builtins = append(options.builtins, builtin)
 	}
 }
+
+// WithModule declares a built-in module that the runtime will provide, so
+// that imports of it ("builtin://name") are checked when building. Once any
+// module is declared, importing one that was not fails the build with an
+// ImportError; without any, built-in imports are not checked. A nil module is
+// ignored.
+func WithModule(module *object.Module) Option {
+	return func(options *options) {
+		if module == nil {
+			return
+		}
+		options.modules = append(options.modules, module)
+	}
+}
+
+// WithScheme declares a URL scheme whose imports the runtime resolves with a
+// resolver of its own (see ren.WithResolver). Imports of the scheme cannot be
+// checked when building and are accepted; imports of any other scheme the
+// runtime does not support fail the build with an ImportError.
+func WithScheme(scheme string) Option {
+	return func(options *options) {
+		options.schemes = append(options.schemes, scheme)
+	}
+}
+
+// WithWarnings calls fn with each warning found while building, such as an
+// import whose name is not a string literal and so cannot be checked, or a
+// test script left out of the package.
+// Warnings are discarded by default.
+func WithWarnings(fn func(Warning)) Option {
+	return func(options *options) {
+		options.warn = fn
+	}
+}
+
+// WithTests includes test scripts (see IsTestFile) in the package. They are
+// compiled as modules, like any other script. Without it, each test script
+// left out is reported as a Warning.
+func WithTests() Option {
+	return func(options *options) {
+		options.tests = true
+	}
+}
+
+// WithEntrypoint compiles the script at name, a slash-separated path relative
+// to the source directory, as the package's entrypoint in place of
+// entrypoint.risor, which is then left out of the package. Build returns
+// ErrMissingEntrypoint if the script does not exist.
+func WithEntrypoint(name string) Option {
+	return func(options *options) {
+		options.entrypoint = path.Clean(name)
+	}
+}
+
+// WithSigningKey signs the package with key. The signature covers the name and
+// content of every entry in the package, so that the runtime can reject a
+// package that was modified after it was built (see Verify).
+func WithSigningKey(key ed25519.PrivateKey) Option {
+	return func(options *options) {
+		options.signingKey = key
+	}
+}
+
+// WithEncryptionKey encrypts the package with key, which must be KeySize bytes
+// long. Every entry but the manifest is encrypted with AES-256-GCM under a
+// random content key, which is stored in the package wrapped with key; the
+// names of the entries stay readable. The runtime needs the same key to run the
+// package. Combined with WithSigningKey, the signature covers the encrypted
+// entries. Inspect decrypts the package it inspects with key.
+func WithEncryptionKey(key []byte) Option {
+	return func(options *options) {
+		options.encryptionKey = key
+	}
+}
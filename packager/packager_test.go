@@ -2,10 +2,19 @@ package packager_test
 
 import (
 	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/deepnoodle-ai/risor/v2/pkg/object"
 	"github.com/stretchr/testify/require"
@@ -38,6 +47,7 @@ func TestBuild(t *testing.T) {
 			wantFiles: []string{
 				"entrypoint.json",
 				"main.json",
+				"ren.index",
 			},
 		},
 	}
@@ -75,3 +85,970 @@ func TestBuild(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildTests(t *testing.T) {
+	src := t.TempDir()
+	for name, content := range map[string]string{
+		"entrypoint.risor":   "const x = 1\n",
+		"lib/a.risor":        "const y = 2\n",
+		"lib/a_test.risor":   "function test_y() {}\n",
+		"lib/data_test.txt":  "not a script\n",
+		"lib/other_test.rsr": "function test_z() {}\n",
+	} {
+		pth := filepath.Join(src, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755))
+		require.NoError(t, os.WriteFile(pth, []byte(content), 0644))
+	}
+
+	tests := []struct {
+		name         string
+		opts         []packager.Option
+		wantFiles    []string
+		wantWarnings []string
+		wantErr      error
+	}{
+		{
+			name:      "default",
+			wantFiles: []string{"entrypoint.json", "lib/", "lib/a.json", "lib/data_test.txt", "ren.index"},
+			wantWarnings: []string{
+				"lib/a_test.risor: test script left out of the package",
+				"lib/other_test.rsr: test script left out of the package",
+			},
+		},
+		{
+			name:      "with tests",
+			opts:      []packager.Option{packager.WithTests()},
+			wantFiles: []string{"entrypoint.json", "lib/", "lib/a.json", "lib/a_test.json", "lib/data_test.txt", "lib/other_test.json", "ren.index"},
+		},
+		{
+			name:         "with entrypoint",
+			opts:         []packager.Option{packager.WithEntrypoint("lib/a_test.risor")},
+			wantFiles:    []string{"entrypoint.json", "lib/", "lib/a.json", "lib/data_test.txt", "ren.index"},
+			wantWarnings: []string{"lib/other_test.rsr: test script left out of the package"},
+		},
+		{
+			name:    "missing entrypoint",
+			opts:    []packager.Option{packager.WithEntrypoint("lib/b_test.risor")},
+			wantErr: packager.ErrMissingEntrypoint,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var warnings []string
+			opts := append(tt.opts, packager.WithWarnings(func(w packager.Warning) {
+				warnings = append(warnings, w.String())
+			}))
+			out := filepath.Join(t.TempDir(), "out.zip")
+			err := packager.Build(src, out, opts...)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			zr, err := zip.OpenReader(out)
+			require.NoError(t, err)
+			defer func() {
+				_ = zr.Close()
+			}()
+
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			require.ElementsMatch(t, tt.wantFiles, names)
+			require.Equal(t, tt.wantWarnings, warnings)
+		})
+	}
+}
+
+func TestBuildFS(t *testing.T) {
+	tests := []struct {
+		name      string
+		fsys      fstest.MapFS
+		wantFiles []string
+		wantErr   error
+		// wantErrText is the message of an error with no sentinel.
+		wantErrText string
+	}{
+		{
+			name: "scripts and data",
+			fsys: fstest.MapFS{
+				"entrypoint.risor": {Data: []byte(`let x = 1`)},
+				"lib/util.risor":   {Data: []byte(`function greet() { return "hello" }`)},
+				"data/names.txt":   {Data: []byte("alice\nbob\n")},
+			},
+			wantFiles: []string{"entrypoint.json", "lib/", "lib/util.json", "data/", "data/names.txt", "ren.index"},
+		},
+		{
+			name: "missing entrypoint",
+			fsys: fstest.MapFS{
+				"lib/util.risor": {Data: []byte(`let x = 1`)},
+			},
+			wantErr: packager.ErrMissingEntrypoint,
+		},
+		{
+			name: "script compiled to a reserved name",
+			fsys: fstest.MapFS{
+				"entrypoint.risor": {Data: []byte(`let x = 1`)},
+				"signature.risor":  {Data: []byte(`let y = 2`)},
+			},
+			wantErrText: "signature.risor: compiles to signature.json, a reserved file name",
+		},
+		{
+			name: "reserved data file",
+			fsys: fstest.MapFS{
+				"entrypoint.risor": {Data: []byte(`let x = 1`)},
+				"ren.index":        {Data: []byte(`{}`)},
+			},
+			wantErrText: "ren.index: reserved file name",
+		},
+		{
+			name: "scripts compiled to the same module",
+			fsys: fstest.MapFS{
+				"entrypoint.risor": {Data: []byte(`let x = 1`)},
+				"lib/util.risor":   {Data: []byte(`let y = 2`)},
+				"lib/util.rsr":     {Data: []byte(`let y = 3`)},
+			},
+			wantErrText: "lib/util.rsr: duplicate entry lib/util.json",
+		},
+		{
+			name: "script compiled to a data file",
+			fsys: fstest.MapFS{
+				"entrypoint.risor": {Data: []byte(`let x = 1`)},
+				"lib/util.json":    {Data: []byte(`{}`)},
+				"lib/util.risor":   {Data: []byte(`let y = 2`)},
+			},
+			wantErrText: "lib/util.risor: duplicate entry lib/util.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := packager.BuildFS(context.Background(), tt.fsys, &buf)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			if tt.wantErrText != "" {
+				require.EqualError(t, err, tt.wantErrText)
+				return
+			}
+			require.NoError(t, err)
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			require.NoError(t, err)
+
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			require.ElementsMatch(t, tt.wantFiles, names)
+		})
+	}
+}
+
+func TestBuildFSCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fsys := fstest.MapFS{
+		"entrypoint.risor": {Data: []byte(`let x = 1`)},
+	}
+	err := packager.BuildFS(ctx, fsys, io.Discard)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestBuildImports(t *testing.T) {
+	noop := func(ctx context.Context, args ...object.Object) (object.Object, error) {
+		return object.Nil, nil
+	}
+	importBuiltin := packager.WithBuiltin(object.NewBuiltin("import", noop))
+	osModule := packager.WithModule(object.NewBuiltinsModule("os", nil))
+
+	tests := []struct {
+		name         string
+		files        map[string]string
+		opts         []packager.Option
+		wantErrs     []string
+		wantWarnings []string
+	}{
+		{
+			name: "resolved",
+			files: map[string]string{
+				"entrypoint.risor": "const a = import(\"lib/a\")\nconst os = import(\"builtin://os\")\n",
+				"lib/a.risor":      "const b = import(\"package://lib/b\")\n",
+				"lib/b.risor":      "let x = 1\n",
+			},
+			opts: []packager.Option{osModule},
+		},
+		{
+			name: "missing module",
+			files: map[string]string{
+				"entrypoint.risor": "let x = 1\nconst a = import(\"lib/missing\")\n",
+			},
+			wantErrs: []string{`entrypoint.risor:2:11: cannot import "lib/missing": no such module in the package`},
+		},
+		{
+			name: "entrypoint is not a module",
+			files: map[string]string{
+				"entrypoint.risor": "let x = 1\n",
+				"lib/a.risor":      "const e = import(\"entrypoint\")\n",
+			},
+			wantErrs: []string{`lib/a.risor:1:11: cannot import "entrypoint": no such module in the package`},
+		},
+		{
+			name: "relative",
+			files: map[string]string{
+				"entrypoint.risor":  "const a = import(\"./lib/a\")\n",
+				"lib/a.risor":       "const b = import(\"./sub/b\")\n",
+				"lib/sub/b.risor":   "const c = import(\"../../shared/c\")\n",
+				"shared/c.risor":    "let x = 1\n",
+				"lib/sub/d.risor":   "const c = import(\"package://../../shared/c\")\n",
+				"lib/sub/e.risor":   "const d = import(\"./d.json\")\n",
+				"lib/sub/f_a.risor": "function f() { return import(\"./b\") }\n",
+			},
+		},
+		{
+			name: "relative missing",
+			files: map[string]string{
+				"entrypoint.risor": "let x = 1\n",
+				"lib/a.risor":      "const b = import(\"./b\")\n",
+				"b.risor":          "let x = 1\n",
+			},
+			wantErrs: []string{`lib/a.risor:1:11: cannot import "./b": no such module in the package`},
+		},
+		{
+			name: "relative cycle",
+			files: map[string]string{
+				"entrypoint.risor": "const a = import(\"lib/a\")\n",
+				"lib/a.risor":      "const b = import(\"./sub/b\")\n",
+				"lib/sub/b.risor":  "const a = import(\"../a\")\n",
+			},
+			wantErrs: []string{`lib/sub/b.risor:1:11: cannot import "../a": import cycle: lib/a.risor:1:11 imports "./sub/b", lib/sub/b.risor:1:11 imports "../a"`},
+		},
+		{
+			name: "escapes root",
+			files: map[string]string{
+				"entrypoint.risor": "let x = 1\n",
+				"lib/a.risor":      "const a = import(\"../../a\")\n",
+			},
+			wantErrs: []string{`lib/a.risor:1:11: cannot import "../../a": import path escapes the package root`},
+		},
+		{
+			name: "invalid path",
+			files: map[string]string{
+				"entrypoint.risor": "const a = import(\"\")\n",
+			},
+			wantErrs: []string{`entrypoint.risor:1:11: cannot import "": invalid import path`},
+		},
+		{
+			name: "missing builtin",
+			files: map[string]string{
+				"entrypoint.risor": "const os = import(\"builtin://htpp\")\n",
+			},
+			opts:     []packager.Option{osModule},
+			wantErrs: []string{`entrypoint.risor:1:12: cannot import "builtin://htpp": no such built-in module`},
+		},
+		{
+			name: "builtins unchecked without modules",
+			files: map[string]string{
+				"entrypoint.risor": "const os = import(\"builtin://htpp\")\n",
+			},
+		},
+		{
+			name: "unsupported scheme",
+			files: map[string]string{
+				"entrypoint.risor": "const a = import(\"https://example.com/a\")\n",
+			},
+			wantErrs: []string{`entrypoint.risor:1:11: cannot import "https://example.com/a": unsupported scheme "https"`},
+		},
+		{
+			name: "data files",
+			files: map[string]string{
+				"entrypoint.risor":     "const settings = import(\"config/settings.json\")\nconst a = import(\"lib/a\")\n",
+				"config/settings.json": "{\"debug\": true}\n",
+				"lib/a.risor":          "const words = import(\"./words.txt\")\nconst missing = import(\"./missing.yaml\")\n",
+				"lib/words.txt":        "a\nb\n",
+			},
+			wantErrs: []string{`lib/a.risor:2:17: cannot import "./missing.yaml": no such module in the package`},
+		},
+		{
+			name: "resolver scheme",
+			files: map[string]string{
+				"entrypoint.risor": "const a = import(\"db://lib/a\")\nconst b = import(\"https://example.com/b\")\n",
+			},
+			opts:     []packager.Option{packager.WithScheme("db")},
+			wantErrs: []string{`entrypoint.risor:2:11: cannot import "https://example.com/b": unsupported scheme "https"`},
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"entrypoint.risor": "const a = import(\"lib/a\")\n",
+				"lib/a.risor":      "const b = import(\"lib/b\")\n",
+				"lib/b.risor":      "let x = 1\nconst a = import(\"lib/a\")\n",
+			},
+			wantErrs: []string{`lib/b.risor:2:11: cannot import "lib/a": import cycle: lib/a.risor:1:11 imports "lib/b", lib/b.risor:2:11 imports "lib/a"`},
+		},
+		{
+			name: "self import",
+			files: map[string]string{
+				"entrypoint.risor": "let x = 1\n",
+				"lib/a.risor":      "const a = import(\"lib/a\")\n",
+			},
+			wantErrs: []string{`lib/a.risor:1:11: cannot import "lib/a": import cycle: lib/a.risor:1:11 imports "lib/a"`},
+		},
+		{
+			name: "cycle through a function",
+			files: map[string]string{
+				"entrypoint.risor": "const a = import(\"lib/a\")\n",
+				"lib/a.risor":      "const b = import(\"lib/b\")\n",
+				"lib/b.risor":      "function a() { return import(\"lib/a\") }\nconst c = () => import(\"lib/a\")\n",
+			},
+		},
+		{
+			name: "all errors",
+			files: map[string]string{
+				"entrypoint.risor": "const a = import(\"lib/x\")\nconst b = import(\"lib/y\")\n",
+			},
+			wantErrs: []string{
+				`entrypoint.risor:1:11: cannot import "lib/x": no such module in the package`,
+				`entrypoint.risor:2:11: cannot import "lib/y": no such module in the package`,
+			},
+		},
+		{
+			name: "non-literal",
+			files: map[string]string{
+				"entrypoint.risor": "let name = \"lib/a\"\nconst a = import(name)\nconst b = import(`${name}`)\n",
+			},
+			wantWarnings: []string{
+				"entrypoint.risor:2:11: import of a non-literal name cannot be checked",
+				"entrypoint.risor:3:11: import of a non-literal name cannot be checked",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for name, content := range tt.files {
+				fsys[name] = &fstest.MapFile{Data: []byte(content)}
+			}
+
+			var warnings []string
+			opts := append([]packager.Option{
+				importBuiltin,
+				packager.WithWarnings(func(w packager.Warning) {
+					warnings = append(warnings, w.String())
+				}),
+			}, tt.opts...)
+			err := packager.BuildFS(context.Background(), fsys, io.Discard, opts...)
+			require.Equal(t, tt.wantWarnings, warnings)
+			if tt.wantErrs == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, packager.ErrUnresolvedImport)
+			var importErr *packager.ImportError
+			require.ErrorAs(t, err, &importErr)
+			require.Equal(t, strings.Join(tt.wantErrs, "\n"), err.Error())
+		})
+	}
+}
+
+func TestBuildManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    *packager.Manifest
+		wantErr error
+		// wantErrText is the message of an error with no sentinel.
+		wantErrText string
+	}{
+		{
+			name:  "none",
+			files: map[string]string{},
+		},
+		{
+			name: "toml",
+			files: map[string]string{
+				"ren.toml": `
+name = " hello "
+version = "v1.2.0"
+authors = ["Jane Doe", ""]
+
+[requires]
+ren = "0.2.0"
+modules = ["os", "fs", "os"]
+builtins = ["print"]
+`,
+			},
+			want: &packager.Manifest{
+				Name:    "hello",
+				Version: "1.2.0",
+				Authors: []string{"Jane Doe"},
+				Requires: packager.Requirements{
+					Ren:      "0.2.0",
+					Modules:  []string{"fs", "os"},
+					Builtins: []string{"print"},
+				},
+			},
+		},
+		{
+			name: "manifest.json data file",
+			files: map[string]string{
+				"ren.toml":      `name = "hello"`,
+				"manifest.json": `{"name": 1, "items": []}`,
+			},
+			want: &packager.Manifest{Name: "hello"},
+		},
+		{
+			name: "unknown field",
+			files: map[string]string{
+				"ren.toml": `nmae = "hello"`,
+			},
+			wantErr: packager.ErrInvalidManifest,
+		},
+		{
+			name: "invalid version",
+			files: map[string]string{
+				"ren.toml": "[requires]\nren = \"latest\"\n",
+			},
+			wantErr: packager.ErrInvalidManifest,
+		},
+		{
+			name: "script named like the manifest",
+			files: map[string]string{
+				"ren.toml":  `name = "hello"`,
+				"ren.risor": `let x = 1`,
+			},
+			wantErrText: "ren.risor: compiles to ren.json, the name of the package manifest",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{
+				"entrypoint.risor": {Data: []byte(`let x = 1`)},
+			}
+			for name, content := range tt.files {
+				fsys[name] = &fstest.MapFile{Data: []byte(content)}
+			}
+
+			var buf bytes.Buffer
+			err := packager.BuildFS(context.Background(), fsys, &buf)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			if tt.wantErrText != "" {
+				require.EqualError(t, err, tt.wantErrText)
+				return
+			}
+			require.NoError(t, err)
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			require.NoError(t, err)
+			b, err := fs.ReadFile(zr, packager.ManifestFile)
+			if tt.want == nil {
+				require.ErrorIs(t, err, fs.ErrNotExist)
+				return
+			}
+			require.NoError(t, err)
+			_, err = fs.Stat(zr, "ren.toml")
+			require.ErrorIs(t, err, fs.ErrNotExist)
+
+			got, err := packager.ParseManifest(b)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+
+			if data, ok := tt.files["manifest.json"]; ok {
+				b, err := fs.ReadFile(zr, "manifest.json")
+				require.NoError(t, err)
+				require.Equal(t, data, string(b))
+			}
+		})
+	}
+}
+
+func TestBuildDependencies(t *testing.T) {
+	noop := func(ctx context.Context, args ...object.Object) (object.Object, error) {
+		return object.Nil, nil
+	}
+	importBuiltin := packager.WithBuiltin(object.NewBuiltin("import", noop))
+
+	var lib bytes.Buffer
+	require.NoError(t, packager.BuildFS(context.Background(), fstest.MapFS{
+		"ren.toml":         {Data: []byte(`version = "1.2.3"`)},
+		"entrypoint.risor": {Data: []byte("let x = 1\n")},
+		"json.risor":       {Data: []byte("function encode(v) { return v }\n")},
+		"data/schema.txt":  {Data: []byte("schema\n")},
+	}, &lib))
+	sum := sha256.Sum256(lib.Bytes())
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name      string
+		manifest  string
+		files     map[string]string
+		wantFiles []string
+		wantLock  *packager.Lock
+		wantErr   error
+		// wantErrText is the message of an error with no sentinel.
+		wantErrText string
+	}{
+		{
+			name: "vendored",
+			manifest: `[dependencies]
+logging = { version = "^1.2.0", path = "deps/logging.zip" }
+metrics = { version = ">= 0.3, <0.5" }
+`,
+			files: map[string]string{
+				"entrypoint.risor": "const json = import(\"pkg://logging/json\")\nconst m = import(\"pkg://metrics/counter\")\n",
+			},
+			wantFiles: []string{
+				"entrypoint.json", "ren.json", "ren.lock", "ren.index",
+				"pkg/", "pkg/logging/", "pkg/logging/ren.json", "pkg/logging/ren.index", "pkg/logging/json.json",
+				"pkg/logging/data/", "pkg/logging/data/schema.txt",
+			},
+			wantLock: &packager.Lock{Dependencies: map[string]packager.LockedDependency{
+				"logging": {Constraint: "^1.2.0", Vendored: true, Version: "1.2.3", Digest: digest},
+				"metrics": {Constraint: ">=0.3.0, <0.5.0"},
+			}},
+		},
+		{
+			name:     "unsatisfied version",
+			manifest: "[dependencies]\nlogging = { version = \"^2.0.0\", path = \"deps/logging.zip\" }\n",
+			files: map[string]string{
+				"entrypoint.risor": "let x = 1\n",
+			},
+			wantErr: packager.ErrInvalidDependency,
+		},
+		{
+			name:     "pinned digest",
+			manifest: "[dependencies]\nlogging = { path = \"deps/logging.zip\" }\n",
+			files: map[string]string{
+				"entrypoint.risor": "let x = 1\n",
+				"ren.lock":         `{"dependencies": {"logging": {"vendored": true, "digest": "sha256:00"}}}`,
+			},
+			wantErr: packager.ErrInvalidDependency,
+		},
+		{
+			name:     "conflict",
+			manifest: "[dependencies]\nlogging = { path = \"deps/logging.zip\" }\n",
+			files: map[string]string{
+				"entrypoint.risor":      "let x = 1\n",
+				"pkg/logging/extra.txt": "extra\n",
+			},
+			wantErrText: "pkg/logging/extra.txt: conflicts with a vendored dependency",
+		},
+		{
+			name:     "missing module",
+			manifest: "[dependencies]\nlogging = { path = \"deps/logging.zip\" }\n",
+			files: map[string]string{
+				"entrypoint.risor": "const x = import(\"pkg://logging/yaml\")\n",
+			},
+			wantErr: packager.ErrUnresolvedImport,
+		},
+		{
+			name: "undeclared",
+			files: map[string]string{
+				"entrypoint.risor": "const x = import(\"pkg://logging/json\")\n",
+			},
+			wantErr: packager.ErrUnresolvedImport,
+		},
+		{
+			name:     "invalid name",
+			manifest: "[dependencies]\n\"a/b\" = { version = \"1.0.0\" }\n",
+			files: map[string]string{
+				"entrypoint.risor": "let x = 1\n",
+			},
+			wantErr: packager.ErrInvalidManifest,
+		},
+		{
+			name:     "invalid constraint",
+			manifest: "[dependencies]\nlogging = { version = \"^latest\" }\n",
+			files: map[string]string{
+				"entrypoint.risor": "let x = 1\n",
+			},
+			wantErr: packager.ErrInvalidManifest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{
+				"deps/logging.zip": {Data: lib.Bytes()},
+			}
+			if tt.manifest != "" {
+				fsys["ren.toml"] = &fstest.MapFile{Data: []byte(tt.manifest)}
+			}
+			for name, content := range tt.files {
+				fsys[name] = &fstest.MapFile{Data: []byte(content)}
+			}
+
+			var buf bytes.Buffer
+			err := packager.BuildFS(context.Background(), fsys, &buf, importBuiltin)
+			if tt.wantErrText != "" {
+				require.EqualError(t, err, tt.wantErrText)
+				return
+			}
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			require.NoError(t, err)
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			require.ElementsMatch(t, tt.wantFiles, names)
+
+			lock, err := packager.ReadLock(zr)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantLock, lock)
+		})
+	}
+}
+
+func TestSatisfiesConstraint(t *testing.T) {
+	tests := []struct {
+		version    string
+		constraint string
+		want       bool
+	}{
+		{"1.2.3", "", true},
+		{"", "", true},
+		{"", "1.0.0", false},
+		{"1.2.3", "1.2.3", true},
+		{"1.2.3", "=1.2", false},
+		{"1.2.0", "=1.2", true},
+		{"v1.2.3", ">=1.2.0, <2.0.0", true},
+		{"2.0.0", ">=1.2.0, <2.0.0", false},
+		{"1.5.0", "^1.2.0", true},
+		{"1.1.0", "^1.2.0", false},
+		{"2.0.0", "^1.2.0", false},
+		{"0.2.5", "^0.2.1", true},
+		{"0.3.0", "^0.2.1", false},
+		{"1.2.9", "~1.2.3", true},
+		{"1.3.0", "~1.2.3", false},
+		{"1.0.0", "> 1.0.0", false},
+		{"1.0.0", "<= 1.0.0", true},
+		{"latest", ">=1.0.0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version+" "+tt.constraint, func(t *testing.T) {
+			got, err := packager.SatisfiesConstraint(tt.version, tt.constraint)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := packager.SatisfiesConstraint("1.0.0", ">=one")
+	require.Error(t, err)
+}
+
+func TestSign(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherPub, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	fsys := fstest.MapFS{
+		"entrypoint.risor": {Data: []byte(`let x = 1`)},
+		"lib/util.risor":   {Data: []byte(`function f() { return 1 }`)},
+		"data/a.txt":       {Data: []byte("a")},
+	}
+
+	build := func(t *testing.T, opts ...packager.Option) *zip.Reader {
+		t.Helper()
+		var buf bytes.Buffer
+		require.NoError(t, packager.BuildFS(context.Background(), fsys, &buf, opts...))
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err)
+		return zr
+	}
+
+	// rewrite copies the package, replacing the content of the entries in
+	// replace and appending the entries in add.
+	rewrite := func(t *testing.T, zr *zip.Reader, replace, add map[string]string) *zip.Reader {
+		t.Helper()
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, f := range zr.File {
+			content, ok := replace[f.Name]
+			if !ok {
+				require.NoError(t, zw.Copy(f))
+				continue
+			}
+			w, err := zw.Create(f.Name)
+			require.NoError(t, err)
+			_, err = io.WriteString(w, content)
+			require.NoError(t, err)
+		}
+		for name, content := range add {
+			w, err := zw.Create(name)
+			require.NoError(t, err)
+			_, err = io.WriteString(w, content)
+			require.NoError(t, err)
+		}
+		require.NoError(t, zw.Close())
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err)
+		return zr
+	}
+
+	t.Run("signed", func(t *testing.T) {
+		zr := build(t, packager.WithSigningKey(priv))
+		key, err := packager.Signer(zr)
+		require.NoError(t, err)
+		require.Equal(t, pub, key)
+		require.NoError(t, packager.Verify(zr, otherPub, pub))
+		require.ErrorIs(t, packager.Verify(zr, otherPub), packager.ErrUntrustedKey)
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		zr := build(t)
+		_, err := packager.Signer(zr)
+		require.ErrorIs(t, err, packager.ErrUnsigned)
+	})
+
+	t.Run("modified entry", func(t *testing.T) {
+		zr := rewrite(t, build(t, packager.WithSigningKey(priv)), map[string]string{"data/a.txt": "b"}, nil)
+		require.ErrorIs(t, packager.Verify(zr, pub), packager.ErrInvalidSignature)
+	})
+
+	t.Run("added entry", func(t *testing.T) {
+		zr := rewrite(t, build(t, packager.WithSigningKey(priv)), nil, map[string]string{"data/b.txt": "b"})
+		require.ErrorIs(t, packager.Verify(zr, pub), packager.ErrInvalidSignature)
+	})
+
+	t.Run("duplicate entry", func(t *testing.T) {
+		zr := rewrite(t, build(t, packager.WithSigningKey(priv)), nil, map[string]string{"data/a.txt": "b"})
+		require.ErrorIs(t, packager.Verify(zr, pub), packager.ErrInvalidSignature)
+	})
+
+	t.Run("sign existing package", func(t *testing.T) {
+		var src bytes.Buffer
+		require.NoError(t, packager.BuildFS(context.Background(), fsys, &src, packager.WithSigningKey(priv)))
+
+		var dst bytes.Buffer
+		require.NoError(t, packager.Sign(bytes.NewReader(src.Bytes()), int64(src.Len()), &dst, otherPriv))
+		zr, err := zip.NewReader(bytes.NewReader(dst.Bytes()), int64(dst.Len()))
+		require.NoError(t, err)
+
+		key, err := packager.Signer(zr)
+		require.NoError(t, err)
+		require.Equal(t, otherPub, key)
+		require.Len(t, zr.File, len(build(t).File)+1)
+	})
+}
+
+func TestEncrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, packager.KeySize)
+	otherKey := bytes.Repeat([]byte{2}, packager.KeySize)
+
+	fsys := fstest.MapFS{
+		"entrypoint.risor": {Data: []byte(`let x = 1`)},
+		"ren.toml":         {Data: []byte(`name = "secret"`)},
+		"data/a.txt":       {Data: []byte("secret data")},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, packager.BuildFS(context.Background(), fsys, &buf, packager.WithEncryptionKey(key)))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	keyID, err := packager.EncryptionKeyID(zr)
+	require.NoError(t, err)
+	require.Equal(t, packager.KeyID(key), keyID)
+
+	raw, err := fs.ReadFile(zr, "data/a.txt")
+	require.NoError(t, err)
+	require.NotContains(t, string(raw), "secret data")
+	manifest, err := fs.ReadFile(zr, packager.ManifestFile)
+	require.NoError(t, err)
+	require.Contains(t, string(manifest), `"secret"`)
+
+	t.Run("right key", func(t *testing.T) {
+		files, err := packager.Decrypt(zr, key)
+		require.NoError(t, err)
+
+		b, err := fs.ReadFile(files, "data/a.txt")
+		require.NoError(t, err)
+		require.Equal(t, "secret data", string(b))
+
+		info, err := fs.Stat(files, "data/a.txt")
+		require.NoError(t, err)
+		require.EqualValues(t, len("secret data"), info.Size())
+
+		entries, err := fs.ReadDir(files, "data")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		info, err = entries[0].Info()
+		require.NoError(t, err)
+		require.EqualValues(t, len("secret data"), info.Size())
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		_, err := packager.Decrypt(zr, otherKey)
+		require.ErrorIs(t, err, packager.ErrWrongKey)
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		_, err := packager.Decrypt(zr, nil)
+		require.ErrorIs(t, err, packager.ErrMissingKey)
+	})
+
+	t.Run("unencrypted", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, packager.BuildFS(context.Background(), fsys, &buf))
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err)
+
+		files, err := packager.Decrypt(zr, nil)
+		require.NoError(t, err)
+		b, err := fs.ReadFile(files, "data/a.txt")
+		require.NoError(t, err)
+		require.Equal(t, "secret data", string(b))
+	})
+
+	t.Run("swapped entries", func(t *testing.T) {
+		// Store the ciphertext of data/a.txt under another name: entries are
+		// bound to their names, so it must not decrypt.
+		var swapped bytes.Buffer
+		zw := zip.NewWriter(&swapped)
+		for _, f := range zr.File {
+			require.NoError(t, zw.Copy(f))
+		}
+		w, err := zw.Create("data/b.txt")
+		require.NoError(t, err)
+		_, err = w.Write(raw)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+
+		zr, err := zip.NewReader(bytes.NewReader(swapped.Bytes()), int64(swapped.Len()))
+		require.NoError(t, err)
+		files, err := packager.Decrypt(zr, key)
+		require.NoError(t, err)
+		_, err = fs.ReadFile(files, "data/b.txt")
+		require.ErrorIs(t, err, packager.ErrCorrupted)
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		err := packager.BuildFS(context.Background(), fsys, io.Discard, packager.WithEncryptionKey([]byte("short")))
+		require.ErrorIs(t, err, packager.ErrInvalidKey)
+	})
+}
+
+func TestInspect(t *testing.T) {
+	noop := func(ctx context.Context, args ...object.Object) (object.Object, error) {
+		return object.Nil, nil
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key := make([]byte, packager.KeySize)
+	_, err = rand.Read(key)
+	require.NoError(t, err)
+
+	fsys := fstest.MapFS{
+		"ren.toml": {Data: []byte("name = \"demo\"\nversion = \"1.0.0\"\n")},
+		"entrypoint.risor": {Data: []byte("const util = import(\"lib/util\")\n" +
+			"let count = 0\nfunction main() { print(util.greet(\"ren\")) }\n")},
+		"lib/util.risor": {Data: []byte("const prefix = \"hello \"\n" +
+			"function greet(name) { return prefix + name }\n" +
+			"function later() { return import(\"builtin://os\") }\n")},
+		"data/words.txt": {Data: []byte("one\ntwo\n")},
+		// A data file that could pass for a compiled module.
+		"data/countries.json": {Data: []byte(`{"codes": ["US", "DE"]}`)},
+	}
+	build := func(t *testing.T, opts ...packager.Option) []byte {
+		t.Helper()
+		opts = append(opts,
+			packager.WithBuiltin(object.NewBuiltin("import", noop)),
+			packager.WithBuiltin(object.NewBuiltin("print", noop)),
+			packager.WithBuiltin(object.NewBuiltin("len", noop)),
+		)
+		var buf bytes.Buffer
+		require.NoError(t, packager.BuildFS(context.Background(), fsys, &buf, opts...))
+		return buf.Bytes()
+	}
+
+	t.Run("signed", func(t *testing.T) {
+		b := build(t, packager.WithSigningKey(priv))
+		info, err := packager.Inspect(bytes.NewReader(b), int64(len(b)))
+		require.NoError(t, err)
+
+		require.Equal(t, "demo", info.Manifest.Name)
+		require.Equal(t, packager.SignatureInfo{Signed: true, Valid: true, PublicKey: pub}, info.Signature)
+		require.Empty(t, info.EncryptionKeyID)
+		require.Equal(t, []packager.FileInfo{{Path: "data/countries.json", Size: 23}, {Path: "data/words.txt", Size: 8}}, info.Files)
+
+		require.Len(t, info.Modules, 2)
+		entry, util := info.Modules[0], info.Modules[1]
+		require.Equal(t, "entrypoint.json", entry.Path)
+		require.Equal(t, "entrypoint.risor", entry.Source)
+		require.Equal(t, []string{"count", "main", "util"}, entry.Exports)
+		require.Equal(t, []string{"lib/util"}, entry.Imports)
+		require.Equal(t, []string{"import", "print"}, entry.Globals)
+		require.Positive(t, entry.Size)
+		require.NotNil(t, entry.Code)
+
+		require.Equal(t, "lib/util.json", util.Path)
+		require.Equal(t, []string{"greet", "later", "prefix"}, util.Exports)
+		require.Equal(t, []string{"builtin://os"}, util.Imports)
+		require.Equal(t, []string{"import"}, util.Globals)
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		b := build(t)
+		info, err := packager.Inspect(bytes.NewReader(b), int64(len(b)))
+		require.NoError(t, err)
+		require.Equal(t, packager.SignatureInfo{}, info.Signature)
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		b := build(t, packager.WithEncryptionKey(key))
+		info, err := packager.Inspect(bytes.NewReader(b), int64(len(b)), packager.WithEncryptionKey(key))
+		require.NoError(t, err)
+		require.Equal(t, packager.KeyID(key), info.EncryptionKeyID)
+		require.Equal(t, []packager.FileInfo{{Path: "data/countries.json", Size: 23}, {Path: "data/words.txt", Size: 8}}, info.Files)
+		require.Len(t, info.Modules, 2)
+		require.Equal(t, []string{"greet", "later", "prefix"}, info.Modules[1].Exports)
+	})
+
+	t.Run("encrypted without key", func(t *testing.T) {
+		b := build(t, packager.WithEncryptionKey(key))
+		info, err := packager.Inspect(bytes.NewReader(b), int64(len(b)))
+		require.NoError(t, err)
+		require.Equal(t, "demo", info.Manifest.Name)
+		require.Empty(t, info.Modules)
+		require.Len(t, info.Files, 4)
+	})
+
+	t.Run("without index", func(t *testing.T) {
+		// Packages built by earlier versions have no module index.
+		b := build(t)
+		zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, f := range zr.File {
+			if f.Name != packager.IndexFile {
+				require.NoError(t, zw.Copy(f))
+			}
+		}
+		require.NoError(t, zw.Close())
+
+		info, err := packager.Inspect(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err)
+		require.Len(t, info.Modules, 2)
+		require.Equal(t, []packager.FileInfo{{Path: "data/countries.json", Size: 23}, {Path: "data/words.txt", Size: 8}}, info.Files)
+	})
+}
@@ -0,0 +1,318 @@
+package packager
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+)
+
+// SignatureFile is the path of the signature inside a signed package.
+const SignatureFile = "signature.json"
+
+// signatureAlgorithm is the only supported signature algorithm.
+const signatureAlgorithm = "ed25519"
+
+// digestPrefix starts the data hashed into the digest of a package, so that a
+// signature over it cannot be mistaken for a signature over anything else.
+const digestPrefix = "ren package digest v1\x00"
+
+var (
+	// ErrUnsigned is returned when a package that must be signed is not.
+	ErrUnsigned = errors.New("package is not signed")
+	// ErrInvalidSignature is returned when the signature of a package is
+	// malformed or does not match its contents, e.g. because an entry was
+	// modified, added or removed after signing.
+	ErrInvalidSignature = errors.New("invalid package signature")
+	// ErrUntrustedKey is returned when a package is validly signed, but by a
+	// key that is not trusted.
+	ErrUntrustedKey = errors.New("package signed by an untrusted key")
+	// ErrInvalidKey is returned when a signing key is not a valid Ed25519
+	// private key or an encryption key is not KeySize bytes long.
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+// signature is the content of SignatureFile.
+type signature struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey []byte `json:"public_key"`
+	Signature []byte `json:"signature"`
+}
+
+// digester computes the canonical digest of a package: a SHA-256 hash over
+// the name and content hash of every entry but the signature, in name order.
+// The digest does not depend on the order, compression or metadata of the
+// entries.
+type digester struct {
+	entries map[string][sha256.Size]byte
+}
+
+func newDigester() *digester {
+	return &digester{entries: make(map[string][sha256.Size]byte)}
+}
+
+// add records the entry name with the given content. A package must not hold
+// two entries with the same name, since readers disagree on which one wins.
+func (d *digester) add(name string, content []byte) error {
+	if _, ok := d.entries[name]; ok {
+		return fmt.Errorf("%s: duplicate entry", name)
+	}
+	d.entries[name] = sha256.Sum256(content)
+	return nil
+}
+
+// sum returns the digest of the recorded entries.
+func (d *digester) sum() []byte {
+	h := sha256.New()
+	_, _ = io.WriteString(h, digestPrefix)
+	for _, name := range slices.Sorted(maps.Keys(d.entries)) {
+		_ = binary.Write(h, binary.BigEndian, uint64(len(name)))
+		_, _ = io.WriteString(h, name)
+		sum := d.entries[name]
+		_, _ = h.Write(sum[:])
+	}
+	return h.Sum(nil)
+}
+
+// packageWriter writes the entries of a package to a zip archive, encrypting
+// them if it has an encryption key and signing them if it has a signing key.
+type packageWriter struct {
+	zw      *zip.Writer
+	key     ed25519.PrivateKey
+	digest  *digester
+	encrypt *encrypter
+	dirs    map[string]struct{}
+	// names holds the names of the entries written, which must be unique.
+	names map[string]struct{}
+}
+
+func newPackageWriter(w io.Writer, opts *options) (*packageWriter, error) {
+	pw := &packageWriter{
+		zw:    zip.NewWriter(w),
+		key:   opts.signingKey,
+		dirs:  make(map[string]struct{}),
+		names: make(map[string]struct{}),
+	}
+	if opts.signingKey != nil {
+		pw.digest = newDigester()
+	}
+	if opts.encryptionKey != nil {
+		enc, err := newEncrypter(opts.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		h := &zip.FileHeader{
+			Name:   EncryptionFile,
+			Method: zip.Deflate,
+		}
+		h.SetMode(0644)
+		err = pw.create(h, enc.header)
+		if err != nil {
+			return nil, err
+		}
+		pw.encrypt = enc
+	}
+	return pw, nil
+}
+
+// create adds an entry with the given header and content. It fails if the
+// package already has an entry of the same name, which the runtime would
+// refuse to load.
+func (pw *packageWriter) create(h *zip.FileHeader, content []byte) error {
+	if _, ok := pw.names[h.Name]; ok {
+		return fmt.Errorf("duplicate entry %s", h.Name)
+	}
+	pw.names[h.Name] = struct{}{}
+	if pw.encrypt != nil && !isPlainEntry(h.Name) {
+		var err error
+		content, err = pw.encrypt.encrypt(h.Name, content)
+		if err != nil {
+			return err
+		}
+		// Ciphertext does not compress.
+		h.Method = zip.Store
+	}
+	if pw.digest != nil {
+		err := pw.digest.add(h.Name, content)
+		if err != nil {
+			return err
+		}
+	}
+	fw, err := pw.zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(content)
+	return err
+}
+
+// addDir adds an entry for dir and each of its parent directories, unless
+// already added. Directories are added ahead of the first entry they contain.
+func (pw *packageWriter) addDir(dir string) error {
+	for _, d := range parentDirs(dir) {
+		if _, ok := pw.dirs[d]; ok {
+			continue
+		}
+		pw.dirs[d] = struct{}{}
+		h := &zip.FileHeader{Name: d + "/"}
+		h.SetMode(fs.ModeDir | 0755)
+		err := pw.create(h, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close adds the signature, if the package is signed, and finishes the
+// archive.
+func (pw *packageWriter) close() error {
+	if pw.digest != nil {
+		err := writeSignature(pw.zw, pw.digest, pw.key)
+		if err != nil {
+			return err
+		}
+	}
+	return pw.zw.Close()
+}
+
+// writeSignature signs the entries recorded by d with key and writes the
+// signature to zw as SignatureFile.
+func writeSignature(zw *zip.Writer, d *digester, key ed25519.PrivateKey) error {
+	b, err := json.MarshalIndent(signature{
+		Algorithm: signatureAlgorithm,
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(key, d.sum()),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	h := &zip.FileHeader{
+		Name:   SignatureFile,
+		Method: zip.Deflate,
+	}
+	h.SetMode(0644)
+	fw, err := zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(append(b, '\n'))
+	return err
+}
+
+// Sign writes a copy of the package read from r to w, signed with key. Any
+// existing signature is replaced. The entries are copied unchanged.
+func Sign(r io.ReaderAt, size int64, w io.Writer, key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return ErrInvalidKey
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	d := newDigester()
+	zw := zip.NewWriter(w)
+	for _, file := range zr.File {
+		if file.Name == SignatureFile {
+			continue
+		}
+		b, err := readZipFile(file)
+		if err != nil {
+			return err
+		}
+		err = d.add(file.Name, b)
+		if err != nil {
+			return err
+		}
+		err = zw.Copy(file)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeSignature(zw, d, key)
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Signer checks the signature of the package and returns the public key it
+// was signed with. It returns ErrUnsigned if the package has no signature and
+// ErrInvalidSignature if the signature does not match the package contents.
+// Signer does not decide whether the key is trusted; see Verify.
+func Signer(zr *zip.Reader) (ed25519.PublicKey, error) {
+	var sig *signature
+	d := newDigester()
+	for _, file := range zr.File {
+		b, err := readZipFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if file.Name == SignatureFile {
+			if sig != nil {
+				return nil, fmt.Errorf("%w: %s: duplicate entry", ErrInvalidSignature, SignatureFile)
+			}
+			sig = &signature{}
+			err = json.Unmarshal(b, sig)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+			}
+			continue
+		}
+		err = d.add(file.Name, b)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+		}
+	}
+
+	if sig == nil {
+		return nil, ErrUnsigned
+	}
+	if sig.Algorithm != signatureAlgorithm {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidSignature, sig.Algorithm)
+	}
+	if len(sig.PublicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: malformed public key", ErrInvalidSignature)
+	}
+	key := ed25519.PublicKey(sig.PublicKey)
+	if !ed25519.Verify(key, d.sum(), sig.Signature) {
+		return nil, ErrInvalidSignature
+	}
+	return key, nil
+}
+
+// Verify checks that the package is signed by one of the trusted keys. Besides
+// the errors of Signer, it returns ErrUntrustedKey if the package is signed by
+// a key that is not trusted.
+func Verify(zr *zip.Reader, trusted ...ed25519.PublicKey) error {
+	key, err := Signer(zr)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(trusted, func(k ed25519.PublicKey) bool { return k.Equal(key) }) {
+		return ErrUntrustedKey
+	}
+	return nil
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return io.ReadAll(f)
+}
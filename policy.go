@@ -0,0 +1,457 @@
+package ren
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/foohq/urlpath"
+)
+
+// ErrDenied is returned when a script attempts an operation its Policy does
+// not grant.
+var ErrDenied = errors.New("denied by policy")
+
+// Policy declares the capabilities granted to a script. It is enforced by the
+// runtime when set with WithPolicy: anything a policy does not grant is denied
+// with a *PolicyError. Without a policy a script may use every module and
+// filesystem registered with the runtime.
+type Policy struct {
+	// Modules lists the built-in modules the script may import.
+	Modules []string
+	// Filesystems lists the filesystem locations the script may access.
+	Filesystems []FSGrant
+	// Env lists the environment variables the script may read. Entries are
+	// patterns as accepted by path.Match, e.g. "APP_*".
+	Env []string
+	// EnvWrite lists the environment variables the script may set and unset,
+	// in the same form as Env.
+	EnvWrite []string
+	// Exit permits the script to call os.exit.
+	Exit bool
+}
+
+// FSGrant grants access to the part of a filesystem below a path prefix.
+type FSGrant struct {
+	// Scheme is the URL scheme of the filesystem, e.g. "file" or "package".
+	Scheme string
+	// Prefix is the path the grant is confined to. It matches whole path
+	// elements, so "/data" covers "/data/a" but not "/database". An empty
+	// prefix grants the whole filesystem.
+	Prefix string
+	// Read permits opening files for reading, reading directories and stat.
+	Read bool
+	// Write permits creating, modifying, renaming and removing files.
+	Write bool
+}
+
+// PolicyError describes an operation denied by the policy. It matches
+// ErrDenied with errors.Is.
+type PolicyError struct {
+	Op   string
+	Name string
+}
+
+// Error returns the error message.
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Name, ErrDenied)
+}
+
+// Unwrap returns ErrDenied.
+func (e *PolicyError) Unwrap() error {
+	return ErrDenied
+}
+
+// allowModule reports whether the built-in module name may be imported.
+func (p *Policy) allowModule(name string) bool {
+	if p == nil {
+		return true
+	}
+	for _, mod := range p.Modules {
+		if mod == name {
+			return true
+		}
+	}
+	return false
+}
+
+// allowEnv reports whether the environment variable key may be read, or
+// written if write is set.
+func (p *Policy) allowEnv(key string, write bool) bool {
+	if p == nil {
+		return true
+	}
+	patterns := p.Env
+	if write {
+		patterns = p.EnvWrite
+	}
+	return matchAny(patterns, key)
+}
+
+// allowExit reports whether the script may call os.exit.
+func (p *Policy) allowExit() bool {
+	return p == nil || p.Exit
+}
+
+// allowPath reports whether the filesystem URL name may be read, or written if
+// write is set.
+func (p *Policy) allowPath(name string, write bool) bool {
+	if p == nil {
+		return true
+	}
+	scheme, err := urlpath.Scheme(name)
+	if err != nil {
+		return false
+	}
+	if scheme == "" {
+		scheme = "file"
+	}
+	pth, err := fsPath(name)
+	if err != nil {
+		return false
+	}
+	pth = path.Clean("/" + strings.TrimPrefix(pth, "/"))
+
+	for _, grant := range p.Filesystems {
+		if grant.Scheme != scheme {
+			continue
+		}
+		if write && !grant.Write || !write && !grant.Read {
+			continue
+		}
+		if hasPathPrefix(pth, grant.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPathPrefix reports whether pth lies at or below prefix, comparing whole
+// path elements.
+func hasPathPrefix(pth, prefix string) bool {
+	prefix = path.Clean("/" + strings.TrimPrefix(prefix, "/"))
+	if prefix == "/" || pth == prefix {
+		return true
+	}
+	return strings.HasPrefix(pth, prefix+"/")
+}
+
+var _ FS = (*policyFS)(nil)
+
+// policyFS enforces a Policy on every operation before passing it on to the
+// underlying filesystem. It expects absolute URLs, as produced by osMiddleware.
+type policyFS struct {
+	fs     FS
+	policy *Policy
+}
+
+func (f *policyFS) Mkdir(name string, perm FileMode) error {
+	if err := f.check("mkdir", name, true); err != nil {
+		return err
+	}
+	return f.fs.Mkdir(name, perm)
+}
+
+func (f *policyFS) MkdirAll(path string, perm FileMode) error {
+	if err := f.check("mkdir", path, true); err != nil {
+		return err
+	}
+	return f.fs.MkdirAll(path, perm)
+}
+
+func (f *policyFS) MkdirTemp(dir, pattern string) (string, error) {
+	if err := f.check("mkdir", dir, true); err != nil {
+		return "", err
+	}
+	return f.fs.MkdirTemp(dir, pattern)
+}
+
+func (f *policyFS) OpenFile(name string, flag int, perm FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		if err := f.check("open", name, true); err != nil {
+			return nil, err
+		}
+	}
+	if flag&os.O_WRONLY == 0 {
+		if err := f.check("open", name, false); err != nil {
+			return nil, err
+		}
+	}
+	return f.fs.OpenFile(name, flag, perm)
+}
+
+func (f *policyFS) ReadFile(name string) ([]byte, error) {
+	if err := f.check("open", name, false); err != nil {
+		return nil, err
+	}
+	return f.fs.ReadFile(name)
+}
+
+func (f *policyFS) Remove(name string) error {
+	if err := f.check("remove", name, true); err != nil {
+		return err
+	}
+	return f.fs.Remove(name)
+}
+
+func (f *policyFS) RemoveAll(path string) error {
+	if err := f.check("remove", path, true); err != nil {
+		return err
+	}
+	return f.fs.RemoveAll(path)
+}
+
+func (f *policyFS) Rename(oldPath, newPath string) error {
+	if err := f.check("rename", oldPath, true); err != nil {
+		return err
+	}
+	if err := f.check("rename", newPath, true); err != nil {
+		return err
+	}
+	return f.fs.Rename(oldPath, newPath)
+}
+
+func (f *policyFS) Stat(name string) (FileInfo, error) {
+	if err := f.check("stat", name, false); err != nil {
+		return nil, err
+	}
+	return f.fs.Stat(name)
+}
+
+// Symlink requires write access to both names, so that a link cannot expose a
+// target outside the granted locations.
+func (f *policyFS) Symlink(oldName, newName string) error {
+	if err := f.check("symlink", oldName, true); err != nil {
+		return err
+	}
+	if err := f.check("symlink", newName, true); err != nil {
+		return err
+	}
+	return f.fs.Symlink(oldName, newName)
+}
+
+func (f *policyFS) WriteFile(name string, data []byte, perm FileMode) error {
+	if err := f.check("open", name, true); err != nil {
+		return err
+	}
+	return f.fs.WriteFile(name, data, perm)
+}
+
+func (f *policyFS) ReadDir(name string) ([]DirEntry, error) {
+	if err := f.check("open", name, false); err != nil {
+		return nil, err
+	}
+	return f.fs.ReadDir(name)
+}
+
+func (f *policyFS) check(op, name string, write bool) error {
+	if f.policy.allowPath(name, write) {
+		return nil
+	}
+	return &PolicyError{Op: op, Name: name}
+}
+
+var (
+	_ OS          = (*policyOS)(nil)
+	_ ExitChecker = (*policyOS)(nil)
+)
+
+// policyOS enforces a Policy on an OS installed in the context with WithOS,
+// which the runtime uses instead of building its own. Names are resolved
+// against the working directory of the OS before they are checked.
+type policyOS struct {
+	OS
+	fs     *policyFS
+	policy *Policy
+}
+
+func newPolicyOS(o OS, policy *Policy) *policyOS {
+	return &policyOS{
+		OS:     o,
+		fs:     &policyFS{fs: o, policy: policy},
+		policy: policy,
+	}
+}
+
+func (o *policyOS) Mkdir(name string, perm FileMode) error {
+	pth, err := o.abs(name)
+	if err != nil {
+		return err
+	}
+	return o.fs.Mkdir(pth, perm)
+}
+
+func (o *policyOS) MkdirAll(path string, perm FileMode) error {
+	pth, err := o.abs(path)
+	if err != nil {
+		return err
+	}
+	return o.fs.MkdirAll(pth, perm)
+}
+
+func (o *policyOS) MkdirTemp(dir, pattern string) (string, error) {
+	pth, err := o.abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return o.fs.MkdirTemp(pth, pattern)
+}
+
+func (o *policyOS) OpenFile(name string, flag int, perm FileMode) (File, error) {
+	pth, err := o.abs(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.fs.OpenFile(pth, flag, perm)
+}
+
+func (o *policyOS) ReadFile(name string) ([]byte, error) {
+	pth, err := o.abs(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.fs.ReadFile(pth)
+}
+
+func (o *policyOS) Remove(name string) error {
+	pth, err := o.abs(name)
+	if err != nil {
+		return err
+	}
+	return o.fs.Remove(pth)
+}
+
+func (o *policyOS) RemoveAll(path string) error {
+	pth, err := o.abs(path)
+	if err != nil {
+		return err
+	}
+	return o.fs.RemoveAll(pth)
+}
+
+func (o *policyOS) Rename(oldPath, newPath string) error {
+	oldPth, err := o.abs(oldPath)
+	if err != nil {
+		return err
+	}
+	newPth, err := o.abs(newPath)
+	if err != nil {
+		return err
+	}
+	return o.fs.Rename(oldPth, newPth)
+}
+
+func (o *policyOS) Stat(name string) (FileInfo, error) {
+	pth, err := o.abs(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.fs.Stat(pth)
+}
+
+func (o *policyOS) Symlink(oldName, newName string) error {
+	oldPth, err := o.abs(oldName)
+	if err != nil {
+		return err
+	}
+	newPth, err := o.abs(newName)
+	if err != nil {
+		return err
+	}
+	return o.fs.Symlink(oldPth, newPth)
+}
+
+func (o *policyOS) WriteFile(name string, data []byte, perm FileMode) error {
+	pth, err := o.abs(name)
+	if err != nil {
+		return err
+	}
+	return o.fs.WriteFile(pth, data, perm)
+}
+
+func (o *policyOS) ReadDir(name string) ([]DirEntry, error) {
+	pth, err := o.abs(name)
+	if err != nil {
+		return nil, err
+	}
+	return o.fs.ReadDir(pth)
+}
+
+func (o *policyOS) Chdir(dir string) error {
+	pth, err := o.abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := o.fs.check("chdir", pth, false); err != nil {
+		return err
+	}
+	return o.OS.Chdir(dir)
+}
+
+func (o *policyOS) Environ() []string {
+	var result []string
+	for _, kv := range o.OS.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if o.policy.allowEnv(key, false) {
+			result = append(result, kv)
+		}
+	}
+	return result
+}
+
+func (o *policyOS) Getenv(key string) string {
+	value, _ := o.LookupEnv(key)
+	return value
+}
+
+func (o *policyOS) LookupEnv(key string) (string, bool) {
+	if !o.policy.allowEnv(key, false) {
+		return "", false
+	}
+	return o.OS.LookupEnv(key)
+}
+
+func (o *policyOS) Setenv(key, value string) error {
+	if !o.policy.allowEnv(key, true) {
+		return &PolicyError{Op: "setenv", Name: key}
+	}
+	return o.OS.Setenv(key, value)
+}
+
+func (o *policyOS) Unsetenv(key string) error {
+	if !o.policy.allowEnv(key, true) {
+		return &PolicyError{Op: "unsetenv", Name: key}
+	}
+	return o.OS.Unsetenv(key)
+}
+
+// CheckExit refuses the exit if the policy does not grant it, then defers to
+// the OS if it checks exits itself.
+func (o *policyOS) CheckExit(code int) error {
+	if !o.policy.allowExit() {
+		return &PolicyError{Op: "exit", Name: strconv.Itoa(code)}
+	}
+	if checker, ok := o.OS.(ExitChecker); ok {
+		return checker.CheckExit(code)
+	}
+	return nil
+}
+
+// Exit does nothing if the policy does not grant it.
+func (o *policyOS) Exit(code int) {
+	if o.policy.allowExit() {
+		o.OS.Exit(code)
+	}
+}
+
+// abs resolves name against the working directory of the OS.
+func (o *policyOS) abs(name string) (string, error) {
+	wd, err := o.OS.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return urlpath.Abs(name, wd)
+}
@@ -0,0 +1,332 @@
+package ren
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/deepnoodle-ai/risor/v2"
+	"github.com/deepnoodle-ai/risor/v2/pkg/bytecode"
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+	"github.com/deepnoodle-ai/risor/v2/pkg/vm"
+
+	"github.com/foohq/ren/packager"
+)
+
+// entrypointFile is the compiled entrypoint inside a package.
+const entrypointFile = "entrypoint" + moduleExt
+
+// Risor creates its default type registry lazily and without synchronisation.
+// Create it up front so that concurrent runs do not race on it.
+var _ = object.DefaultRegistry()
+
+// Program is a loaded package, ready to be run. Load parses and validates the
+// package and decodes the bytecode of every module once; Run can then be
+// called any number of times, concurrently, and each run gets its own module
+// cache and execution environment.
+type Program struct {
+	pkg        *zip.Reader
+	files      fs.FS
+	entrypoint *bytecode.Code
+	modules    map[string]*bytecode.Code
+	manifest   *packager.Manifest
+	// lock is the lockfile of the package, or nil if it has no dependencies.
+	lock *packager.Lock
+	// deps holds the packages the program imports modules from with the
+	// pkg:// scheme, by name: vendored ones and those given with
+	// WithPackageDependency.
+	deps map[string]*Program
+
+	signOnce sync.Once
+	signer   ed25519.PublicKey
+	signErr  error
+}
+
+// Load reads a package from an io.ReaderAt. The reader must remain valid for
+// as long as the Program is used, since data files are read from it on demand.
+//
+// The options that concern the package itself apply to loading: with
+// WithTrustedKeys the signature is verified before any bytecode is decoded,
+// an encrypted package is decrypted with the key given by WithDecryptionKey or
+// WithKeyProvider, and the dependencies that are not vendored in the package
+// are loaded from WithPackageDependency, with the same options. All other
+// options are ignored; pass them to Program.Run.
+func Load(reader io.ReaderAt, size int64, opt ...Option) (*Program, error) {
+	var opts options
+	for _, o := range opt {
+		o(&opts)
+	}
+	return load(context.Background(), reader, size, &opts)
+}
+
+// load reads a package from an io.ReaderAt, verifying its signature and
+// decrypting it as opts require.
+func load(ctx context.Context, reader io.ReaderAt, size int64, opts *options) (*Program, error) {
+	return loadProgram(ctx, reader, size, opts, nil)
+}
+
+// loadProgram loads a package like load. loading lists the names of the
+// dependencies being loaded, whose package depends on this one, so that a
+// cycle of dependencies is reported rather than loaded forever.
+func loadProgram(ctx context.Context, reader io.ReaderAt, size int64, opts *options, loading []string) (*Program, error) {
+	zr, err := zip.NewReader(reader, size)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Program{pkg: zr}
+	err = p.verify(opts.trustedKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	p.files, err = opts.decrypt(ctx, zr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.loadFiles(ctx, opts, loading)
+	if err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	p.entrypoint, ok = p.modules[entrypointFile]
+	if !ok {
+		return nil, fmt.Errorf("open %s: %w", entrypointFile, fs.ErrNotExist)
+	}
+
+	return p, nil
+}
+
+// loadFiles decodes the modules, the manifest and the dependencies of the
+// package from p.files.
+func (p *Program) loadFiles(ctx context.Context, opts *options, loading []string) error {
+	var err error
+	p.lock, err = packager.ReadLock(p.files)
+	if err != nil {
+		return err
+	}
+
+	p.modules, err = packager.ReadModules(p.files)
+	if err != nil {
+		return err
+	}
+
+	p.manifest, err = loadManifest(p.files)
+	if err != nil {
+		return err
+	}
+
+	if p.lock != nil {
+		p.deps, err = loadDependencies(ctx, p.files, p.lock, opts, loading)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify checks that the package is signed by one of the trusted keys. The
+// signature is checked once per program; verify returns nil if no keys are
+// given.
+func (p *Program) verify(trusted []ed25519.PublicKey) error {
+	if len(trusted) == 0 {
+		return nil
+	}
+
+	p.signOnce.Do(func() {
+		p.signer, p.signErr = packager.Signer(p.pkg)
+	})
+	if p.signErr != nil {
+		return p.signErr
+	}
+	if !slices.ContainsFunc(trusted, func(key ed25519.PublicKey) bool { return key.Equal(p.signer) }) {
+		return packager.ErrUntrustedKey
+	}
+	return nil
+}
+
+// Manifest returns a copy of the package manifest, or nil if the package was
+// built without one.
+func (p *Program) Manifest() *packager.Manifest {
+	if p.manifest == nil {
+		return nil
+	}
+	m := *p.manifest
+	m.Authors = slices.Clone(m.Authors)
+	m.Requires.Modules = slices.Clone(m.Requires.Modules)
+	m.Requires.Builtins = slices.Clone(m.Requires.Builtins)
+	m.Dependencies = maps.Clone(m.Dependencies)
+	return &m
+}
+
+// Run executes the program's entrypoint. It returns a *RequirementError
+// without running any code if the runtime does not satisfy the requirements
+// declared in the package manifest, and a signature error if WithTrustedKeys
+// is given and the package is not signed by one of the keys.
+func (p *Program) Run(ctx context.Context, opt ...Option) error {
+	rt := p.newRuntime(ctx, opt)
+	err := rt.check()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := rt.begin(ctx)
+	defer stop()
+
+	_, err = risor.Run(ctx, p.entrypoint,
+		risor.WithEnv(rt.env),
+		risor.WithFilename(p.entrypoint.Filename()),
+		risor.WithObserver(rt.limits),
+	)
+	if err != nil {
+		return rt.error(ctx, err)
+	}
+
+	return nil
+}
+
+// runtime is the execution environment of a single run of a program, or of an
+// Instance and all of its calls: the globals, the OS and filesystems, the
+// importer with its module cache and the execution limits.
+type runtime struct {
+	prog     *Program
+	opts     options
+	env      map[string]any
+	os       OS
+	importer *Importer
+	limits   *limiter
+}
+
+func (p *Program) newRuntime(ctx context.Context, opt []Option) *runtime {
+	var opts options
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	limits := newLimiter(opts.maxSteps)
+
+	builtins := opts.Builtins()
+	modules := opts.Modules()
+	if opts.manifestRestriction {
+		builtins, modules = p.restrict(builtins, modules)
+	}
+
+	env := make(map[string]any, len(builtins))
+	maps.Copy(env, builtins)
+
+	var o OS
+	if isOS(ctx) {
+		o = GetOS(ctx)
+		if opts.policy != nil {
+			o = newPolicyOS(o, opts.policy)
+		}
+	} else {
+		var fs FS = fsMiddleware(opts.Filesystems(p))
+		if opts.policy != nil {
+			fs = &policyFS{fs: fs, policy: opts.policy}
+		}
+		if opts.auditor != nil {
+			fs = &auditFS{fs: fs, auditor: opts.auditor}
+		}
+		o = &osMiddleware{
+			fs:          fs,
+			stdin:       opts.Stdin(),
+			stdout:      opts.Stdout(),
+			stderr:      opts.Stderr(),
+			args:        opts.Args(),
+			wd:          strings.TrimPrefix(opts.workingDir, "file://"),
+			env:         newEnviron(opts.Env()),
+			exitHandler: opts.ExitHandler(),
+			policy:      opts.policy,
+			auditor:     opts.auditor,
+		}
+	}
+
+	return &runtime{
+		prog:     p,
+		opts:     opts,
+		env:      env,
+		os:       o,
+		importer: p.newImporter(modules, opts.resolvers, env, opts.policy, limits),
+		limits:   limits,
+	}
+}
+
+// begin prepares ctx for executing script code in the runtime: it installs the
+// OS and the importer and arms the execution limits. The returned function
+// must be called once execution ends.
+func (rt *runtime) begin(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := func() {
+		cancel(nil)
+	}
+
+	if rt.opts.timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, rt.opts.timeout, &LimitError{
+			Limit: LimitTimeout,
+			Max:   int64(rt.opts.timeout),
+		})
+		stop = func() {
+			cancelTimeout()
+			cancel(nil)
+		}
+	}
+
+	rt.limits.reset(ctx.Done(), cancel)
+
+	ctx = WithOS(ctx, rt.os)
+	ctx = WithImporter(ctx, rt.importer)
+
+	return ctx, stop
+}
+
+// vmOptions returns the options of a VM executing script code in the runtime.
+func (rt *runtime) vmOptions() []vm.Option {
+	return []vm.Option{
+		vm.WithGlobals(rt.env),
+		vm.WithObserver(rt.limits),
+	}
+}
+
+// error converts an error returned by the VM into the error reported to the
+// caller. Exceeding an execution limit is reported as the *LimitError that
+// cancelled ctx.
+func (rt *runtime) error(ctx context.Context, err error) error {
+	if limitErr, ok := errors.AsType[*LimitError](context.Cause(ctx)); ok {
+		return limitErr
+	}
+	if ctx.Err() != nil {
+		// The limiter, rather than the VM, may have halted execution.
+		err = ctx.Err()
+	}
+	return &Error{err: err, prog: rt.prog}
+}
+
+// loadManifest reads the manifest of the package, or returns nil if it has
+// none.
+func loadManifest(files fs.FS) (*packager.Manifest, error) {
+	b, err := fs.ReadFile(files, packager.ManifestFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := packager.ParseManifest(b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load %q: %w", packager.ManifestFile, err)
+	}
+	return manifest, nil
+}
@@ -12,18 +12,17 @@
 package ren
 
 import (
-	"archive/zip"
 	"bytes"
 	"context"
-	"errors"
+	"crypto/ed25519"
 	"io"
 	"maps"
 	"os"
+	"time"
 
-	"github.com/deepnoodle-ai/risor/v2"
-	"github.com/deepnoodle-ai/risor/v2/pkg/compiler"
 	"github.com/deepnoodle-ai/risor/v2/pkg/object"
-	"github.com/deepnoodle-ai/risor/v2/pkg/parser"
+
+	"github.com/foohq/ren/packager"
 )
 
 // Option is a function that configures the execution of a script.
@@ -62,6 +61,15 @@ func WithFilesystem(scheme string, fs FS) Option {
 	}
 }
 
+// WithPackageModules controls whether the compiled modules of the package are
+// visible through the package filesystem. By default only the data files
+// bundled with the package can be read under the package scheme.
+func WithPackageModules(visible bool) Option {
+	return func(o *options) {
+		o.packageModules = visible
+	}
+}
+
 // WithStdin sets the standard input file for the script.
 func WithStdin(f File) Option {
 	return func(o *options) {
@@ -76,6 +84,50 @@ func WithStdout(f File) Option {
 	}
 }
 
+// WithStderr sets the standard error file for the script.
+func WithStderr(f File) Option {
+	return func(o *options) {
+		o.stderr = f
+	}
+}
+
+// WithEnv sets the environment variables the script starts with. Every run has
+// an environment of its own: os.setenv and os.unsetenv modify only that
+// environment, never the host process's or another run's. Without WithEnv and
+// WithInheritEnv a run starts with a copy of the host process environment.
+func WithEnv(env map[string]string) Option {
+	return func(o *options) {
+		if o.env == nil {
+			o.env = make(map[string]string, len(env))
+		}
+		maps.Copy(o.env, env)
+	}
+}
+
+// WithInheritEnv copies the host environment variables whose names match any
+// of the patterns, as accepted by path.Match (e.g. "LANG" or "APP_*"), into the
+// environment the script starts with. Variables set with WithEnv take
+// precedence.
+func WithInheritEnv(patterns ...string) Option {
+	return func(o *options) {
+		if o.inheritEnv == nil {
+			o.inheritEnv = []string{}
+		}
+		o.inheritEnv = append(o.inheritEnv, patterns...)
+	}
+}
+
+// WithWorkingDir sets the working directory the script starts in, which
+// relative paths are resolved against: a path of the "file" filesystem, or a
+// URL of another, e.g. "mem:///work". Without it, the script starts in the
+// working directory of the host process. Pair it with a filesystem confined
+// by NewSubFS, whose root is "/", so that relative paths stay within the root.
+func WithWorkingDir(dir string) Option {
+	return func(o *options) {
+		o.workingDir = dir
+	}
+}
+
 // WithArgs sets the command line arguments for the script.
 func WithArgs(args []string) Option {
 	return func(o *options) {
@@ -90,6 +142,137 @@ func WithExitHandler(handler ExitHandler) Option {
 	}
 }
 
+// WithPolicy restricts the script to the capabilities granted by policy.
+// Operations the policy does not grant fail with a *PolicyError. The policy
+// also applies to an OS installed in the context with WithOS.
+func WithPolicy(policy Policy) Option {
+	return func(o *options) {
+		o.policy = &policy
+	}
+}
+
+// WithAuditor makes the runtime report every operation the script performs
+// through its OS to auditor: filesystem calls, with the absolute URLs they
+// resolve to, and changes to the working directory and the environment, and
+// os.exit. Operations denied by the policy are reported along with the
+// *PolicyError. An OS installed in the context with WithOS is not audited.
+// See NewJSONAuditor for a ready-made auditor.
+func WithAuditor(auditor Auditor) Option {
+	return func(o *options) {
+		o.auditor = auditor
+	}
+}
+
+// WithManifestRestriction, when restricted is true, makes only the modules
+// and builtins the package manifest requires available to the script (see
+// packager.Requirements). Modules are restricted only if the manifest lists
+// some, and likewise builtins, so a package without a manifest runs with
+// everything the runtime provides. The import builtin is always kept; Run
+// returns a *RequirementError if the package uses another builtin the manifest
+// does not list.
+func WithManifestRestriction(restricted bool) Option {
+	return func(o *options) {
+		o.manifestRestriction = restricted
+	}
+}
+
+// WithMaxSteps limits the number of instructions the script may execute,
+// including those of the modules it imports. The count is approximate and may
+// overshoot the budget slightly before execution is aborted with a
+// *LimitError.
+func WithMaxSteps(n int64) Option {
+	return func(o *options) {
+		o.maxSteps = n
+	}
+}
+
+// WithTimeout limits the wall-clock time the script may run for. Execution is
+// aborted with a *LimitError once the deadline passes.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithTrustedKeys makes the runtime refuse to run a package unless it is
+// signed by one of keys (see packager.WithSigningKey). An unsigned package is
+// rejected with packager.ErrUnsigned, a package modified after signing with
+// packager.ErrInvalidSignature and one signed by another key with
+// packager.ErrUntrustedKey.
+func WithTrustedKeys(keys ...ed25519.PublicKey) Option {
+	return func(o *options) {
+		o.trustedKeys = append(o.trustedKeys, keys...)
+	}
+}
+
+// WithDecryptionKey adds a key to decrypt encrypted packages with (see
+// packager.WithEncryptionKey). Several keys may be added; the runtime picks
+// the one a package was encrypted with. Running an encrypted package fails with
+// packager.ErrMissingKey if no key is given and with packager.ErrWrongKey if
+// none of the keys is the right one.
+func WithDecryptionKey(key []byte) Option {
+	return func(o *options) {
+		if key == nil {
+			return
+		}
+		o.decryptionKeys = append(o.decryptionKeys, key)
+	}
+}
+
+// WithKeyProvider adds a provider of keys to decrypt encrypted packages with.
+// Providers are asked, in the order they were added, only if no key added with
+// WithDecryptionKey fits the package.
+func WithKeyProvider(provider KeyProvider) Option {
+	return func(o *options) {
+		if provider == nil {
+			return
+		}
+		o.keyProviders = append(o.keyProviders, provider)
+	}
+}
+
+// WithPackageDependency provides the package read from reader as the
+// dependency name of the package being run, for a dependency its manifest
+// declares but that is not vendored in it (see packager.Dependency). Its
+// modules are imported with the pkg:// scheme, e.g. import("pkg://name/mod").
+// The package must satisfy the version constraint recorded in the lockfile of
+// the package being run, and is itself verified and decrypted with the same
+// options. A package whose dependency is missing fails to load with
+// ErrMissingDependency.
+func WithPackageDependency(name string, reader io.ReaderAt, size int64) Option {
+	return func(o *options) {
+		if reader == nil {
+			return
+		}
+		if o.dependencies == nil {
+			o.dependencies = make(map[string]dependencySource)
+		}
+		o.dependencies[name] = dependencySource{reader: reader, size: size}
+	}
+}
+
+// WithResolver registers resolver to resolve the imports of the URL scheme,
+// e.g. import("db://lib/util") for the scheme "db". Resolved modules are
+// cached, checked for import cycles and exported like the modules of the
+// package. The schemes the Importer supports itself ("package", "pkg" and
+// "builtin") cannot be taken over; a resolver for one of them, or a nil
+// resolver, is ignored.
+func WithResolver(scheme string, resolver ModuleResolver) Option {
+	return func(o *options) {
+		if resolver == nil {
+			return
+		}
+		switch scheme {
+		case "", packageScheme, dependencyScheme, builtinScheme:
+			return
+		}
+		if o.resolvers == nil {
+			o.resolvers = make(map[string]ModuleResolver)
+		}
+		o.resolvers[scheme] = resolver
+	}
+}
+
 // RunBytes executes a Ren script provided as a byte slice.
 func RunBytes(ctx context.Context, b []byte, opts ...Option) error {
 	reader := bytes.NewReader(b)
@@ -114,83 +297,83 @@ func RunFile(ctx context.Context, filename string, opts ...Option) error {
 	return Run(ctx, f, inf.Size(), opts...)
 }
 
-// Run executes a Ren script from an io.ReaderAt.
-func Run(ctx context.Context, reader io.ReaderAt, size int64, opt ...Option) error {
-	var opts options
-	for _, o := range opt {
-		o(&opts)
-	}
-
-	zr, err := zip.NewReader(reader, size)
-	if err != nil {
-		return err
+// RunDir compiles the source directory dir in memory, following the same rules
+// as packager.Build, and executes the resulting package. The builtins added with
+// WithBuiltin are treated as pre-declared globals during compilation,
+// built-in imports are checked against the modules added with WithModule (see
+// packager.WithModule) and imports of the schemes of the resolvers added with
+// WithResolver are accepted. Nothing is written to disk, which makes RunDir
+// convenient during development, when building a package after every change
+// gets in the way.
+func RunDir(ctx context.Context, dir string, opts ...Option) error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
 
-	b, err := readEntrypoint(zr)
-	if err != nil {
-		return err
+	var buildOpts []packager.Option
+	for _, builtin := range o.builtins {
+		buildOpts = append(buildOpts, packager.WithBuiltin(builtin))
 	}
-
-	code, err := compiler.UnmarshalCode(b)
-	if err != nil {
-		return err
+	for _, module := range o.modules {
+		buildOpts = append(buildOpts, packager.WithModule(module))
 	}
-
-	builtins := opts.Builtins()
-
-	env := make(map[string]any, len(builtins))
-	maps.Copy(env, builtins)
-
-	if !isOS(ctx) {
-		ctx = WithOS(ctx, &osMiddleware{
-			fs:          opts.Filesystems(),
-			stdin:       opts.Stdin(),
-			stdout:      opts.Stdout(),
-			args:        opts.Args(),
-			exitHandler: opts.ExitHandler(),
-		})
+	for scheme := range o.resolvers {
+		buildOpts = append(buildOpts, packager.WithScheme(scheme))
 	}
 
-	ctx = WithImporter(ctx, newImporter(zr, opts.Modules(), env))
-
-	_, err = risor.Run(
-		ctx,
-		code.ToBytecode(),
-		risor.WithEnv(env),
-		risor.WithFilename(code.Filename()),
-	)
+	var buf bytes.Buffer
+	err := packager.BuildFS(ctx, os.DirFS(dir), &buf, buildOpts...)
 	if err != nil {
-		return &Error{err}
+		return err
 	}
 
-	return nil
+	return RunBytes(ctx, buf.Bytes(), opts...)
 }
 
-func readEntrypoint(zr *zip.Reader) ([]byte, error) {
-	f, err := zr.Open("entrypoint.json")
-	if err != nil {
-		return nil, err
+// Run executes a Ren script from an io.ReaderAt. To run the same package many
+// times, Load it once and call Program.Run instead. With WithTrustedKeys, the
+// package signature is verified before any bytecode is decoded. An encrypted
+// package is decrypted with the key given by WithDecryptionKey or
+// WithKeyProvider.
+func Run(ctx context.Context, reader io.ReaderAt, size int64, opts ...Option) error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
-	defer func() {
-		_ = f.Close()
-	}()
 
-	b, err := io.ReadAll(f)
+	prog, err := load(ctx, reader, size, &o)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	return b, nil
+	return prog.Run(ctx, opts...)
 }
 
 type options struct {
 	stdin       File
 	stdout      File
+	stderr      File
 	args        []string
+	workingDir  string
+	env         map[string]string
+	inheritEnv  []string
 	exitHandler ExitHandler
 	filesystems map[string]FS
 	builtins    []*object.Builtin
 	modules     []*object.Module
+	policy      *Policy
+	auditor     Auditor
+	trustedKeys []ed25519.PublicKey
+
+	decryptionKeys [][]byte
+	keyProviders   []KeyProvider
+	dependencies   map[string]dependencySource
+	resolvers      map[string]ModuleResolver
+
+	maxSteps            int64
+	timeout             time.Duration
+	packageModules      bool
+	manifestRestriction bool
 }
 
 func (o *options) Builtins() map[string]any {
@@ -209,9 +392,10 @@ func (o *options) Modules() map[string]*object.Module {
 	return result
 }
 
-func (o *options) Filesystems() map[string]FS {
+func (o *options) Filesystems(prog *Program) map[string]FS {
 	result := make(map[string]FS, len(o.filesystems))
 	result["file"] = &localFS{}
+	result[packageScheme] = newPackageFS(prog, o.packageModules)
 	maps.Copy(result, o.filesystems)
 	return result
 }
@@ -230,6 +414,13 @@ func (o *options) Stdout() File {
 	return os.Stdout
 }
 
+func (o *options) Stderr() File {
+	if o.stderr != nil {
+		return o.stderr
+	}
+	return os.Stderr
+}
+
 func (o *options) Args() []string {
 	if o.args != nil {
 		return o.args
@@ -237,27 +428,22 @@ func (o *options) Args() []string {
 	return []string{}
 }
 
+// Env returns the environment a run starts with.
+func (o *options) Env() map[string]string {
+	if o.env == nil && o.inheritEnv == nil {
+		return hostEnv(nil)
+	}
+	env := make(map[string]string, len(o.env))
+	if len(o.inheritEnv) > 0 {
+		maps.Copy(env, hostEnv(o.inheritEnv))
+	}
+	maps.Copy(env, o.env)
+	return env
+}
+
 func (o *options) ExitHandler() ExitHandler {
 	if o.exitHandler != nil {
 		return o.exitHandler
 	}
 	return func(code int) {}
 }
-
-// Error represents an error that occurred during script execution.
-type Error struct {
-	err error
-}
-
-// Unwrap returns the underlying error.
-func (e *Error) Unwrap() error {
-	return e.err
-}
-
-// Error returns the error message.
-func (e *Error) Error() string {
-	if parserErr, ok := errors.AsType[parser.ParserError](e.err); ok {
-		return parserErr.FriendlyErrorMessage()
-	}
-	return e.err.Error()
-}
@@ -1,18 +1,34 @@
 package ren_test
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"github.com/foohq/ren"
 	"github.com/foohq/ren/builtins"
 	"github.com/foohq/ren/modules"
 	"github.com/foohq/ren/packager"
+	"github.com/foohq/ren/testutils"
 )
 
 func TestRunFile(t *testing.T) {
@@ -67,6 +83,35 @@ func TestRunFile(t *testing.T) {
 	}
 }
 
+// TestRunDir verifies that a source directory runs without a build step and
+// without leaving files in the working directory.
+func TestRunDir(t *testing.T) {
+	srcDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "lib"), 0755))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(srcDir, "entrypoint.risor"),
+		[]byte(`const greet = import("lib/greet")`+"\n"+`print(greet.hello("ren"))`+"\n"),
+		0644,
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(srcDir, "lib", "greet.risor"),
+		[]byte("function hello(name) {\n  return \"hello \" + name\n}\n"),
+		0644,
+	))
+
+	wd := t.TempDir()
+	t.Chdir(wd)
+
+	stdout := &bytes.Buffer{}
+	opts := append(stdOptions(), ren.WithStdout(&bufferFile{Buffer: stdout}))
+	require.NoError(t, ren.RunDir(context.Background(), srcDir, opts...))
+	require.Equal(t, "hello ren\n", stdout.String())
+
+	entries, err := os.ReadDir(wd)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
 // TestModuleErrorLineNumber verifies that a runtime error inside an imported
 // module reports the module's real source line and column, despite the packager
 // wrapping the module in a synthetic function.
@@ -90,6 +135,98 @@ func TestModuleErrorLineNumber(t *testing.T) {
 	require.Contains(t, err.Error(), "(3:13)")
 }
 
+// TestErrorStack verifies that a script error exposes a stack spanning the
+// entrypoint and its modules, with package-relative files, and a friendly
+// message quoting the source.
+func TestErrorStack(t *testing.T) {
+	srcDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "lib"), 0755))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(srcDir, "entrypoint.risor"),
+		[]byte("const m = import(\"lib/m\")\nfunction f() {\n  return m.boom()\n}\nf()\n"),
+		0644,
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(srcDir, "lib", "m.risor"),
+		[]byte("function boom() {\n  const a = 1\n  return a(2)\n}\n"),
+		0644,
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(srcDir, "lib", "init.risor"),
+		[]byte("const a = 1\nconst b = a(2)\n"),
+		0644,
+	))
+
+	t.Run("call into module", func(t *testing.T) {
+		err := packAndRun(t, srcDir)
+		renErr, ok := errors.AsType[*ren.Error](err)
+		require.True(t, ok, "got %v", err)
+		require.Equal(t, []ren.Frame{
+			{Function: "boom", File: "lib/m.risor", Line: 3, Column: 12},
+			{Function: "f", File: "entrypoint.risor", Line: 3, Column: 12},
+			{Function: "__main__", File: "entrypoint.risor", Line: 5, Column: 1},
+		}, renErr.Stack())
+
+		msg := renErr.FriendlyErrorMessage()
+		require.Contains(t, msg, " --> lib/m.risor:3:12\n")
+		require.Contains(t, msg, "3 |   return a(2)\n  |            ^\n")
+		require.Contains(t, msg, "  at f (entrypoint.risor:3:12)\n")
+	})
+
+	t.Run("error during import", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(
+			filepath.Join(srcDir, "entrypoint.risor"),
+			[]byte(`const m = import("lib/init")`+"\n"),
+			0644,
+		))
+		err := packAndRun(t, srcDir)
+		renErr, ok := errors.AsType[*ren.Error](err)
+		require.True(t, ok, "got %v", err)
+		require.Equal(t, []ren.Frame{
+			{Function: "__main__", File: "lib/init.risor", Line: 2, Column: 13},
+			{Function: "__main__", File: "entrypoint.risor", Line: 1, Column: 18},
+		}, renErr.Stack())
+		require.Contains(t, renErr.FriendlyErrorMessage(), "2 | const b = a(2)\n")
+	})
+
+	t.Run("error during nested import", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(
+			filepath.Join(srcDir, "lib", "outer.risor"),
+			[]byte("const x = 1\nconst init = import(\"./init\")\n"),
+			0644,
+		))
+		require.NoError(t, os.WriteFile(
+			filepath.Join(srcDir, "entrypoint.risor"),
+			[]byte("function load() {\n  return import(\"lib/outer\")\n}\nload()\n"),
+			0644,
+		))
+		err := packAndRun(t, srcDir)
+		renErr, ok := errors.AsType[*ren.Error](err)
+		require.True(t, ok, "got %v", err)
+		require.Equal(t, []ren.Frame{
+			{Function: "__main__", File: "lib/init.risor", Line: 2, Column: 13},
+			{Function: "__main__", File: "lib/outer.risor", Line: 2, Column: 21},
+			{Function: "load", File: "entrypoint.risor", Line: 2, Column: 17},
+			{Function: "__main__", File: "entrypoint.risor", Line: 4, Column: 1},
+		}, renErr.Stack())
+	})
+
+	t.Run("caught import error", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(
+			filepath.Join(srcDir, "entrypoint.risor"),
+			[]byte("const ok = try { import(\"lib/\" + \"missing\"); true } catch (e) { false }\nassert(!ok)\nconst m = import(\"lib/m\")\nm.boom()\n"),
+			0644,
+		))
+		err := packAndRun(t, srcDir)
+		renErr, ok := errors.AsType[*ren.Error](err)
+		require.True(t, ok, "got %v", err)
+		require.Equal(t, []ren.Frame{
+			{Function: "boom", File: "lib/m.risor", Line: 3, Column: 12},
+			{Function: "__main__", File: "entrypoint.risor", Line: 4, Column: 3},
+		}, renErr.Stack())
+	})
+}
+
 // TestModuleImportCycle verifies that a circular import is rejected with a
 // cycle error rather than looping or overflowing the stack.
 func TestModuleImportCycle(t *testing.T) {
@@ -100,7 +237,8 @@ func TestModuleImportCycle(t *testing.T) {
 		[]byte(`const a = import("lib/a")`+"\n"),
 		0644,
 	))
-	// lib/a imports lib/b, which imports lib/a -> cycle.
+	// lib/a imports lib/b, which imports lib/a -> cycle. The name is not a
+	// literal, so the packager cannot detect the cycle and the runtime must.
 	require.NoError(t, os.WriteFile(
 		filepath.Join(srcDir, "lib", "a.risor"),
 		[]byte(`const b = import("lib/b")`+"\n"),
@@ -108,7 +246,7 @@ func TestModuleImportCycle(t *testing.T) {
 	))
 	require.NoError(t, os.WriteFile(
 		filepath.Join(srcDir, "lib", "b.risor"),
-		[]byte(`const a = import("lib/a")`+"\n"),
+		[]byte(`let name = "lib/a"`+"\n"+`const a = import(name)`+"\n"),
 		0644,
 	))
 
@@ -117,9 +255,938 @@ func TestModuleImportCycle(t *testing.T) {
 	require.Contains(t, err.Error(), "import cycle detected")
 }
 
+// TestRelativeImport verifies that relative imports resolve against the
+// importing module, whether the name is a literal resolved by the packager or
+// computed while the module loads, and cannot escape the package root.
+func TestRelativeImport(t *testing.T) {
+	files := map[string]string{
+		"entrypoint.risor": "const a = import(\"lib/a\")\nprint(a.greet())\nprint(a.later().name)\n",
+		"lib/a.risor": "const b = import(\"./sub/b\")\n" +
+			"let name = \"../shared/c\"\n" +
+			"const c = import(name)\n" +
+			"function greet() { return b.name + \" \" + c.name }\n" +
+			"function later() { return import(\"./sub/b\") }\n",
+		"lib/sub/b.risor":  "const name = \"b\"\n",
+		"shared/c.risor":   "const name = \"c\"\n",
+		"lib/escape.risor": "let name = \"../../c\"\nconst c = import(name)\n",
+	}
+
+	srcDir := t.TempDir()
+	for name, content := range files {
+		pth := filepath.Join(srcDir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755))
+		require.NoError(t, os.WriteFile(pth, []byte(content), 0644))
+	}
+
+	t.Run("resolved", func(t *testing.T) {
+		stdout := &bytes.Buffer{}
+		err := packAndRun(t, srcDir, ren.WithStdout(&bufferFile{Buffer: stdout}))
+		require.NoError(t, err)
+		require.Equal(t, "b c\nb\n", stdout.String())
+	})
+
+	t.Run("escape", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(
+			filepath.Join(srcDir, "entrypoint.risor"),
+			[]byte(`const e = import("lib/escape")`+"\n"),
+			0644,
+		))
+		err := packAndRun(t, srcDir)
+		require.ErrorContains(t, err, "import path escapes the package root")
+	})
+}
+
+// TestPackageDependency verifies that modules of vendored and runtime
+// dependencies are importable with the pkg:// scheme, that each dependency has
+// a module cache of its own and resolves its imports within itself, and that
+// runtime dependencies are checked against the lockfile.
+func TestPackageDependency(t *testing.T) {
+	buildLib := func(version string) []byte {
+		return buildFS(t, fstest.MapFS{
+			"ren.toml":         {Data: []byte(`version = "` + version + `"`)},
+			"entrypoint.risor": {Data: []byte("let x = 1\n")},
+			"counter.risor":    {Data: []byte("let count = 0\nfunction inc() {\n  count += 1\n  return count\n}\n")},
+			"greet.risor": {Data: []byte("const util = import(\"./util\")\n" +
+				"function hello(name) { return util.prefix + name }\n" +
+				"function later() { return import(\"util\").prefix }\n")},
+			"util.risor": {Data: []byte(`const prefix = "hello "` + "\n")},
+			"fn.risor": {Data: []byte("function apply(f) { return f() }\n" +
+				"function maker() { return function() { return import(\"util\").prefix } }\n" +
+				"function boxed() { return {\"fetch\": function() { return import(\"util\").prefix }} }\n" +
+				"const table = {\"fetch\": function() { return import(\"util\").prefix }}\n")},
+		})
+	}
+	lib := buildLib("1.2.3")
+
+	main := buildFS(t, fstest.MapFS{
+		"ren.toml": {Data: []byte("[dependencies]\n" +
+			"a = { version = \"^1.0.0\", path = \"deps/lib.zip\" }\n" +
+			"b = { version = \"^1.2.0\" }\n")},
+		"deps/lib.zip": {Data: lib},
+		"entrypoint.risor": {Data: []byte("const ga = import(\"pkg://a/greet\")\n" +
+			"const ca = import(\"pkg://a/counter\")\n" +
+			"const cb = import(\"pkg://b/counter\")\n" +
+			"print(ga.hello(\"ren\"))\n" +
+			"print(ga.later())\n" +
+			"print(ca.inc(), ca.inc(), cb.inc())\n" +
+			"const fa = import(\"pkg://a/fn\")\n" +
+			"print(fa.apply(function() { return import(\"util\").prefix }))\n" +
+			"print(fa.maker()(), fa.boxed()[\"fetch\"](), fa.table[\"fetch\"]())\n")},
+		// The dependency's own util module must not resolve to this one.
+		"util.risor": {Data: []byte(`const prefix = "main "` + "\n")},
+	})
+
+	t.Run("run", func(t *testing.T) {
+		stdout := &bytes.Buffer{}
+		opts := append(stdOptions(),
+			ren.WithStdout(&bufferFile{Buffer: stdout}),
+			ren.WithPackageDependency("b", bytes.NewReader(lib), int64(len(lib))),
+		)
+		err := ren.RunBytes(context.Background(), main, opts...)
+		require.NoError(t, err)
+		require.Equal(t, "hello ren\nhello \n1 2 1\nmain \nhello  hello  hello \n", stdout.String())
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		err := ren.RunBytes(context.Background(), main, stdOptions()...)
+		require.ErrorIs(t, err, ren.ErrMissingDependency)
+	})
+
+	t.Run("unsatisfied", func(t *testing.T) {
+		old := buildLib("1.1.0")
+		opts := append(stdOptions(), ren.WithPackageDependency("b", bytes.NewReader(old), int64(len(old))))
+		err := ren.RunBytes(context.Background(), main, opts...)
+		require.ErrorIs(t, err, packager.ErrInvalidDependency)
+	})
+}
+
+// TestProgram verifies that a loaded package can be run many times, including
+// concurrently, with every run getting its own options and module state.
+func TestProgram(t *testing.T) {
+	srcDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "lib"), 0755))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(srcDir, "entrypoint.risor"),
+		[]byte(`const counter = import("lib/counter")
+counter.incr()
+const os = import("builtin://os")
+print(os.args()[0] + ":" + string(counter.incr()))
+`),
+		0644,
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(srcDir, "lib", "counter.risor"),
+		[]byte("let n = 0\nfunction incr() {\n\tn++\n\treturn n\n}\n"),
+		0644,
+	))
+
+	prog := loadProgram(t, pack(t, srcDir))
+
+	const runs = 8
+	outputs := make([]*bufferFile, runs)
+	errs := make([]error, runs)
+	var wg sync.WaitGroup
+	for i := range runs {
+		outputs[i] = &bufferFile{Buffer: &bytes.Buffer{}}
+		wg.Go(func() {
+			opts := append(stdOptions(),
+				ren.WithStdout(outputs[i]),
+				ren.WithArgs([]string{strconv.Itoa(i)}),
+			)
+			errs[i] = prog.Run(context.Background(), opts...)
+		})
+	}
+	wg.Wait()
+
+	for i := range runs {
+		require.NoError(t, errs[i])
+		require.Equal(t, strconv.Itoa(i)+":2\n", outputs[i].String())
+	}
+}
+
+// TestInstance verifies that the host can call the functions a script exports
+// and that values are converted between Go and Risor in both directions.
+func TestInstance(t *testing.T) {
+	srcDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "lib"), 0755))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(srcDir, "entrypoint.risor"),
+		[]byte(`const strings = import("lib/strings")
+let events = 0
+function handle_event(event) {
+	events++
+	return {"name": strings.upper(event.name), "tags": event.tags, "count": events}
+}
+function fail(msg) {
+	return error(msg)
+}
+function spin() {
+	range(1000000000).each(i => i)
+}
+const upper = strings.upper
+`),
+		0644,
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(srcDir, "lib", "strings.risor"),
+		[]byte("function upper(s) {\n\treturn s.to_upper()\n}\n"),
+		0644,
+	))
+
+	pkg := pack(t, srcDir)
+	prog := loadProgram(t, pkg)
+	inst, err := prog.Instantiate(context.Background(), append(stdOptions(), ren.WithMaxSteps(100000))...)
+	require.NoError(t, err)
+	require.Equal(t, []string{"events", "fail", "handle_event", "spin", "strings", "upper"}, inst.Exports())
+
+	// The exports are those ren inspect reports for the entrypoint.
+	b, err := os.ReadFile(pkg)
+	require.NoError(t, err)
+	info, err := packager.Inspect(bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+	idx := slices.IndexFunc(info.Modules, func(m packager.ModuleInfo) bool { return m.Path == "entrypoint.json" })
+	require.NotEqual(t, -1, idx)
+	require.Equal(t, info.Modules[idx].Exports, inst.Exports())
+
+	result, err := inst.Call(context.Background(), "handle_event", map[string]any{
+		"name": "click",
+		"tags": []string{"a", "b"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"name": "CLICK", "tags": []any{"a", "b"}, "count": int64(1)}, result)
+
+	_, err = inst.Call(context.Background(), "handle_event", map[string]any{"name": "key", "tags": nil})
+	require.NoError(t, err)
+	events, err := inst.Get("events")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), events)
+
+	result, err = inst.Call(context.Background(), "upper", []byte("x"))
+	require.Error(t, err)
+	require.Nil(t, result)
+
+	result, err = inst.Call(context.Background(), "fail", "boom")
+	require.NoError(t, err)
+	require.EqualError(t, result.(error), "boom")
+
+	// Every call gets a fresh step budget.
+	for range 2 {
+		_, err = inst.Call(context.Background(), "spin")
+		var limitErr *ren.LimitError
+		require.ErrorAs(t, err, &limitErr)
+		require.Equal(t, ren.LimitSteps, limitErr.Limit)
+	}
+
+	// Cancelling the context of a call halts it, and only it.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = inst.Call(ctx, "spin")
+	require.ErrorIs(t, err, context.Canceled)
+	for range 10 {
+		_, err = inst.Call(context.Background(), "handle_event", map[string]any{"name": "key", "tags": nil})
+		require.NoError(t, err)
+	}
+	events, err = inst.Get("events")
+	require.NoError(t, err)
+	require.Equal(t, int64(12), events)
+
+	_, err = inst.Call(context.Background(), "print")
+	require.Error(t, err)
+	_, err = inst.Call(context.Background(), "events")
+	require.Error(t, err)
+}
+
+// TestPackageFS verifies that scripts can read the data files bundled with
+// their package through the read-only package:// filesystem, and that compiled
+// modules stay hidden unless requested.
+func TestPackageFS(t *testing.T) {
+	srcDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "templates"), 0755))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(srcDir, "templates", "a.txt"),
+		[]byte("hello"),
+		0644,
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(srcDir, "lib.risor"),
+		[]byte("const x = 1\n"),
+		0644,
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(srcDir, "entrypoint.risor"),
+		[]byte(`const fs = import("builtin://fs")
+assert(string(fs.read_file("package://templates/a.txt")) == "hello")
+assert(fs.stat("package:///templates/a.txt").size() == 5)
+assert(len(fs.read_dir("package://templates")) == 1)
+
+let f = fs.open_file("package://templates/a.txt", "r", 0)
+assert(f.info().size() == 5)
+f.close()
+
+const written = try { fs.write_file("package://templates/b.txt", "x", 0644); true } catch (e) { false }
+assert(!written)
+const opened = try { fs.open_file("package://templates/a.txt", "w", 0644); true } catch (e) { false }
+assert(!opened)
+
+const visible = try { fs.stat("package://lib.json"); true } catch (e) { false }
+print(visible)
+`),
+		0644,
+	))
+
+	t.Run("hidden modules", func(t *testing.T) {
+		stdout := &bytes.Buffer{}
+		err := packAndRun(t, srcDir, ren.WithStdout(&bufferFile{Buffer: stdout}))
+		require.NoError(t, err)
+		require.Equal(t, "false\n", stdout.String())
+	})
+
+	t.Run("visible modules", func(t *testing.T) {
+		stdout := &bytes.Buffer{}
+		err := packAndRun(t, srcDir, ren.WithStdout(&bufferFile{Buffer: stdout}), ren.WithPackageModules(true))
+		require.NoError(t, err)
+		require.Equal(t, "true\n", stdout.String())
+	})
+
+	t.Run("hidden metadata and dependencies", func(t *testing.T) {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		lib := buildFS(t, fstest.MapFS{
+			"entrypoint.risor": {Data: []byte("let x = 1\n")},
+			"data/secret.txt":  {Data: []byte("secret")},
+		})
+		b := buildFS(t, fstest.MapFS{
+			"ren.toml":     {Data: []byte("name = \"demo\"\n[dependencies]\nlib = { path = \"deps/lib.zip\" }\n")},
+			"deps/lib.zip": {Data: lib},
+			"entrypoint.risor": {Data: []byte(`const fs = import("builtin://fs")
+function read(name) { return fs.read_file("package://" + name) }
+function count(name) { return len(fs.read_dir("package://" + name)) }
+`)},
+		}, packager.WithSigningKey(priv))
+
+		prog, err := ren.Load(bytes.NewReader(b), int64(len(b)))
+		require.NoError(t, err)
+		inst, err := prog.Instantiate(context.Background(), stdOptions()...)
+		require.NoError(t, err)
+		for _, name := range []string{"ren.json", "ren.index", "ren.lock", "signature.json", "pkg/lib", "pkg/lib/ren.index", "pkg/lib/data/secret.txt"} {
+			_, err := inst.Call(context.Background(), "read", name)
+			require.ErrorIs(t, err, fs.ErrNotExist, name)
+		}
+		n, err := inst.Call(context.Background(), "count", "pkg")
+		require.NoError(t, err)
+		require.EqualValues(t, 0, n)
+	})
+}
+
+// TestPolicy verifies that a policy denies every capability it does not grant
+// with an error matching ren.ErrDenied.
+func TestPolicy(t *testing.T) {
+	dataDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "in.txt"), []byte("in"), 0644))
+	t.Setenv("REN_POLICY_ALLOWED", "yes")
+	t.Setenv("REN_POLICY_SECRET", "no")
+
+	policy := ren.Policy{
+		Modules: []string{"fs", "os"},
+		Filesystems: []ren.FSGrant{
+			{Scheme: "file", Prefix: dataDir, Read: true},
+			{Scheme: "file", Prefix: filepath.Join(dataDir, "out"), Read: true, Write: true},
+		},
+		Env:      []string{"REN_POLICY_A*"},
+		EnvWrite: []string{"REN_POLICY_NEW"},
+	}
+
+	tests := []struct {
+		name    string
+		script  string
+		wantErr bool
+	}{
+		{
+			name:   "allowed module",
+			script: `import("builtin://fs")`,
+		},
+		{
+			name:    "denied module",
+			script:  `import("builtin://filepath")`,
+			wantErr: true,
+		},
+		{
+			name:   "read granted prefix",
+			script: `import("builtin://fs").read_file("` + filepath.ToSlash(filepath.Join(dataDir, "in.txt")) + `")`,
+		},
+		{
+			name:    "write read-only prefix",
+			script:  `import("builtin://fs").write_file("` + filepath.ToSlash(filepath.Join(dataDir, "x.txt")) + `", "x", 0644)`,
+			wantErr: true,
+		},
+		{
+			name:   "write granted prefix",
+			script: `import("builtin://fs").mkdir("` + filepath.ToSlash(filepath.Join(dataDir, "out")) + `", 0755)`,
+		},
+		{
+			name:    "read outside prefix",
+			script:  `import("builtin://fs").read_dir("` + filepath.ToSlash(filepath.Dir(dataDir)) + `")`,
+			wantErr: true,
+		},
+		{
+			name:    "denied scheme",
+			script:  `import("builtin://fs").read_dir("package://")`,
+			wantErr: true,
+		},
+		{
+			name: "env allowlist",
+			script: `const os = import("builtin://os")
+assert(os.getenv("REN_POLICY_ALLOWED") == "yes")
+assert(os.getenv("REN_POLICY_SECRET") == "")
+assert(len(os.environ()) == 1)`,
+		},
+		{
+			name:   "setenv allowed",
+			script: `import("builtin://os").setenv("REN_POLICY_NEW", "1")`,
+		},
+		{
+			name:    "setenv denied",
+			script:  `import("builtin://os").setenv("REN_POLICY_SECRET", "1")`,
+			wantErr: true,
+		},
+		{
+			name:    "exit denied",
+			script:  `import("builtin://os").exit(0)`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcDir := t.TempDir()
+			require.NoError(t, os.WriteFile(
+				filepath.Join(srcDir, "entrypoint.risor"),
+				[]byte(tt.script+"\n"),
+				0644,
+			))
+
+			err := packAndRun(t, srcDir, ren.WithPolicy(policy))
+			if tt.wantErr {
+				require.ErrorIs(t, err, ren.ErrDenied)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+
+	t.Run("os from context", func(t *testing.T) {
+		m := &testutils.MockOS{}
+		m.On("Getwd").Return("/", nil)
+		m.On("ReadFile", filepath.ToSlash(filepath.Join(dataDir, "in.txt"))).Return([]byte("in"), nil)
+		ctx := ren.WithOS(context.Background(), m)
+
+		for script, wantErr := range map[string]bool{
+			`import("builtin://fs").read_file("` + filepath.ToSlash(filepath.Join(dataDir, "in.txt")) + `")`: false,
+			`import("builtin://fs").read_file("/etc/passwd")`:                                                true,
+			`import("builtin://os").setenv("REN_POLICY_SECRET", "1")`:                                        true,
+			`import("builtin://os").exit(0)`:                                                                 true,
+		} {
+			pkg := buildFS(t, fstest.MapFS{
+				"entrypoint.risor": {Data: []byte(script + "\n")},
+			})
+			err := ren.RunBytes(ctx, pkg, append(stdOptions(), ren.WithPolicy(policy))...)
+			if wantErr {
+				require.ErrorIs(t, err, ren.ErrDenied, script)
+				continue
+			}
+			require.NoError(t, err, script)
+		}
+		m.AssertNotCalled(t, "Setenv", mock.Anything, mock.Anything)
+		m.AssertNotCalled(t, "Exit", mock.Anything)
+	})
+}
+
+func TestAuditor(t *testing.T) {
+	pkg := buildFS(t, fstest.MapFS{
+		"entrypoint.risor": {Data: []byte(`const fs = import("builtin://fs")
+const os = import("builtin://os")
+fs.mkdir("/data", 0755)
+os.chdir("/data")
+fs.write_file("out.txt", "hello", 0644)
+let f = fs.open_file("out.txt", "a", 0644)
+f.write("!!")
+f.close()
+fs.rename("out.txt", "/moved.txt")
+os.setenv("REN_AUDIT", "1")
+try {
+	fs.read_file("/missing.txt")
+} catch (e) {
+}
+os.exit(3)
+`)},
+	})
+
+	var events []ren.AuditEvent
+	var buf bytes.Buffer
+	jsonAuditor := ren.NewJSONAuditor(&buf)
+	exitCode := -1
+	opts := append(stdOptions(),
+		ren.WithFilesystem("file", ren.NewMemFS()),
+		ren.WithEnv(map[string]string{}),
+		ren.WithAuditor(func(event ren.AuditEvent) {
+			events = append(events, event)
+			jsonAuditor(event)
+		}),
+		ren.WithExitHandler(func(code int) {
+			exitCode = code
+		}),
+	)
+	err := ren.RunBytes(context.Background(), pkg, opts...)
+	require.ErrorContains(t, err, "exited with code 3")
+	require.Equal(t, 3, exitCode)
+
+	var ops []string
+	for _, event := range events {
+		ops = append(ops, event.Op+" "+event.Name)
+	}
+	require.Equal(t, []string{
+		"Mkdir file:///data",
+		"Stat file:///data",
+		"Chdir file:///data",
+		"WriteFile file:///data/out.txt",
+		"OpenFile file:///data/out.txt",
+		"Close file:///data/out.txt",
+		"Rename file:///data/out.txt",
+		"Setenv REN_AUDIT",
+		"ReadFile file:///missing.txt",
+		"Exit 3",
+	}, ops)
+
+	require.Equal(t, fs.FileMode(0755), events[0].Perm)
+	require.Equal(t, int64(5), events[3].BytesWritten)
+	require.Equal(t, os.O_WRONLY|os.O_CREATE|os.O_APPEND, events[4].Flag)
+	require.Equal(t, int64(2), events[5].BytesWritten)
+	require.Equal(t, "file:///moved.txt", events[6].Target)
+	require.ErrorIs(t, events[8].Err, fs.ErrNotExist)
+	for _, event := range events {
+		require.False(t, event.Time.IsZero())
+		require.GreaterOrEqual(t, event.Duration, time.Duration(0))
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, len(events))
+	var line map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[8]), &line))
+	require.Equal(t, "ReadFile", line["op"])
+	require.Equal(t, "file:///missing.txt", line["name"])
+	require.Contains(t, line["error"], "file does not exist")
+}
+
+// TestEnv verifies that every run has an environment of its own, seeded from
+// the options, and that changes to it reach neither the host process nor
+// other runs.
+func TestEnv(t *testing.T) {
+	t.Setenv("REN_ENV_HOST", "host")
+	t.Setenv("REN_ENV_OTHER", "other")
+
+	tests := []struct {
+		name   string
+		opts   []ren.Option
+		script string
+	}{
+		{
+			name: "host copy",
+			script: `assert(os.getenv("REN_ENV_HOST") == "host")
+os.setenv("REN_ENV_HOST", "changed")
+assert(os.getenv("REN_ENV_HOST") == "changed")`,
+		},
+		{
+			name: "with env",
+			opts: []ren.Option{ren.WithEnv(map[string]string{"A": "1"})},
+			script: `print(os.environ())
+assert(string(os.environ()) == string(["A=1"]))
+os.unsetenv("A")
+assert(len(os.environ()) == 0)`,
+		},
+		{
+			name: "inherit selected",
+			opts: []ren.Option{
+				ren.WithInheritEnv("REN_ENV_H*"),
+				ren.WithEnv(map[string]string{"B": "2"}),
+			},
+			script: `assert(string(os.environ()) == string(["B=2", "REN_ENV_HOST=host"]))`,
+		},
+		{
+			name:   "inherit nothing",
+			opts:   []ren.Option{ren.WithInheritEnv()},
+			script: `assert(len(os.environ()) == 0)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcDir := t.TempDir()
+			require.NoError(t, os.WriteFile(
+				filepath.Join(srcDir, "entrypoint.risor"),
+				[]byte("const os = import(\"builtin://os\")\n"+tt.script+"\n"),
+				0644,
+			))
+			require.NoError(t, packAndRun(t, srcDir, tt.opts...))
+		})
+	}
+	require.Equal(t, "host", os.Getenv("REN_ENV_HOST"))
+
+	// Concurrent runs never observe each other's changes.
+	srcDir := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(srcDir, "entrypoint.risor"),
+		[]byte(`const os = import("builtin://os")
+const id = os.args()[0]
+range(100).each(i => {
+	os.setenv("REN_ENV_ID", id)
+	assert(os.getenv("REN_ENV_ID") == id)
+})
+`),
+		0644,
+	))
+	prog := loadProgram(t, pack(t, srcDir))
+
+	const runs = 8
+	errs := make([]error, runs)
+	var wg sync.WaitGroup
+	for i := range runs {
+		wg.Go(func() {
+			opts := append(stdOptions(), ren.WithArgs([]string{strconv.Itoa(i)}))
+			errs[i] = prog.Run(context.Background(), opts...)
+		})
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	_, ok := os.LookupEnv("REN_ENV_ID")
+	require.False(t, ok)
+}
+
+// TestLimits verifies that a script exceeding an execution limit, directly or
+// inside an imported module, is aborted with a *ren.LimitError naming the limit.
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		opt       ren.Option
+		wantLimit ren.Limit
+	}{
+		{
+			name: "steps",
+			files: map[string]string{
+				"entrypoint.risor": "range(1000000000).each(i => i)\n",
+			},
+			opt:       ren.WithMaxSteps(10000),
+			wantLimit: ren.LimitSteps,
+		},
+		{
+			name: "steps in module",
+			files: map[string]string{
+				"entrypoint.risor": `const spin = import("lib/spin")` + "\n",
+				"lib/spin.risor":   "range(1000000000).each(i => i)\n",
+			},
+			opt:       ren.WithMaxSteps(10000),
+			wantLimit: ren.LimitSteps,
+		},
+		{
+			name: "timeout",
+			files: map[string]string{
+				"entrypoint.risor": "range(1000000000).each(i => i)\n",
+			},
+			opt:       ren.WithTimeout(50 * time.Millisecond),
+			wantLimit: ren.LimitTimeout,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcDir := t.TempDir()
+			for name, content := range tt.files {
+				pth := filepath.Join(srcDir, filepath.FromSlash(name))
+				require.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755))
+				require.NoError(t, os.WriteFile(pth, []byte(content), 0644))
+			}
+
+			err := packAndRun(t, srcDir, tt.opt)
+			limitErr, ok := errors.AsType[*ren.LimitError](err)
+			require.Truef(t, ok, "expected *ren.LimitError, got %v", err)
+			require.Equal(t, tt.wantLimit, limitErr.Limit)
+		})
+	}
+}
+
+func TestRequirements(t *testing.T) {
+	// A pre-release of Ren satisfies the release it leads to.
+	release, _, _ := strings.Cut(ren.Version(), "-")
+
+	tests := []struct {
+		name     string
+		manifest string
+		script   string
+		opts     []ren.Option
+		wantErr  *ren.RequirementError
+	}{
+		{
+			name:     "satisfied",
+			manifest: "[requires]\nren = \"0.1.0\"\nmodules = [\"os\"]\nbuiltins = [\"print\"]\n",
+			opts:     stdOptions(),
+		},
+		{
+			name:     "release of pre-release ren",
+			manifest: "[requires]\nren = \"" + release + "\"\n",
+			opts:     stdOptions(),
+		},
+		{
+			name:     "newer ren",
+			manifest: "name = \"hello\"\n[requires]\nren = \"99.0.0\"\n",
+			opts:     stdOptions(),
+			wantErr:  &ren.RequirementError{Package: "hello", Ren: "99.0.0"},
+		},
+		{
+			name:     "missing module and builtin",
+			manifest: "[requires]\nmodules = [\"os\", \"gpio\"]\nbuiltins = [\"print\", \"blink\"]\n",
+			opts:     stdOptions(),
+			wantErr:  &ren.RequirementError{Modules: []string{"gpio"}, Builtins: []string{"blink"}},
+		},
+		{
+			name:     "no options",
+			manifest: "[requires]\nmodules = [\"os\"]\nbuiltins = [\"print\"]\n",
+			wantErr:  &ren.RequirementError{Modules: []string{"os"}, Builtins: []string{"print"}},
+		},
+		{
+			name:     "restricted keeps import",
+			manifest: "[requires]\nmodules = [\"os\"]\nbuiltins = [\"print\"]\n",
+			script:   "import(\"builtin://os\")\nprint(\"ran\")\n",
+			opts:     append(stdOptions(), ren.WithManifestRestriction(true)),
+		},
+		{
+			name:     "restricted unlisted builtin",
+			manifest: "[requires]\nbuiltins = [\"print\"]\n",
+			script:   "print(string(\"ran\"))\n",
+			opts:     append(stdOptions(), ren.WithManifestRestriction(true)),
+			wantErr:  &ren.RequirementError{Builtins: []string{"string"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := tt.script
+			if script == "" {
+				script = "print(\"ran\")\n"
+			}
+			srcDir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(srcDir, "entrypoint.risor"), []byte(script), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(srcDir, "ren.toml"), []byte(tt.manifest), 0644))
+			prog := loadProgram(t, pack(t, srcDir))
+
+			var stdout bytes.Buffer
+			opts := append(tt.opts, ren.WithStdout(&bufferFile{&stdout}))
+			err := prog.Run(context.Background(), opts...)
+			if tt.wantErr == nil {
+				require.NoError(t, err)
+				require.Equal(t, "ran\n", stdout.String())
+				return
+			}
+			require.ErrorIs(t, err, ren.ErrUnsatisfied)
+			reqErr, ok := errors.AsType[*ren.RequirementError](err)
+			require.True(t, ok)
+			require.Equal(t, tt.wantErr, reqErr)
+			require.Empty(t, stdout.String())
+
+			_, err = prog.Instantiate(context.Background(), opts...)
+			require.ErrorIs(t, err, ren.ErrUnsatisfied)
+		})
+	}
+}
+
+func TestTrustedKeys(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	srcDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "entrypoint.risor"), []byte("print(\"ran\")\n"), 0644))
+
+	build := func(opts ...packager.Option) []byte {
+		var buf bytes.Buffer
+		for _, b := range builtins.Builtins() {
+			opts = append(opts, packager.WithBuiltin(b))
+		}
+		require.NoError(t, packager.BuildFS(context.Background(), os.DirFS(srcDir), &buf, opts...))
+		return buf.Bytes()
+	}
+	signed := build(packager.WithSigningKey(priv))
+
+	// Corrupt the compiled entrypoint, keeping the archive readable: a
+	// tampered package must be rejected by its signature rather than fail to
+	// decode.
+	var tampered bytes.Buffer
+	zr, err := zip.NewReader(bytes.NewReader(signed), int64(len(signed)))
+	require.NoError(t, err)
+	zw := zip.NewWriter(&tampered)
+	for _, f := range zr.File {
+		if f.Name != "entrypoint.json" {
+			require.NoError(t, zw.Copy(f))
+			continue
+		}
+		w, err := zw.Create(f.Name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte("{}"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	tests := []struct {
+		name    string
+		pkg     []byte
+		trusted []ed25519.PublicKey
+		wantErr error
+	}{
+		{name: "signed", pkg: signed, trusted: []ed25519.PublicKey{pub}},
+		{name: "signed without trusted keys", pkg: signed},
+		{name: "unsigned without trusted keys", pkg: build()},
+		{name: "unsigned", pkg: build(), trusted: []ed25519.PublicKey{pub}, wantErr: packager.ErrUnsigned},
+		{name: "untrusted", pkg: signed, trusted: []ed25519.PublicKey{otherPub}, wantErr: packager.ErrUntrustedKey},
+		{name: "tampered", pkg: tampered.Bytes(), trusted: []ed25519.PublicKey{pub}, wantErr: packager.ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			opts := append(stdOptions(), ren.WithStdout(&bufferFile{&stdout}), ren.WithTrustedKeys(tt.trusted...))
+			err := ren.RunBytes(context.Background(), tt.pkg, opts...)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				require.Empty(t, stdout.String())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "ran\n", stdout.String())
+		})
+	}
+
+	t.Run("loaded program", func(t *testing.T) {
+		unsigned := build()
+		prog, err := ren.Load(bytes.NewReader(unsigned), int64(len(unsigned)))
+		require.NoError(t, err)
+		require.ErrorIs(t, prog.Run(context.Background(), append(stdOptions(), ren.WithTrustedKeys(pub))...), packager.ErrUnsigned)
+		_, err = prog.Instantiate(context.Background(), append(stdOptions(), ren.WithTrustedKeys(pub))...)
+		require.ErrorIs(t, err, packager.ErrUnsigned)
+
+		prog, err = ren.Load(bytes.NewReader(signed), int64(len(signed)))
+		require.NoError(t, err)
+		require.NoError(t, prog.Run(context.Background(), append(stdOptions(), ren.WithStdout(&bufferFile{&bytes.Buffer{}}), ren.WithTrustedKeys(pub))...))
+		require.ErrorIs(t, prog.Run(context.Background(), append(stdOptions(), ren.WithTrustedKeys(otherPub))...), packager.ErrUntrustedKey)
+	})
+}
+
+func TestDecryptionKey(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, packager.KeySize)
+	otherKey := bytes.Repeat([]byte{2}, packager.KeySize)
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	srcDir := t.TempDir()
+	for name, content := range map[string]string{
+		"entrypoint.risor": `const fs = import("builtin://fs")
+const greet = import("lib/greet")
+const data = string(fs.read_file("package://data/name.txt"))
+assert(fs.stat("package://data/name.txt").size() == len(data))
+print(greet.hello(data))
+`,
+		"lib/greet.risor": `function hello(name) { return "hello " + name }` + "\n",
+		"data/name.txt":   "ren",
+	} {
+		pth := filepath.Join(srcDir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755))
+		require.NoError(t, os.WriteFile(pth, []byte(content), 0644))
+	}
+
+	var buildOpts []packager.Option
+	for _, b := range builtins.Builtins() {
+		buildOpts = append(buildOpts, packager.WithBuiltin(b))
+	}
+	var buf bytes.Buffer
+	require.NoError(t, packager.BuildFS(context.Background(), os.DirFS(srcDir), &buf, append(buildOpts, packager.WithEncryptionKey(key), packager.WithSigningKey(priv))...))
+	pkg := buf.Bytes()
+
+	provider := ren.KeyProviderFunc(func(ctx context.Context, keyID string) ([]byte, error) {
+		if keyID == packager.KeyID(key) {
+			return key, nil
+		}
+		return nil, nil
+	})
+	wrongProvider := ren.KeyProviderFunc(func(ctx context.Context, keyID string) ([]byte, error) {
+		return otherKey, nil
+	})
+
+	tests := []struct {
+		name    string
+		opts    []ren.Option
+		wantErr error
+	}{
+		{name: "key", opts: []ren.Option{ren.WithDecryptionKey(otherKey), ren.WithDecryptionKey(key)}},
+		{name: "key and trusted key", opts: []ren.Option{ren.WithDecryptionKey(key), ren.WithTrustedKeys(pub)}},
+		{name: "provider", opts: []ren.Option{ren.WithKeyProvider(provider)}},
+		{name: "missing key", wantErr: packager.ErrMissingKey},
+		{name: "wrong key", opts: []ren.Option{ren.WithDecryptionKey(otherKey)}, wantErr: packager.ErrWrongKey},
+		{name: "wrong provider", opts: []ren.Option{ren.WithKeyProvider(wrongProvider)}, wantErr: packager.ErrWrongKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			opts := append(stdOptions(), ren.WithStdout(&bufferFile{&stdout}))
+			err := ren.RunBytes(context.Background(), pkg, append(opts, tt.opts...)...)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "hello ren\n", stdout.String())
+		})
+	}
+
+	t.Run("load", func(t *testing.T) {
+		_, err := ren.Load(bytes.NewReader(pkg), int64(len(pkg)))
+		require.ErrorIs(t, err, packager.ErrMissingKey)
+
+		prog, err := ren.Load(bytes.NewReader(pkg), int64(len(pkg)), ren.WithDecryptionKey(key))
+		require.NoError(t, err)
+		var stdout bytes.Buffer
+		require.NoError(t, prog.Run(context.Background(), append(stdOptions(), ren.WithStdout(&bufferFile{&stdout}))...))
+		require.Equal(t, "hello ren\n", stdout.String())
+	})
+}
+
+// bufferFile adapts a bytes.Buffer to ren.File so tests can capture a script's
+// output.
+type bufferFile struct {
+	*bytes.Buffer
+}
+
+func (f *bufferFile) Stat() (ren.FileInfo, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (f *bufferFile) Close() error {
+	return nil
+}
+
 // packAndRun builds the package rooted at srcDir with the standard builtins and
-// runs it, returning any execution error.
-func packAndRun(t *testing.T, srcDir string) error {
+// runs it with the standard builtins and modules plus any extra options,
+// returning any execution error.
+func packAndRun(t *testing.T, srcDir string, opts ...ren.Option) error {
+	t.Helper()
+	out := pack(t, srcDir)
+	return ren.RunFile(context.Background(), out, append(stdOptions(), opts...)...)
+}
+
+// pack builds the package rooted at srcDir with the standard builtins and
+// returns the path of the package file.
+func pack(t *testing.T, srcDir string) string {
 	t.Helper()
 
 	out := filepath.Join(t.TempDir(), packager.NewFilename("pkg"))
@@ -130,9 +1197,700 @@ func packAndRun(t *testing.T, srcDir string) error {
 	}
 	require.NoError(t, packager.Build(srcDir, out, buildOpts...))
 
-	var runOpts []ren.Option
+	return out
+}
+
+// TestResolver verifies that imports of a scheme registered with WithResolver
+// are served by its resolver, compiled from source or already compiled, and
+// that resolved modules are cached, checked for cycles and resolve relative
+// imports against themselves. Binding their functions must leave the maps and
+// lists of the module and of its caller as they are.
+func TestResolver(t *testing.T) {
+	compiled, err := packager.CompileModule(context.Background(), "mem://lib/fmt", "function wrap(s) { return \"[\" + s + \"]\" }\n")
+	require.NoError(t, err)
+
+	var loads []string
+	resolver := ren.ModuleResolverFunc(func(ctx context.Context, name string) (*ren.ResolvedModule, error) {
+		loads = append(loads, name)
+		switch name {
+		case "mem://lib/log":
+			return &ren.ResolvedModule{Source: "const fmt = import(\"./fmt\")\n" +
+				"function info(s) { return fmt.wrap(s) }\n" +
+				"function later() { return import(\"./util\").name }\n"}, nil
+		case "mem://lib/fmt":
+			return &ren.ResolvedModule{Code: compiled}, nil
+		case "mem://lib/util":
+			return &ren.ResolvedModule{Source: "const name = \"util\"\n"}, nil
+		case "mem://lib/table":
+			return &ren.ResolvedModule{Source: "const handlers = {\"greet\": function(s) { return \"hi \" + s }}\n" +
+				"const sizes = [1, 2]\n" +
+				"function kind() { return type(handlers[\"greet\"]) }\n" +
+				"function table() { return handlers }\n" +
+				"function apply(m) { return m[\"f\"]() }\n"}, nil
+		case "mem://a":
+			return &ren.ResolvedModule{Source: "const b = import(\"mem://b\")\n"}, nil
+		case "mem://b":
+			return &ren.ResolvedModule{Source: "const a = import(\"mem://a\")\n"}, nil
+		}
+		return nil, fs.ErrNotExist
+	})
+
+	run := func(t *testing.T, entrypoint string) (string, error) {
+		t.Helper()
+		b := buildFS(t, fstest.MapFS{
+			"entrypoint.risor": {Data: []byte(entrypoint)},
+			// Relative imports of resolved modules must not resolve to this one.
+			"lib/util.risor": {Data: []byte(`const name = "package"` + "\n")},
+		}, packager.WithScheme("mem"))
+
+		stdout := &bytes.Buffer{}
+		opts := append(stdOptions(),
+			ren.WithStdout(&bufferFile{Buffer: stdout}),
+			ren.WithResolver("mem", resolver),
+		)
+		err := ren.RunBytes(context.Background(), b, opts...)
+		return stdout.String(), err
+	}
+
+	t.Run("resolve", func(t *testing.T) {
+		loads = nil
+		out, err := run(t, "const log = import(\"mem://lib/log\")\n"+
+			"const again = import(\"mem://lib/log\")\n"+
+			"print(log.info(\"hi\"), again.later())\n")
+		require.NoError(t, err)
+		require.Equal(t, "[hi] util\n", out)
+		require.Equal(t, []string{"mem://lib/log", "mem://lib/fmt", "mem://lib/util"}, loads)
+	})
+
+	t.Run("bound copies", func(t *testing.T) {
+		out, err := run(t, "const t1 = import(\"mem://lib/table\")\n"+
+			"const t2 = import(\"mem://lib/table\")\n"+
+			"const mine = {\"f\": function() { return 1 }}\n"+
+			"print(t1.apply(mine), type(mine[\"f\"]), t1.kind(), t2.kind())\n"+
+			"print(t1.handlers[\"greet\"](\"a\"), t2.handlers[\"greet\"](\"a\"), t1.table()[\"greet\"](\"b\"))\n"+
+			"print(t1.sizes, t2.sizes, t1.sizes == t2.sizes)\n")
+		require.NoError(t, err)
+		require.Equal(t, "1 function function function\nhi a hi a hi b\n[1, 2] [1, 2] true\n", out)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := run(t, "const x = import(\"mem://missing\")\n")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		_, err := run(t, "const a = import(\"mem://a\")\n")
+		require.ErrorContains(t, err, "import cycle detected")
+	})
+
+	t.Run("reserved scheme", func(t *testing.T) {
+		b := buildFS(t, fstest.MapFS{
+			"entrypoint.risor": {Data: []byte("const os = import(\"builtin://os\")\nprint(type(os.getenv))\n")},
+		})
+		opts := append(stdOptions(),
+			ren.WithStdout(&bufferFile{Buffer: &bytes.Buffer{}}),
+			ren.WithResolver("builtin", resolver),
+		)
+		loads = nil
+		err := ren.RunBytes(context.Background(), b, opts...)
+		require.NoError(t, err)
+		require.Empty(t, loads)
+	})
+}
+
+// TestImportData verifies that importing a data file of the package returns
+// its content, decoded with the codec of its extension, and that the decoded
+// value is cached.
+func TestImportData(t *testing.T) {
+	b := buildFS(t, fstest.MapFS{
+		"entrypoint.risor": {Data: []byte(`const settings = import("config/settings.json")
+const again = import("package://config/settings.json")
+again["extra"] = true
+again["ports"][0] = 8080
+print(settings["name"], settings["ports"], settings.get("extra", false))
+print(import("config/app.yaml")["tags"], import("config/app.yml").debug)
+print(import("config/app.toml")["server"]["port"])
+print(import("data/table.csv"))
+print(type(import("data/words.txt")), type(import("data/blob.bin")))
+print(import("lib/words").count)
+print(import("data/countries.json")["codes"])
+`)},
+		"config/settings.json": {Data: []byte(`{"name": "ren", "ports": [80, 443]}`)},
+		"data/countries.json":  {Data: []byte(`{"codes": ["US", "DE"]}`)},
+		"config/app.yaml":      {Data: []byte("tags: [a, b]\n")},
+		"config/app.yml":       {Data: []byte("debug: true\n")},
+		"config/app.toml":      {Data: []byte("[server]\nport = 8080\n")},
+		"data/table.csv":       {Data: []byte("a,b\n1,2\n")},
+		"data/words.txt":       {Data: []byte("one\ntwo\n")},
+		"data/blob.bin":        {Data: []byte{0xff, 0xfe}},
+		"lib/words.risor":      {Data: []byte("const count = len(import(\"../data/words.txt\").split(\"\\n\"))\n")},
+	})
+
+	stdout := &bytes.Buffer{}
+	opts := append(stdOptions(), ren.WithStdout(&bufferFile{Buffer: stdout}))
+	err := ren.RunBytes(context.Background(), b, opts...)
+	require.NoError(t, err)
+	require.Equal(t, "ren [80, 443] false\n"+
+		"[\"a\", \"b\"] true\n"+
+		"8080\n"+
+		"[[\"a\", \"b\"], [\"1\", \"2\"]]\n"+
+		"string bytes\n"+
+		"3\n"+
+		"[\"US\", \"DE\"]\n", stdout.String())
+}
+
+// TestEmbed verifies that a package embedded in an executable with Embed is
+// found and run by RunEmbeddedFile, that embedding again replaces it and that
+// a recorded manifest restriction applies.
+func TestEmbed(t *testing.T) {
+	exe := []byte("\x7fELF host executable")
+	embed := func(t *testing.T, exe, pkg []byte, opts ...ren.Option) string {
+		t.Helper()
+		var buf bytes.Buffer
+		err := ren.Embed(&buf, bytes.NewReader(exe), int64(len(exe)), bytes.NewReader(pkg), int64(len(pkg)), opts...)
+		require.NoError(t, err)
+		pth := filepath.Join(t.TempDir(), "tool")
+		require.NoError(t, os.WriteFile(pth, buf.Bytes(), 0755))
+		return pth
+	}
+	run := func(t *testing.T, pth string) (string, error) {
+		t.Helper()
+		stdout := &bytes.Buffer{}
+		opts := append(stdOptions(), ren.WithStdout(&bufferFile{Buffer: stdout}), ren.WithArgs([]string{"a", "b"}))
+		err := ren.RunEmbeddedFile(context.Background(), pth, opts...)
+		return stdout.String(), err
+	}
+
+	hello := buildFS(t, fstest.MapFS{
+		"entrypoint.risor": {Data: []byte("print(\"hello\", import(\"builtin://os\").args())\n")},
+		"ren.toml":         {Data: []byte("[requires]\nmodules = [\"os\"]\nbuiltins = [\"import\", \"print\"]\n")},
+	})
+	bye := buildFS(t, fstest.MapFS{
+		"entrypoint.risor": {Data: []byte("print(\"bye\")\n")},
+	})
+
+	t.Run("run", func(t *testing.T) {
+		out, err := run(t, embed(t, exe, hello))
+		require.NoError(t, err)
+		require.Equal(t, "hello [\"a\", \"b\"]\n", out)
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		b, err := os.ReadFile(embed(t, exe, hello))
+		require.NoError(t, err)
+		pth := embed(t, b, bye)
+		out, err := run(t, pth)
+		require.NoError(t, err)
+		require.Equal(t, "bye\n", out)
+
+		b, err = os.ReadFile(pth)
+		require.NoError(t, err)
+		require.True(t, bytes.HasPrefix(b, exe))
+		require.Len(t, b, len(exe)+len(bye)+24)
+	})
+
+	t.Run("restricted", func(t *testing.T) {
+		restricted := buildFS(t, fstest.MapFS{
+			"entrypoint.risor": {Data: []byte("import(\"builtin://fs\")\n")},
+			"ren.toml":         {Data: []byte("[requires]\nmodules = [\"os\"]\nbuiltins = [\"import\"]\n")},
+		})
+		_, err := run(t, embed(t, exe, restricted))
+		require.NoError(t, err)
+		_, err = run(t, embed(t, exe, restricted, ren.WithManifestRestriction(true)))
+		require.ErrorContains(t, err, "no such built-in module")
+	})
+
+	t.Run("not embedded", func(t *testing.T) {
+		pth := filepath.Join(t.TempDir(), "tool")
+		require.NoError(t, os.WriteFile(pth, exe, 0755))
+		_, err := run(t, pth)
+		require.ErrorIs(t, err, ren.ErrNotEmbedded)
+
+		_, err = run(t, filepath.Join(t.TempDir(), "missing"))
+		require.ErrorIs(t, err, ren.ErrNotEmbedded)
+		require.ErrorIs(t, err, fs.ErrNotExist)
+
+		err = ren.RunEmbedded(context.Background(), stdOptions()...)
+		require.ErrorIs(t, err, ren.ErrNotEmbedded)
+	})
+
+	t.Run("invalid package", func(t *testing.T) {
+		err := ren.Embed(&bytes.Buffer{}, bytes.NewReader(exe), int64(len(exe)), bytes.NewReader(exe), int64(len(exe)))
+		require.Error(t, err)
+	})
+}
+
+// TestMemFS verifies the semantics of MemFS: errors, permissions,
+// modification times and symbolic links, its use as an fs.FS and as the file
+// filesystem of a run.
+func TestMemFS(t *testing.T) {
+	t.Run("fs.FS", func(t *testing.T) {
+		mem, err := ren.NewMemFSFrom(fstest.MapFS{
+			"a.txt":       {Data: []byte("a")},
+			"dir/b.txt":   {Data: []byte("bb"), Mode: 0600},
+			"dir/sub/c":   {Data: []byte("ccc")},
+			"empty":       {Mode: fs.ModeDir | 0700},
+			"dir/link":    {Data: []byte("../a.txt"), Mode: fs.ModeSymlink},
+			"dir/sub/.hi": {Data: []byte{}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, fstest.TestFS(mem.FS(), "a.txt", "dir/b.txt", "dir/sub/c", "empty", "dir/link"))
+
+		info, err := mem.Stat("dir/b.txt")
+		require.NoError(t, err)
+		require.Equal(t, fs.FileMode(0600), info.Mode())
+		b, err := mem.ReadFile("/dir/link")
+		require.NoError(t, err)
+		require.Equal(t, "a", string(b))
+	})
+
+	t.Run("map", func(t *testing.T) {
+		mem, err := ren.NewMemFSFromMap(map[string][]byte{
+			"etc/app.conf": []byte("x"),
+			"tmp/":         nil,
+		})
+		require.NoError(t, err)
+		entries, err := mem.ReadDir("/")
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.Equal(t, "etc", entries[0].Name())
+		require.True(t, entries[1].IsDir())
+
+		_, err = ren.NewMemFSFromMap(map[string][]byte{"a": nil, "a/b": nil})
+		require.ErrorIs(t, err, syscall.ENOTDIR)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		mem := ren.NewMemFS()
+		require.NoError(t, mem.MkdirAll("/a/b", 0755))
+		require.NoError(t, mem.WriteFile("/a/b/f", []byte("data"), 0644))
+
+		require.ErrorIs(t, mem.Mkdir("/a", 0755), fs.ErrExist)
+		require.ErrorIs(t, mem.Mkdir("/x/y", 0755), fs.ErrNotExist)
+		require.ErrorIs(t, mem.Mkdir("/a/b/f/g", 0755), syscall.ENOTDIR)
+		require.ErrorIs(t, mem.MkdirAll("/a/b/f/g", 0755), syscall.ENOTDIR)
+		require.ErrorIs(t, mem.Remove("/a"), fs.ErrExist)
+		require.ErrorIs(t, mem.Remove("/missing"), fs.ErrNotExist)
+		_, err := mem.ReadFile("/a")
+		require.ErrorIs(t, err, syscall.EISDIR)
+		_, err = mem.ReadDir("/a/b/f")
+		require.ErrorIs(t, err, syscall.ENOTDIR)
+		_, err = mem.Stat("/a/missing")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		_, err = mem.OpenFile("/a/b/f", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		require.ErrorIs(t, err, fs.ErrExist)
+		_, err = mem.OpenFile("/a", os.O_WRONLY, 0)
+		require.ErrorIs(t, err, syscall.EISDIR)
+		require.ErrorIs(t, mem.Rename("/a", "/a/b/c"), syscall.EINVAL)
+		require.ErrorIs(t, mem.Rename("/a/b/f", "/a"), syscall.EISDIR)
+
+		require.NoError(t, mem.RemoveAll("/missing"))
+		require.NoError(t, mem.RemoveAll("/a"))
+		_, err = mem.Stat("/a/b/f")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("files", func(t *testing.T) {
+		mem := ren.NewMemFS()
+		f, err := mem.OpenFile("/log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		require.NoError(t, err)
+		_, err = f.Write([]byte("one\n"))
+		require.NoError(t, err)
+		_, err = f.Read(make([]byte, 1))
+		require.ErrorIs(t, err, fs.ErrPermission)
+		require.NoError(t, f.Close())
+		require.ErrorIs(t, f.Close(), fs.ErrClosed)
+
+		f, err = mem.OpenFile("/log", os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, err = f.Write([]byte("two\n"))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		b, err := mem.ReadFile("/log")
+		require.NoError(t, err)
+		require.Equal(t, "one\ntwo\n", string(b))
+
+		require.NoError(t, mem.WriteFile("/log", []byte("x"), 0))
+		info, err := mem.Stat("/log")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), info.Size())
+		require.Equal(t, fs.FileMode(0644), info.Mode())
+	})
+
+	t.Run("permissions", func(t *testing.T) {
+		mem := ren.NewMemFS()
+		require.NoError(t, mem.WriteFile("/ro", []byte("x"), 0444))
+		require.ErrorIs(t, mem.WriteFile("/ro", []byte("y"), 0644), fs.ErrPermission)
+		require.NoError(t, mem.WriteFile("/wo", []byte("x"), 0200))
+		_, err := mem.ReadFile("/wo")
+		require.ErrorIs(t, err, fs.ErrPermission)
+
+		require.NoError(t, mem.Mkdir("/sealed", 0555))
+		require.ErrorIs(t, mem.WriteFile("/sealed/f", nil, 0644), fs.ErrPermission)
+		require.ErrorIs(t, mem.Rename("/ro", "/sealed/ro"), fs.ErrPermission)
+	})
+
+	t.Run("rename and mod times", func(t *testing.T) {
+		mem := ren.NewMemFS()
+		require.NoError(t, mem.MkdirAll("/src/dir", 0755))
+		require.NoError(t, mem.Mkdir("/dst", 0755))
+		before, err := mem.Stat("/dst")
+		require.NoError(t, err)
+
+		time.Sleep(time.Millisecond)
+		require.NoError(t, mem.Rename("/src/dir", "/dst/dir"))
+		after, err := mem.Stat("/dst")
+		require.NoError(t, err)
+		require.True(t, after.ModTime().After(before.ModTime()))
+
+		_, err = mem.Stat("/src/dir")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		info, err := mem.Stat("/dst/dir")
+		require.NoError(t, err)
+		require.True(t, info.IsDir())
+	})
+
+	t.Run("symlinks", func(t *testing.T) {
+		mem := ren.NewMemFS()
+		require.NoError(t, mem.MkdirAll("/data/v1", 0755))
+		require.NoError(t, mem.WriteFile("/data/v1/f", []byte("v1"), 0644))
+		require.NoError(t, mem.Symlink("v1", "/data/current"))
+		require.ErrorIs(t, mem.Symlink("v1", "/data/current"), fs.ErrExist)
+
+		b, err := mem.ReadFile("/data/current/f")
+		require.NoError(t, err)
+		require.Equal(t, "v1", string(b))
+		entries, err := mem.ReadDir("/data")
+		require.NoError(t, err)
+		require.Equal(t, fs.ModeSymlink, entries[0].Type())
+
+		require.NoError(t, mem.Symlink("/loop", "/loop"))
+		_, err = mem.Stat("/loop")
+		require.ErrorIs(t, err, syscall.ELOOP)
+
+		require.NoError(t, mem.Remove("/data/current"))
+		_, err = mem.Stat("/data/v1/f")
+		require.NoError(t, err)
+	})
+
+	t.Run("temp dirs", func(t *testing.T) {
+		mem := ren.NewMemFS()
+		dir1, err := mem.MkdirTemp("", "run-*.d")
+		require.NoError(t, err)
+		dir2, err := mem.MkdirTemp("", "run-*.d")
+		require.NoError(t, err)
+		require.NotEqual(t, dir1, dir2)
+		require.Regexp(t, `^/tmp/run-\d+\.d$`, dir1)
+		info, err := mem.Stat(dir1)
+		require.NoError(t, err)
+		require.True(t, info.IsDir())
+
+		_, err = mem.MkdirTemp("/missing", "x")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		mem := ren.NewMemFS()
+		var wg sync.WaitGroup
+		for i := range 16 {
+			wg.Go(func() {
+				dir := "/w/" + strconv.Itoa(i)
+				require.NoError(t, mem.MkdirAll(dir, 0755))
+				require.NoError(t, mem.WriteFile(dir+"/f", []byte(dir), 0644))
+				_, err := mem.ReadDir("/w")
+				require.NoError(t, err)
+			})
+		}
+		wg.Wait()
+		entries, err := mem.ReadDir("/w")
+		require.NoError(t, err)
+		require.Len(t, entries, 16)
+	})
+
+	t.Run("run", func(t *testing.T) {
+		mem, err := ren.NewMemFSFromMap(map[string][]byte{"/in.txt": []byte("input")})
+		require.NoError(t, err)
+		srcDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, "entrypoint.risor"), []byte(`let fs = import("builtin://fs")
+fs.write_file("file:///out.txt", fs.read_file("file:///in.txt") + "!", 0644)
+`), 0644))
+
+		require.NoError(t, packAndRun(t, srcDir, ren.WithFilesystem("file", mem)))
+		b, err := fs.ReadFile(mem.FS(), "out.txt")
+		require.NoError(t, err)
+		require.Equal(t, "input!", string(b))
+	})
+}
+
+// TestSubFS verifies that NewSubFS confines a filesystem to a subtree, over
+// the host filesystem and over a MemFS: paths are resolved against the root,
+// and climbing above it, directly or through a symbolic link, is denied. A run
+// given WithWorkingDir resolves relative paths within the root.
+func TestSubFS(t *testing.T) {
+	hostDir := t.TempDir()
+	root := filepath.Join(hostDir, "root")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "data"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "data", "in.txt"), []byte("in"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(hostDir, "secret.txt"), []byte("secret"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(hostDir, "secret.txt"), filepath.Join(root, "abs")))
+	require.NoError(t, os.Symlink("../secret.txt", filepath.Join(root, "rel")))
+	require.NoError(t, os.Symlink("data", filepath.Join(root, "inside")))
+
+	mem, err := ren.NewMemFSFromMap(map[string][]byte{
+		"/root/data/in.txt": []byte("in"),
+		"/secret.txt":       []byte("secret"),
+	})
+	require.NoError(t, err)
+	require.NoError(t, mem.Symlink("/secret.txt", "/root/abs"))
+	require.NoError(t, mem.Symlink("../secret.txt", "/root/rel"))
+	require.NoError(t, mem.Symlink("data", "/root/inside"))
+
+	tests := []struct {
+		name string
+		fs   ren.FS
+	}{
+		{name: "local", fs: ren.NewSubFS(ren.NewLocalFS(), root)},
+		{name: "mem", fs: ren.NewSubFS(mem, "/root")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := tt.fs
+			for _, name := range []string{"/data/in.txt", "data/in.txt", "/data/../data/in.txt", "/inside/in.txt"} {
+				b, err := sub.ReadFile(name)
+				require.NoError(t, err, name)
+				require.Equal(t, "in", string(b))
+			}
+
+			for _, name := range []string{"../secret.txt", "/data/../../secret.txt", "/abs", "/rel"} {
+				_, err := sub.ReadFile(name)
+				require.ErrorIs(t, err, fs.ErrPermission, name)
+				_, err = sub.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0644)
+				require.ErrorIs(t, err, fs.ErrPermission, name)
+			}
+			_, err := sub.ReadFile("/secret.txt")
+			require.ErrorIs(t, err, fs.ErrNotExist)
+			require.ErrorIs(t, sub.Remove("/"), fs.ErrPermission)
+			require.ErrorIs(t, sub.Rename("/data", "../data"), fs.ErrPermission)
+
+			require.NoError(t, sub.WriteFile("/out.txt", []byte("out"), 0644))
+			require.NoError(t, sub.Symlink("/out.txt", "/data/link"))
+			b, err := sub.ReadFile("/data/link")
+			require.NoError(t, err)
+			require.Equal(t, "out", string(b))
+
+			dir, err := sub.MkdirTemp("", "run-*")
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(dir, "/tmp/run-"), dir)
+			info, err := sub.Stat(dir)
+			require.NoError(t, err)
+			require.True(t, info.IsDir())
+
+			entries, err := sub.ReadDir("/")
+			require.NoError(t, err)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			require.Equal(t, []string{"abs", "data", "inside", "out.txt", "rel", "tmp"}, names)
+		})
+	}
+
+	t.Run("working directory", func(t *testing.T) {
+		// Relative paths resolve against the working directory given to the
+		// run, not against the host's.
+		b := buildFS(t, fstest.MapFS{
+			"entrypoint.risor": {Data: []byte(`const os = import("builtin://os")
+const fs = import("builtin://fs")
+print(os.getwd(), string(fs.read_file("data/in.txt")))
+`)},
+		})
+		stdout := &bytes.Buffer{}
+		opts := append(stdOptions(),
+			ren.WithStdout(&bufferFile{Buffer: stdout}),
+			ren.WithFilesystem("file", ren.NewSubFS(ren.NewLocalFS(), root)),
+			ren.WithWorkingDir("/"),
+		)
+		require.NoError(t, ren.RunBytes(context.Background(), b, opts...))
+		require.Equal(t, "/ in\n", stdout.String())
+	})
+
+	b, err := os.ReadFile(filepath.Join(root, "out.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "out", string(b))
+	target, err := os.Readlink(filepath.Join(root, "data", "link"))
+	require.NoError(t, err)
+	require.Equal(t, "../out.txt", target)
+}
+
+func TestOverlayFS(t *testing.T) {
+	files := map[string][]byte{
+		"/etc/app.conf":     []byte("debug=false"),
+		"/etc/hosts":        []byte("localhost"),
+		"/var/log/old.log":  []byte("old"),
+		"/var/cache/a.bin":  []byte("a"),
+		"/home/user/readme": []byte("readme"),
+	}
+	lower, err := ren.NewMemFSFromMap(files)
+	require.NoError(t, err)
+	upper := ren.NewMemFS()
+	overlay := ren.NewOverlayFS(lower, upper)
+
+	b, err := overlay.ReadFile("/etc/hosts")
+	require.NoError(t, err)
+	require.Equal(t, "localhost", string(b))
+
+	require.NoError(t, overlay.WriteFile("/etc/app.conf", []byte("debug=true"), 0644))
+	require.NoError(t, overlay.WriteFile("/etc/new.conf", []byte("new"), 0644))
+	require.NoError(t, overlay.WriteFile("/etc/hosts", []byte("localhost"), 0644))
+	f, err := overlay.OpenFile("/var/log/old.log", os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("er"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	b, err = overlay.ReadFile("/var/log/old.log")
+	require.NoError(t, err)
+	require.Equal(t, "older", string(b))
+
+	require.ErrorIs(t, overlay.Remove("/var/cache"), syscall.ENOTEMPTY)
+	require.NoError(t, overlay.Remove("/var/cache/a.bin"))
+	require.NoError(t, overlay.Remove("/var/cache"))
+	_, err = overlay.Stat("/var/cache")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.NoError(t, overlay.Mkdir("/var/cache", 0755))
+	entries, err := overlay.ReadDir("/var/cache")
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.NoError(t, overlay.Rename("/home/user", "/home/admin"))
+	b, err = overlay.ReadFile("/home/admin/readme")
+	require.NoError(t, err)
+	require.Equal(t, "readme", string(b))
+	_, err = overlay.Stat("/home/user/readme")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.ErrorIs(t, overlay.Rename("/home", "/home/admin/home"), syscall.EINVAL)
+
+	require.NoError(t, overlay.Symlink("app.conf", "/etc/link"))
+	b, err = overlay.ReadFile("/etc/link")
+	require.NoError(t, err)
+	require.Equal(t, "debug=true", string(b))
+
+	entries, err = overlay.ReadDir("/etc")
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.Equal(t, []string{"app.conf", "hosts", "link", "new.conf"}, names)
+
+	changes, err := overlay.Changes()
+	require.NoError(t, err)
+	require.Equal(t, []ren.Change{
+		{Path: "/etc/app.conf", Kind: ren.ChangeModify},
+		{Path: "/etc/link", Kind: ren.ChangeAdd},
+		{Path: "/etc/new.conf", Kind: ren.ChangeAdd},
+		{Path: "/home/admin", Kind: ren.ChangeAdd},
+		{Path: "/home/admin/readme", Kind: ren.ChangeAdd},
+		{Path: "/home/user", Kind: ren.ChangeDelete},
+		{Path: "/var/cache", Kind: ren.ChangeDelete},
+		{Path: "/var/cache", Kind: ren.ChangeAdd},
+		{Path: "/var/log/old.log", Kind: ren.ChangeModify},
+	}, changes)
+	require.Equal(t, "delete /home/user", changes[5].String())
+
+	for name, data := range files {
+		b, err := lower.ReadFile(name)
+		require.NoError(t, err)
+		require.Equal(t, data, b)
+	}
+
+	require.NoError(t, overlay.Apply(lower))
+	for _, name := range []string{"/etc/app.conf", "/etc/hosts", "/etc/link", "/etc/new.conf", "/var/log/old.log", "/home/admin/readme"} {
+		want, err := overlay.ReadFile(name)
+		require.NoError(t, err)
+		b, err := lower.ReadFile(name)
+		require.NoError(t, err, name)
+		require.Equal(t, want, b, name)
+	}
+	_, err = lower.Stat("/home/user")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	entries, err = lower.ReadDir("/var/cache")
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	t.Run("write through symlink", func(t *testing.T) {
+		for _, flag := range []int{os.O_WRONLY | os.O_APPEND, os.O_WRONLY | os.O_TRUNC} {
+			lower, err := ren.NewMemFSFromMap(map[string][]byte{"/b": []byte("x")})
+			require.NoError(t, err)
+			require.NoError(t, lower.Symlink("b", "/a"))
+			overlay := ren.NewOverlayFS(lower, ren.NewMemFS())
+
+			f, err := overlay.OpenFile("/a", flag, 0)
+			require.NoError(t, err)
+			_, err = f.Write([]byte("y"))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			want := "xy"
+			if flag&os.O_TRUNC != 0 {
+				want = "y"
+			}
+			b, err := overlay.ReadFile("/b")
+			require.NoError(t, err)
+			require.Equal(t, want, string(b))
+			info, err := overlay.Lstat("/a")
+			require.NoError(t, err)
+			require.NotZero(t, info.Mode()&fs.ModeSymlink)
+
+			changes, err := overlay.Changes()
+			require.NoError(t, err)
+			require.Equal(t, []ren.Change{{Path: "/b", Kind: ren.ChangeModify}}, changes)
+			b, err = lower.ReadFile("/b")
+			require.NoError(t, err)
+			require.Equal(t, "x", string(b))
+		}
+	})
+}
+
+// buildFS builds the package fsys in memory with the standard builtins and
+// modules, and any further options, and returns it.
+func buildFS(t *testing.T, fsys fstest.MapFS, opts ...packager.Option) []byte {
+	t.Helper()
+
+	buildOpts := slices.Clone(opts)
 	for _, o := range builtins.Builtins() {
-		runOpts = append(runOpts, ren.WithBuiltin(o))
+		buildOpts = append(buildOpts, packager.WithBuiltin(o))
+	}
+	for _, o := range modules.Modules() {
+		buildOpts = append(buildOpts, packager.WithModule(o))
+	}
+	var buf bytes.Buffer
+	require.NoError(t, packager.BuildFS(context.Background(), fsys, &buf, buildOpts...))
+
+	return buf.Bytes()
+}
+
+// loadProgram loads the package file at pth.
+func loadProgram(t *testing.T, pth string) *ren.Program {
+	t.Helper()
+
+	b, err := os.ReadFile(pth)
+	require.NoError(t, err)
+	prog, err := ren.Load(bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+
+	return prog
+}
+
+// stdOptions returns the options registering the standard builtins and
+// modules.
+func stdOptions() []ren.Option {
+	var opts []ren.Option
+	for _, o := range builtins.Builtins() {
+		opts = append(opts, ren.WithBuiltin(o))
+	}
+	for _, o := range modules.Modules() {
+		opts = append(opts, ren.WithModule(o))
 	}
-	return ren.RunFile(context.Background(), out, runOpts...)
+	return opts
 }
@@ -0,0 +1,168 @@
+package ren
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+
+	"github.com/foohq/ren/packager"
+)
+
+// importBuiltin is the name of the builtin that imports modules.
+const importBuiltin = "import"
+
+// ErrUnsatisfied is returned when the runtime does not satisfy the
+// requirements a package declares in its manifest.
+var ErrUnsatisfied = errors.New("package requirements not satisfied")
+
+// RequirementError describes the requirements of a package that the runtime
+// does not satisfy. It is returned by Run before any script code runs, and
+// matches ErrUnsatisfied with errors.Is.
+type RequirementError struct {
+	// Package is the name of the package, if its manifest declares one.
+	Package string
+	// Ren is the minimum version of Ren the package requires. It is set only
+	// if Version, without any pre-release suffix, is lower.
+	Ren string
+	// Modules lists the required modules that are not registered with
+	// WithModule.
+	Modules []string
+	// Builtins lists the required builtins that are not registered with
+	// WithBuiltin and, under WithManifestRestriction, the builtins registered
+	// with it that the package uses but its manifest does not list.
+	Builtins []string
+}
+
+// Error returns the error message.
+func (e *RequirementError) Error() string {
+	var reasons []string
+	if e.Ren != "" {
+		reasons = append(reasons, fmt.Sprintf("requires ren %s or later, running %s", e.Ren, Version()))
+	}
+	if len(e.Modules) > 0 {
+		reasons = append(reasons, "missing modules "+strings.Join(e.Modules, ", "))
+	}
+	if len(e.Builtins) > 0 {
+		reasons = append(reasons, "missing builtins "+strings.Join(e.Builtins, ", "))
+	}
+
+	msg := fmt.Sprintf("%v: %s", ErrUnsatisfied, strings.Join(reasons, "; "))
+	if e.Package != "" {
+		msg = fmt.Sprintf("package %q: %s", e.Package, msg)
+	}
+	return msg
+}
+
+// Unwrap returns ErrUnsatisfied.
+func (e *RequirementError) Unwrap() error {
+	return ErrUnsatisfied
+}
+
+// check verifies, before any script code runs, that the package is signed by a
+// trusted key if WithTrustedKeys is given and that the runtime satisfies the
+// requirements of the package.
+func (rt *runtime) check() error {
+	err := rt.prog.verify(rt.opts.trustedKeys)
+	if err != nil {
+		return err
+	}
+	return rt.checkRequirements()
+}
+
+// checkRequirements returns a *RequirementError if the runtime lacks anything
+// the package manifest requires.
+func (rt *runtime) checkRequirements() error {
+	manifest := rt.prog.manifest
+	if manifest == nil {
+		return nil
+	}
+
+	var reqErr RequirementError
+	req := manifest.Requires
+	if req.Ren != "" && packager.CompareVersions(releaseVersion(Version()), req.Ren) < 0 {
+		reqErr.Ren = req.Ren
+	}
+	for _, name := range req.Modules {
+		if _, ok := rt.importer.builtins[name]; !ok {
+			reqErr.Modules = append(reqErr.Modules, name)
+		}
+	}
+	for _, name := range req.Builtins {
+		if _, ok := rt.env[name]; !ok {
+			reqErr.Builtins = append(reqErr.Builtins, name)
+		}
+	}
+	if rt.opts.manifestRestriction {
+		registered := rt.opts.Builtins()
+		for _, name := range rt.prog.globalsUsed() {
+			_, provided := rt.env[name]
+			if _, ok := registered[name]; ok && !provided {
+				reqErr.Builtins = append(reqErr.Builtins, name)
+			}
+		}
+	}
+
+	if reqErr.Ren == "" && len(reqErr.Modules) == 0 && len(reqErr.Builtins) == 0 {
+		return nil
+	}
+	reqErr.Package = manifest.Name
+	return &reqErr
+}
+
+// releaseVersion returns the semantic version v without its pre-release and
+// build metadata, so that a pre-release of Ren satisfies a package requiring
+// the release it leads to.
+func releaseVersion(v string) string {
+	v, _, _ = strings.Cut(v, "+")
+	v, _, _ = strings.Cut(v, "-")
+	return v
+}
+
+// restrict returns the builtins and modules the package manifest requires, out
+// of those given (see WithManifestRestriction). The import builtin is always
+// kept, since modules cannot be imported without it.
+func (p *Program) restrict(builtins map[string]any, modules map[string]*object.Module) (map[string]any, map[string]*object.Module) {
+	if p.manifest == nil {
+		return builtins, modules
+	}
+	req := p.manifest.Requires
+	if req.Builtins != nil {
+		builtins = filterKeys(builtins, append([]string{importBuiltin}, req.Builtins...))
+	}
+	if req.Modules != nil {
+		modules = filterKeys(modules, req.Modules)
+	}
+	return builtins, modules
+}
+
+// globalsUsed returns the sorted names of the globals the modules of the
+// program and of its dependencies use without defining them.
+func (p *Program) globalsUsed() []string {
+	names := make(map[string]bool)
+	for _, code := range p.modules {
+		for _, name := range packager.GlobalsUsed(code) {
+			names[name] = true
+		}
+	}
+	for _, dep := range p.deps {
+		for _, name := range dep.globalsUsed() {
+			names[name] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(names))
+}
+
+// filterKeys returns the entries of m whose key is one of keys.
+func filterKeys[V any](m map[string]V, keys []string) map[string]V {
+	result := make(map[string]V, len(keys))
+	for _, key := range keys {
+		if v, ok := m[key]; ok {
+			result[key] = v
+		}
+	}
+	return result
+}
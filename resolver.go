@@ -0,0 +1,102 @@
+package ren
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/deepnoodle-ai/risor/v2/pkg/bytecode"
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+
+	"github.com/foohq/ren/packager"
+)
+
+// ModuleResolver resolves the imports of a URL scheme registered with
+// WithResolver, e.g. to serve modules from a database, a Git checkout or a
+// directory of Risor source.
+type ModuleResolver interface {
+	// ResolveModule returns the module name refers to, given as imported,
+	// e.g. "db://lib/util". It returns an error wrapping fs.ErrNotExist if
+	// there is no such module.
+	ResolveModule(ctx context.Context, name string) (*ResolvedModule, error)
+}
+
+// ModuleResolverFunc adapts a function to the ModuleResolver interface.
+type ModuleResolverFunc func(ctx context.Context, name string) (*ResolvedModule, error)
+
+// ResolveModule calls f(ctx, name).
+func (f ModuleResolverFunc) ResolveModule(ctx context.Context, name string) (*ResolvedModule, error) {
+	return f(ctx, name)
+}
+
+// ResolvedModule is a module returned by a ModuleResolver, either as source or
+// compiled.
+type ResolvedModule struct {
+	// Source is the Risor source of the module. It is compiled when the module
+	// is imported, with the runtime's builtins as pre-declared globals.
+	Source string
+	// Code is the compiled module, as written to a package by the packager or
+	// returned by packager.CompileModule. It takes precedence over Source.
+	Code []byte
+}
+
+// importResolved imports the module name of the given scheme, once, from
+// resolver. Its functions are bound to it, so that the relative imports they
+// make resolve against name rather than against the importing module.
+func (imp *Importer) importResolved(ctx context.Context, name, scheme string, resolver ModuleResolver) (object.Object, error) {
+	return imp.importOnce(name, name, func() (object.Object, error) {
+		mod, err := resolver.ResolveModule(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot import %q: %w", name, err)
+		}
+		if mod == nil {
+			return nil, fmt.Errorf("cannot import %q: %w", name, fs.ErrNotExist)
+		}
+
+		code, err := imp.compileResolved(ctx, name, mod)
+		if err != nil {
+			return nil, fmt.Errorf("cannot import %q: %w", name, err)
+		}
+
+		_, pth, _ := strings.Cut(name, "://")
+		return imp.runModule(ctx, name, code, moduleScope{importer: imp, scheme: scheme, path: pth})
+	})
+}
+
+// compileResolved returns the bytecode of the resolved module name, compiling
+// its source if it is not compiled.
+func (imp *Importer) compileResolved(ctx context.Context, name string, mod *ResolvedModule) (*bytecode.Code, error) {
+	b := mod.Code
+	if b == nil {
+		var opts []packager.Option
+		for _, key := range slices.Sorted(maps.Keys(imp.env)) {
+			if builtin, ok := imp.env[key].(*object.Builtin); ok {
+				opts = append(opts, packager.WithBuiltin(builtin))
+			}
+		}
+
+		var err error
+		b, err = packager.CompileModule(ctx, name, mod.Source, opts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return packager.DecodeModule(b)
+}
+
+// resolvedPath resolves the relative import name against the path, e.g.
+// "lib/log", of the resolved module importing it. It rejects paths that escape
+// the root of the resolver.
+func resolvedPath(base, name string) (string, error) {
+	pth := path.Join(path.Dir(base), name)
+	if pth == ".." || strings.HasPrefix(pth, "../") {
+		return "", errors.New("import path escapes the root of the scheme")
+	}
+	return pth, nil
+}
@@ -0,0 +1,606 @@
+package ren
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// linkFS is implemented by filesystems that can report symbolic links, such as
+// MemFS. The filesystem NewSubFS returns over one resolves the links itself,
+// so that none leads out of its root.
+type linkFS interface {
+	Lstat(name string) (FileInfo, error)
+	Readlink(name string) (string, error)
+}
+
+// NewSubFS returns a filesystem that confines every operation to the subtree
+// of base rooted at root, like a chroot: absolute paths are resolved against
+// root, and a path that climbs above it with ".." fails with fs.ErrPermission,
+// as does following a symbolic link that leads out of it. Absolute link
+// targets given to Symlink are stored relative to the link, so that they stay
+// within root.
+//
+// Over the host filesystem, exactly as returned by NewLocalFS, root is a host
+// directory and the operations go through os.Root, which also guards against
+// links that are swapped while a path is resolved. Over any other filesystem,
+// including one that wraps the host filesystem, root is a path of that
+// filesystem and the confinement is lexical: symbolic links are resolved by
+// NewSubFS if the filesystem has Lstat and Readlink methods, as MemFS does, and
+// are assumed not to exist otherwise, so a link in such a filesystem can lead
+// out of root.
+func NewSubFS(base FS, root string) FS {
+	if _, ok := base.(*localFS); ok {
+		return &rootFS{dir: root}
+	}
+	return &subFS{
+		base: base,
+		root: path.Clean("/" + root),
+	}
+}
+
+var (
+	_ FS     = (*subFS)(nil)
+	_ linkFS = (*subFS)(nil)
+)
+
+// subFS confines a filesystem to the subtree at root.
+type subFS struct {
+	base FS
+	root string
+}
+
+func (f *subFS) Mkdir(name string, perm FileMode) error {
+	pth, err := f.resolve(name, false)
+	if err != nil {
+		return err
+	}
+	return f.base.Mkdir(pth, perm)
+}
+
+func (f *subFS) MkdirAll(path string, perm FileMode) error {
+	pth, err := f.resolve(path, true)
+	if err != nil {
+		return err
+	}
+	return f.base.MkdirAll(pth, perm)
+}
+
+// MkdirTemp creates a directory with a unique name in dir, following the rules
+// of os.MkdirTemp. An empty dir stands for "/tmp" under the root, which is
+// created if needed.
+func (f *subFS) MkdirTemp(dir, pattern string) (string, error) {
+	if dir == "" {
+		dir = "/tmp"
+		err := f.MkdirAll(dir, 0o777)
+		if err != nil {
+			return "", err
+		}
+	}
+	pth, err := f.resolve(dir, true)
+	if err != nil {
+		return "", err
+	}
+	name, err := f.base.MkdirTemp(pth, pattern)
+	if err != nil {
+		return "", err
+	}
+	rel, ok := f.rel(name)
+	if !ok {
+		return "", fs.ErrPermission
+	}
+	return "/" + rel, nil
+}
+
+func (f *subFS) OpenFile(name string, flag int, perm FileMode) (File, error) {
+	pth, err := f.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return f.base.OpenFile(pth, flag, perm)
+}
+
+func (f *subFS) ReadFile(name string) ([]byte, error) {
+	pth, err := f.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return f.base.ReadFile(pth)
+}
+
+func (f *subFS) Remove(name string) error {
+	pth, err := f.resolveEntry(name)
+	if err != nil {
+		return err
+	}
+	return f.base.Remove(pth)
+}
+
+func (f *subFS) RemoveAll(path string) error {
+	pth, err := f.resolveEntry(path)
+	if err != nil {
+		return err
+	}
+	return f.base.RemoveAll(pth)
+}
+
+func (f *subFS) Rename(oldPath, newPath string) error {
+	oldPth, err := f.resolveEntry(oldPath)
+	if err != nil {
+		return err
+	}
+	newPth, err := f.resolveEntry(newPath)
+	if err != nil {
+		return err
+	}
+	return f.base.Rename(oldPth, newPth)
+}
+
+func (f *subFS) Stat(name string) (FileInfo, error) {
+	pth, err := f.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return f.base.Stat(pth)
+}
+
+func (f *subFS) Symlink(oldName, newName string) error {
+	target, err := linkTarget(oldName, newName)
+	if err != nil {
+		return err
+	}
+	pth, err := f.resolve(newName, false)
+	if err != nil {
+		return err
+	}
+	return f.base.Symlink(target, pth)
+}
+
+func (f *subFS) WriteFile(name string, data []byte, perm FileMode) error {
+	pth, err := f.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	return f.base.WriteFile(pth, data, perm)
+}
+
+func (f *subFS) ReadDir(name string) ([]DirEntry, error) {
+	pth, err := f.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return f.base.ReadDir(pth)
+}
+
+func (f *subFS) Lstat(name string) (FileInfo, error) {
+	pth, err := f.resolve(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return lstatFS(f.base, pth)
+}
+
+func (f *subFS) Readlink(name string) (string, error) {
+	pth, err := f.resolve(name, false)
+	if err != nil {
+		return "", err
+	}
+	links, ok := f.base.(linkFS)
+	if !ok {
+		return "", fs.ErrInvalid
+	}
+	target, err := links.Readlink(pth)
+	if err != nil {
+		return "", err
+	}
+	if rel, ok := f.rel(target); ok && path.IsAbs(target) {
+		return "/" + rel, nil
+	}
+	return target, nil
+}
+
+// resolve returns the path in the base filesystem of name, with the symbolic
+// links on the way resolved if the base filesystem reports them. A link that
+// is the last element of name is resolved only if follow is set.
+func (f *subFS) resolve(name string, follow bool) (string, error) {
+	pth, err := subPath(name)
+	if err != nil {
+		return "", err
+	}
+	links, ok := f.base.(linkFS)
+	if !ok {
+		return path.Join(f.root, pth), nil
+	}
+	pth, err = resolveLinks(pth, follow, linkTree{
+		lstat: func(name string) (FileInfo, error) {
+			return links.Lstat(path.Join(f.root, name))
+		},
+		readlink: func(name string) (string, error) {
+			return links.Readlink(path.Join(f.root, name))
+		},
+		abs: f.rel,
+	})
+	if err != nil {
+		return "", err
+	}
+	return path.Join(f.root, pth), nil
+}
+
+// resolveEntry resolves name like resolve, without following a link that is
+// its last element, and refuses the root itself.
+func (f *subFS) resolveEntry(name string) (string, error) {
+	pth, err := f.resolve(name, false)
+	if err != nil {
+		return "", err
+	}
+	if pth == f.root {
+		return "", fs.ErrPermission
+	}
+	return pth, nil
+}
+
+// rel returns the path relative to the root of the path pth of the base
+// filesystem, and whether pth is within the root.
+func (f *subFS) rel(pth string) (string, bool) {
+	pth = path.Clean("/" + pth)
+	switch {
+	case pth == f.root:
+		return "", true
+	case f.root == "/":
+		return pth[1:], true
+	case strings.HasPrefix(pth, f.root+"/"):
+		return pth[len(f.root)+1:], true
+	}
+	return "", false
+}
+
+var (
+	_ FS     = (*rootFS)(nil)
+	_ linkFS = (*rootFS)(nil)
+)
+
+// rootFS confines the host filesystem to the directory dir with os.Root.
+type rootFS struct {
+	dir string
+}
+
+func (f *rootFS) Mkdir(name string, perm FileMode) error {
+	return f.do(name, false, func(r *os.Root, pth string) error {
+		return r.Mkdir(pth, perm)
+	})
+}
+
+func (f *rootFS) MkdirAll(path string, perm FileMode) error {
+	return f.do(path, true, func(r *os.Root, pth string) error {
+		return r.MkdirAll(pth, perm)
+	})
+}
+
+// MkdirTemp creates a directory with a unique name in dir, following the rules
+// of os.MkdirTemp. An empty dir stands for "/tmp" under the root, which is
+// created if needed.
+func (f *rootFS) MkdirTemp(dir, pattern string) (string, error) {
+	if dir == "" {
+		dir = "/tmp"
+		err := f.MkdirAll(dir, 0o777)
+		if err != nil {
+			return "", err
+		}
+	}
+	var name string
+	err := f.do(dir, true, func(r *os.Root, pth string) error {
+		var err error
+		name, err = mkdirTemp(pth, pattern, func(name string) error {
+			return rootError(r.Mkdir(name, 0o700))
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return "/" + name, nil
+}
+
+func (f *rootFS) OpenFile(name string, flag int, perm FileMode) (File, error) {
+	var file *os.File
+	err := f.do(name, true, func(r *os.Root, pth string) error {
+		var err error
+		file, err = r.OpenFile(pth, flag, perm)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (f *rootFS) ReadFile(name string) ([]byte, error) {
+	var b []byte
+	err := f.do(name, true, func(r *os.Root, pth string) error {
+		var err error
+		b, err = r.ReadFile(pth)
+		return err
+	})
+	return b, err
+}
+
+func (f *rootFS) Remove(name string) error {
+	return f.doEntry(name, func(r *os.Root, pth string) error {
+		return r.Remove(pth)
+	})
+}
+
+func (f *rootFS) RemoveAll(path string) error {
+	return f.doEntry(path, func(r *os.Root, pth string) error {
+		return r.RemoveAll(pth)
+	})
+}
+
+func (f *rootFS) Rename(oldPath, newPath string) error {
+	return f.doEntry(oldPath, func(r *os.Root, pth string) error {
+		newPth, err := rootPath(r, newPath, false)
+		if err != nil {
+			return err
+		}
+		if newPth == "." {
+			return fs.ErrPermission
+		}
+		return r.Rename(pth, newPth)
+	})
+}
+
+func (f *rootFS) Stat(name string) (FileInfo, error) {
+	var info FileInfo
+	err := f.do(name, true, func(r *os.Root, pth string) error {
+		var err error
+		info, err = r.Stat(pth)
+		return err
+	})
+	return info, err
+}
+
+func (f *rootFS) Symlink(oldName, newName string) error {
+	target, err := linkTarget(oldName, newName)
+	if err != nil {
+		return err
+	}
+	return f.do(newName, false, func(r *os.Root, pth string) error {
+		return r.Symlink(target, pth)
+	})
+}
+
+func (f *rootFS) WriteFile(name string, data []byte, perm FileMode) error {
+	return f.do(name, true, func(r *os.Root, pth string) error {
+		return r.WriteFile(pth, data, perm)
+	})
+}
+
+func (f *rootFS) ReadDir(name string) ([]DirEntry, error) {
+	var entries []DirEntry
+	err := f.do(name, true, func(r *os.Root, pth string) error {
+		var err error
+		entries, err = fs.ReadDir(r.FS(), pth)
+		return err
+	})
+	return entries, err
+}
+
+func (f *rootFS) Lstat(name string) (FileInfo, error) {
+	var info FileInfo
+	err := f.do(name, false, func(r *os.Root, pth string) error {
+		var err error
+		info, err = r.Lstat(pth)
+		return err
+	})
+	return info, err
+}
+
+func (f *rootFS) Readlink(name string) (string, error) {
+	var target string
+	err := f.do(name, false, func(r *os.Root, pth string) error {
+		var err error
+		target, err = r.Readlink(pth)
+		return err
+	})
+	return target, err
+}
+
+// do opens the root and calls fn with it and the path of name relative to it,
+// with the symbolic links on the way resolved. A link that is the last element
+// of name is resolved only if follow is set.
+func (f *rootFS) do(name string, follow bool, fn func(r *os.Root, pth string) error) error {
+	r, err := os.OpenRoot(f.dir)
+	if err != nil {
+		return errors.Unwrap(err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	pth, err := rootPath(r, name, follow)
+	if err != nil {
+		return err
+	}
+	return rootError(fn(r, pth))
+}
+
+// doEntry is like do, without following a link that is the last element of
+// name, and refuses the root itself.
+func (f *rootFS) doEntry(name string, fn func(r *os.Root, pth string) error) error {
+	return f.do(name, false, func(r *os.Root, pth string) error {
+		if pth == "." {
+			return fs.ErrPermission
+		}
+		return fn(r, pth)
+	})
+}
+
+// rootPath converts name into a path relative to r, with its symbolic links
+// resolved like NewSubFS does over other filesystems. Resolving them before
+// calling into r reports a link that leads out of the root as fs.ErrPermission;
+// os.Root itself fails with an error it does not export. A link that is swapped
+// while r resolves the path is still caught by r.
+func rootPath(r *os.Root, name string, follow bool) (string, error) {
+	pth, err := subPath(name)
+	if err != nil {
+		return "", err
+	}
+	return resolveLinks(pth, follow, linkTree{
+		lstat: func(name string) (FileInfo, error) {
+			return r.Lstat(name)
+		},
+		readlink: func(name string) (string, error) {
+			target, err := r.Readlink(name)
+			return target, rootError(err)
+		},
+		abs: func(string) (string, bool) {
+			// An absolute target is a host path, which os.Root never follows.
+			return "", false
+		},
+	})
+}
+
+// rootError strips the *fs.PathError or *os.LinkError of an os.Root operation,
+// as localFS does.
+func rootError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if inner := errors.Unwrap(err); inner != nil {
+		return inner
+	}
+	return err
+}
+
+// linkTree reads the symbolic links of a tree by their path relative to its
+// root (see resolveLinks).
+type linkTree struct {
+	lstat    func(name string) (FileInfo, error)
+	readlink func(name string) (string, error)
+	// abs converts an absolute link target into a path relative to the root,
+	// and reports whether it is within the root.
+	abs func(target string) (string, bool)
+}
+
+// resolveLinks returns the clean relative path pth of the tree with the
+// symbolic links on the way resolved. A link that is the last element of pth
+// is resolved only if follow is set. A link that leads out of the root fails
+// with fs.ErrPermission.
+func resolveLinks(pth string, follow bool, tree linkTree) (string, error) {
+	if pth == "." {
+		return pth, nil
+	}
+
+	var resolved string
+	elems := strings.Split(pth, "/")
+	for hops := 0; len(elems) > 0; {
+		next := path.Join(resolved, elems[0])
+		elems = elems[1:]
+		if len(elems) == 0 && !follow {
+			resolved = next
+			break
+		}
+		info, err := tree.lstat(next)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinks {
+			return "", syscall.ELOOP
+		}
+		target, err := tree.readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			var ok bool
+			target, ok = tree.abs(target)
+			if !ok {
+				return "", fs.ErrPermission
+			}
+		} else {
+			target = path.Join(resolved, target)
+			if escapes(target) {
+				return "", fs.ErrPermission
+			}
+		}
+		resolved = ""
+		if target != "." && target != "" {
+			elems = append(strings.Split(target, "/"), elems...)
+		}
+	}
+	if resolved == "" {
+		return ".", nil
+	}
+	return resolved, nil
+}
+
+// subPath converts name into a path relative to the root of a filesystem
+// returned by NewSubFS, "." for the root itself. Absolute paths are taken relative to the root; a path that
+// climbs above it fails with fs.ErrPermission.
+func subPath(name string) (string, error) {
+	pth := path.Clean(strings.TrimLeft(name, "/"))
+	if escapes(pth) {
+		return "", fs.ErrPermission
+	}
+	return pth, nil
+}
+
+// escapes reports whether the clean relative path pth climbs above its root.
+func escapes(pth string) bool {
+	return pth == ".." || strings.HasPrefix(pth, "../")
+}
+
+// linkTarget returns the target to store for a link at newName to oldName. An
+// absolute oldName is made relative to the directory of the link, so that it
+// resolves within the root.
+func linkTarget(oldName, newName string) (string, error) {
+	if !path.IsAbs(oldName) {
+		return oldName, nil
+	}
+	target, err := subPath(oldName)
+	if err != nil {
+		return "", err
+	}
+	link, err := subPath(newName)
+	if err != nil {
+		return "", err
+	}
+	return relPath(path.Dir(link), target), nil
+}
+
+// relPath returns the path of the clean relative path to relative to the
+// directory from.
+func relPath(from, to string) string {
+	split := func(pth string) []string {
+		if pth == "." {
+			return nil
+		}
+		return strings.Split(pth, "/")
+	}
+	fromElems, toElems := split(from), split(to)
+	i := 0
+	for i < len(fromElems) && i < len(toElems) && fromElems[i] == toElems[i] {
+		i++
+	}
+	elems := slices.Repeat([]string{".."}, len(fromElems)-i)
+	elems = append(elems, toElems[i:]...)
+	if len(elems) == 0 {
+		return "."
+	}
+	return path.Join(elems...)
+}
+
+// lstatFS returns information about name in fsys without following a link
+// that is its last element, if fsys can report links.
+func lstatFS(fsys FS, name string) (FileInfo, error) {
+	if links, ok := fsys.(linkFS); ok {
+		return links.Lstat(name)
+	}
+	return fsys.Stat(name)
+}
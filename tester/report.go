@@ -0,0 +1,232 @@
+package tester
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Summary counts the results of a test run by outcome.
+type Summary struct {
+	Passed   int
+	Failed   int
+	Skipped  int
+	Duration time.Duration
+}
+
+// Summarize counts results by outcome and adds up their durations.
+func Summarize(results []Result) Summary {
+	var s Summary
+	for _, r := range results {
+		switch r.Status {
+		case StatusPass:
+			s.Passed++
+		case StatusFail:
+			s.Failed++
+		case StatusSkip:
+			s.Skipped++
+		}
+		s.Duration += r.Duration
+	}
+	return s
+}
+
+// WriteText writes a human-readable report of results to w. Failed tests are
+// listed with their message and output; passed and skipped tests are listed
+// only if verbose is set.
+func WriteText(w io.Writer, results []Result, verbose bool) error {
+	var b strings.Builder
+	for _, r := range results {
+		if r.Status != StatusFail && !verbose {
+			continue
+		}
+		fmt.Fprintf(&b, "--- %s: %s (%s)\n", strings.ToUpper(r.Status.String()), testName(r), formatSeconds(r.Duration))
+		writeIndented(&b, r.Message)
+		writeIndented(&b, r.Output)
+	}
+
+	s := Summarize(results)
+	status := "PASS"
+	if s.Failed > 0 {
+		status = "FAIL"
+	}
+	fmt.Fprintf(&b, "%s: %d passed, %d failed, %d skipped (%s)\n", status, s.Passed, s.Failed, s.Skipped, formatSeconds(s.Duration))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes results to w as a JSON document.
+func WriteJSON(w io.Writer, results []Result) error {
+	type jsonResult struct {
+		File     string  `json:"file"`
+		Name     string  `json:"name,omitempty"`
+		Status   string  `json:"status"`
+		Duration float64 `json:"duration"`
+		Message  string  `json:"message,omitempty"`
+		Output   string  `json:"output,omitempty"`
+	}
+	type jsonReport struct {
+		Passed   int          `json:"passed"`
+		Failed   int          `json:"failed"`
+		Skipped  int          `json:"skipped"`
+		Duration float64      `json:"duration"`
+		Tests    []jsonResult `json:"tests"`
+	}
+
+	s := Summarize(results)
+	report := jsonReport{
+		Passed:   s.Passed,
+		Failed:   s.Failed,
+		Skipped:  s.Skipped,
+		Duration: s.Duration.Seconds(),
+		Tests:    make([]jsonResult, 0, len(results)),
+	}
+	for _, r := range results {
+		report.Tests = append(report.Tests, jsonResult{
+			File:     r.File,
+			Name:     r.Name,
+			Status:   r.Status.String(),
+			Duration: r.Duration.Seconds(),
+			Message:  r.Message,
+			Output:   r.Output,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// WriteJUnit writes results to w as a JUnit XML report, with one test suite
+// per test script.
+func WriteJUnit(w io.Writer, results []Result) error {
+	type junitMessage struct {
+		Message string `xml:"message,attr,omitempty"`
+		Text    string `xml:",chardata"`
+	}
+	type junitCase struct {
+		Name      string        `xml:"name,attr"`
+		Classname string        `xml:"classname,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitMessage `xml:"failure,omitempty"`
+		Skipped   *junitMessage `xml:"skipped,omitempty"`
+		SystemOut string        `xml:"system-out,omitempty"`
+	}
+	type junitSuite struct {
+		Name     string      `xml:"name,attr"`
+		Tests    int         `xml:"tests,attr"`
+		Failures int         `xml:"failures,attr"`
+		Skipped  int         `xml:"skipped,attr"`
+		Time     string      `xml:"time,attr"`
+		Cases    []junitCase `xml:"testcase"`
+	}
+	type junitReport struct {
+		XMLName  xml.Name     `xml:"testsuites"`
+		Tests    int          `xml:"tests,attr"`
+		Failures int          `xml:"failures,attr"`
+		Skipped  int          `xml:"skipped,attr"`
+		Time     string       `xml:"time,attr"`
+		Suites   []junitSuite `xml:"testsuite"`
+	}
+
+	s := Summarize(results)
+	report := junitReport{
+		Tests:    len(results),
+		Failures: s.Failed,
+		Skipped:  s.Skipped,
+		Time:     junitSeconds(s.Duration),
+	}
+
+	var suite *junitSuite
+	var suiteDuration time.Duration
+	for _, r := range results {
+		if suite == nil || suite.Name != r.File {
+			if suite != nil {
+				suite.Time = junitSeconds(suiteDuration)
+			}
+			report.Suites = append(report.Suites, junitSuite{Name: r.File})
+			suite = &report.Suites[len(report.Suites)-1]
+			suiteDuration = 0
+		}
+
+		name := r.Name
+		if name == "" {
+			name = r.File
+		}
+		c := junitCase{
+			Name:      name,
+			Classname: r.File,
+			Time:      junitSeconds(r.Duration),
+			SystemOut: r.Output,
+		}
+		switch r.Status {
+		case StatusFail:
+			c.Failure = &junitMessage{Message: firstLine(r.Message), Text: r.Message}
+			suite.Failures++
+		case StatusSkip:
+			c.Skipped = &junitMessage{Message: r.Message}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, c)
+		suite.Tests++
+		suiteDuration += r.Duration
+	}
+	if suite != nil {
+		suite.Time = junitSeconds(suiteDuration)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(report)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// testName returns the name a result is reported under: the test script and
+// the test function, or the test script alone if it failed as a whole.
+func testName(r Result) string {
+	if r.Name == "" {
+		return r.File
+	}
+	return r.File + ":" + r.Name
+}
+
+// writeIndented writes the lines of text to b, indented by four spaces.
+func writeIndented(b *strings.Builder, text string) {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return
+	}
+	for line := range strings.SplitSeq(text, "\n") {
+		b.WriteString("    ")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+}
+
+// firstLine returns the first line of text.
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return line
+}
+
+// junitSeconds formats d as a number of seconds, as JUnit reports expect.
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// formatSeconds formats d as a number of seconds with millisecond precision.
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
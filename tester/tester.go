@@ -0,0 +1,307 @@
+// Package tester runs the tests of a Ren package.
+//
+// A test script is a Risor script in the source directory whose name ends in
+// "_test", such as lib/math_test.risor. Every top-level function of a test
+// script whose name starts with "test_" is a test. Run builds each test script
+// together with the package's modules, so that it can import them, and runs
+// every test in isolation: each gets a fresh instance of the script and of the
+// modules it imports. A test that takes a parameter is passed the "testing"
+// module, so it can be written as
+//
+//	function test_add(t) {
+//		t.equal(add(1, 2), 3)
+//	}
+package tester
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/deepnoodle-ai/risor/v2/pkg/object"
+
+	"github.com/foohq/ren"
+	modtesting "github.com/foohq/ren/modules/testing"
+	"github.com/foohq/ren/packager"
+)
+
+// testPrefix is the prefix of the names of test functions.
+const testPrefix = "test_"
+
+// Status is the outcome of a test.
+type Status int
+
+// Test outcomes.
+const (
+	StatusPass Status = iota + 1
+	StatusFail
+	StatusSkip
+)
+
+// String returns the name of the status.
+func (s Status) String() string {
+	switch s {
+	case StatusPass:
+		return "pass"
+	case StatusFail:
+		return "fail"
+	case StatusSkip:
+		return "skip"
+	default:
+		return "unknown"
+	}
+}
+
+// Result is the outcome of a single test.
+type Result struct {
+	// File is the path of the test script within the package.
+	File string
+	// Name is the name of the test function. It is empty if the test script
+	// itself could not be built or run, in which case none of its tests ran.
+	Name string
+	// Status is the outcome of the test.
+	Status Status
+	// Duration is how long the test took to run.
+	Duration time.Duration
+	// Message explains why the test failed or was skipped.
+	Message string
+	// Output holds what the test printed and logged.
+	Output string
+}
+
+// Run runs the tests of the package in the source directory src and returns
+// their results, ordered by test script and then by test name. The error is
+// reserved for failures to find the tests; a test script that cannot be built
+// is reported as a failed result.
+func Run(ctx context.Context, src string, opt ...Option) ([]Result, error) {
+	var opts options
+	for _, o := range opt {
+		o(&opts)
+	}
+
+	files, err := findTestFiles(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for _, file := range files {
+		results = append(results, runFile(ctx, src, file, &opts)...)
+	}
+	return results, nil
+}
+
+// findTestFiles returns the package-relative paths of the test scripts in src.
+func findTestFiles(src string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(src, func(pth string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !packager.IsTestFile(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(src, pth)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
+
+// runFile builds the test script file and runs each of its tests.
+func runFile(ctx context.Context, src, file string, opts *options) []Result {
+	start := time.Now()
+	fileFailed := func(err error) []Result {
+		return []Result{{
+			File:     file,
+			Status:   StatusFail,
+			Duration: time.Since(start),
+			Message:  errorMessage(err),
+		}}
+	}
+
+	prog, err := buildFile(ctx, src, file, opts)
+	if err != nil {
+		return fileFailed(err)
+	}
+
+	// Instantiate the script once to find its tests. Each test then runs on an
+	// instance of its own.
+	var output outputFile
+	inst, err := prog.Instantiate(ctx, opts.runOptions(&output)...)
+	if err != nil {
+		return fileFailed(err)
+	}
+
+	var results []Result
+	for _, name := range inst.Exports() {
+		if !strings.HasPrefix(name, testPrefix) || !opts.match(name) {
+			continue
+		}
+		results = append(results, runTest(ctx, prog, file, name, opts))
+	}
+	return results
+}
+
+// buildFile builds the package in src with the test script file as its
+// entrypoint and loads it. The package is built in memory.
+func buildFile(ctx context.Context, src, file string, opts *options) (*ren.Program, error) {
+	buildOpts := append(opts.buildOpts[:len(opts.buildOpts):len(opts.buildOpts)],
+		packager.WithTests(),
+		packager.WithEntrypoint(file),
+	)
+	var buf bytes.Buffer
+	err := packager.BuildFS(ctx, os.DirFS(src), &buf, buildOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ren.Load(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+}
+
+// runTest runs the test function name of the test script file.
+func runTest(ctx context.Context, prog *ren.Program, file, name string, opts *options) Result {
+	t := modtesting.NewT()
+	ctx = modtesting.WithT(ctx, t)
+
+	var output outputFile
+	start := time.Now()
+	err := callTest(ctx, prog, name, opts.runOptions(&output))
+	t.Cleanup()
+
+	result := Result{
+		File:     file,
+		Name:     name,
+		Status:   StatusPass,
+		Duration: time.Since(start),
+	}
+	switch {
+	case t.Skipped():
+		result.Status = StatusSkip
+		result.Message = t.Message()
+	case t.Failed():
+		result.Status = StatusFail
+		result.Message = t.Message()
+	case err != nil:
+		result.Status = StatusFail
+		result.Message = errorMessage(err)
+	}
+
+	var out strings.Builder
+	out.WriteString(output.String())
+	for _, line := range t.Logs() {
+		out.WriteString(line)
+		out.WriteString("\n")
+	}
+	result.Output = out.String()
+
+	return result
+}
+
+// callTest calls the test function name on a new instance of the program.
+func callTest(ctx context.Context, prog *ren.Program, name string, opts []ren.Option) error {
+	inst, err := prog.Instantiate(ctx, opts...)
+	if err != nil {
+		return err
+	}
+
+	var args []any
+	fn, err := inst.Get(name)
+	if err != nil {
+		return err
+	}
+	if closure, ok := fn.(*object.Closure); ok && closure.ParameterCount() > 0 {
+		args = append(args, modtesting.Module())
+	}
+
+	_, err = inst.Call(ctx, name, args...)
+	return err
+}
+
+// errorMessage returns the message reported for an error that made a test
+// fail: the friendly message of a script error, or the error text.
+func errorMessage(err error) string {
+	if renErr, ok := errors.AsType[*ren.Error](err); ok {
+		return strings.TrimSuffix(renErr.FriendlyErrorMessage(), "\n")
+	}
+	return err.Error()
+}
+
+var _ ren.File = (*outputFile)(nil)
+
+// outputFile collects what a test writes to its standard output and error.
+type outputFile struct {
+	bytes.Buffer
+}
+
+func (f *outputFile) Stat() (fs.FileInfo, error) {
+	return nil, fs.ErrInvalid
+}
+
+func (f *outputFile) Read(p []byte) (int, error) {
+	return 0, fs.ErrInvalid
+}
+
+func (f *outputFile) Close() error {
+	return nil
+}
+
+type options struct {
+	buildOpts []packager.Option
+	runOpts   []ren.Option
+	filter    *regexp.Regexp
+}
+
+// runOptions returns the options of a run of a test, which can import the
+// "testing" module and writes its standard output and error to output.
+func (o *options) runOptions(output *outputFile) []ren.Option {
+	opts := append(o.runOpts[:len(o.runOpts):len(o.runOpts)],
+		ren.WithModule(modtesting.Module()),
+		ren.WithStdout(output),
+		ren.WithStderr(output),
+	)
+	return opts
+}
+
+// match reports whether the test function name is selected by the filter.
+func (o *options) match(name string) bool {
+	return o.filter == nil || o.filter.MatchString(name)
+}
+
+// Option configures Run.
+type Option func(*options)
+
+// WithBuildOptions sets the options used to build the test scripts, such as
+// the builtins to treat as pre-declared globals. Every test runs with the
+// "testing" module; if the options list modules with packager.WithModule, they
+// must include it for test scripts to import builtin://testing.
+func WithBuildOptions(opt ...packager.Option) Option {
+	return func(options *options) {
+		options.buildOpts = append(options.buildOpts, opt...)
+	}
+}
+
+// WithRunOptions sets the options every test runs with, such as the builtins
+// and modules available to it. The standard output and error of a test are
+// always captured in its Result.
+func WithRunOptions(opt ...ren.Option) Option {
+	return func(options *options) {
+		options.runOpts = append(options.runOpts, opt...)
+	}
+}
+
+// WithFilter runs only the tests whose function name matches re.
+func WithFilter(re *regexp.Regexp) Option {
+	return func(options *options) {
+		options.filter = re
+	}
+}
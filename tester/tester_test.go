@@ -0,0 +1,144 @@
+package tester_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/foohq/ren"
+	"github.com/foohq/ren/builtins"
+	"github.com/foohq/ren/packager"
+	"github.com/foohq/ren/tester"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		pth := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755))
+		require.NoError(t, os.WriteFile(pth, []byte(content), 0644))
+	}
+	return dir
+}
+
+func options() []tester.Option {
+	var buildOpts []packager.Option
+	var runOpts []ren.Option
+	for _, builtin := range builtins.Builtins() {
+		buildOpts = append(buildOpts, packager.WithBuiltin(builtin))
+		runOpts = append(runOpts, ren.WithBuiltin(builtin))
+	}
+	return []tester.Option{
+		tester.WithBuildOptions(buildOpts...),
+		tester.WithRunOptions(runOpts...),
+	}
+}
+
+func TestRun(t *testing.T) {
+	src := writeFiles(t, map[string]string{
+		"entrypoint.risor": `print("entrypoint must not run")` + "\n",
+		"lib/math.risor":   "function add(a, b) {\n  return a + b\n}\n",
+		"lib/math_test.risor": `const math = import("lib/math")
+const testing = import("builtin://testing")
+let calls = 0
+
+function test_add(t) {
+  calls = calls + 1
+  t.equal(math.add(1, 2), 3)
+  t.equal(calls, 1, "tests run in isolation")
+}
+
+function test_fail() {
+  print("some output")
+  testing.equal(math.add(1, 2), 4, "add")
+}
+
+function test_skip(t) {
+  t.skip("not yet")
+}
+
+function test_error(t) {
+  const x = 1
+  x()
+}
+
+function helper() {
+  return 1
+}
+`,
+	})
+
+	results, err := tester.Run(context.Background(), src, options()...)
+	require.NoError(t, err)
+
+	statuses := make(map[string]tester.Status)
+	for _, r := range results {
+		require.Equal(t, "lib/math_test.risor", r.File)
+		statuses[r.Name] = r.Status
+	}
+	require.Equal(t, map[string]tester.Status{
+		"test_add":   tester.StatusPass,
+		"test_error": tester.StatusFail,
+		"test_fail":  tester.StatusFail,
+		"test_skip":  tester.StatusSkip,
+	}, statuses)
+
+	for _, r := range results {
+		switch r.Name {
+		case "test_fail":
+			require.Equal(t, "add: got 3, want 4", r.Message)
+			require.Equal(t, "some output\n", r.Output)
+		case "test_skip":
+			require.Equal(t, "not yet", r.Message)
+		case "test_error":
+			require.Contains(t, r.Message, "lib/math_test.risor:22:3")
+		}
+	}
+
+	t.Run("filter", func(t *testing.T) {
+		opts := append(options(), tester.WithFilter(regexp.MustCompile("add")))
+		results, err := tester.Run(context.Background(), src, opts...)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Equal(t, "test_add", results[0].Name)
+	})
+
+	t.Run("reports", func(t *testing.T) {
+		var text bytes.Buffer
+		require.NoError(t, tester.WriteText(&text, results, false))
+		require.Contains(t, text.String(), "--- FAIL: lib/math_test.risor:test_fail (")
+		require.NotContains(t, text.String(), "test_add")
+		require.Contains(t, text.String(), "FAIL: 1 passed, 2 failed, 1 skipped (")
+
+		var junit bytes.Buffer
+		require.NoError(t, tester.WriteJUnit(&junit, results))
+		var report struct {
+			Tests    int `xml:"tests,attr"`
+			Failures int `xml:"failures,attr"`
+		}
+		require.NoError(t, xml.Unmarshal(junit.Bytes(), &report))
+		require.Equal(t, 4, report.Tests)
+		require.Equal(t, 2, report.Failures)
+	})
+}
+
+func TestRunBuildError(t *testing.T) {
+	src := writeFiles(t, map[string]string{
+		"entrypoint.risor": "print(1)\n",
+		"bad_test.risor":   "function test_x( {\n",
+	})
+
+	results, err := tester.Run(context.Background(), src, options()...)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, "bad_test.risor", results[0].File)
+	require.Empty(t, results[0].Name)
+	require.Equal(t, tester.StatusFail, results[0].Status)
+}
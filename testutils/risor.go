@@ -185,6 +185,11 @@ func (m *MockOS) Stdout() ren.File {
 	return args.Get(0).(ren.File)
 }
 
+func (m *MockOS) Stderr() ren.File {
+	args := m.Called()
+	return args.Get(0).(ren.File)
+}
+
 func (m *MockOS) CurrentUser() (ren.User, error) {
 	args := m.Called()
 	return args.Get(0).(ren.User), args.Error(1)